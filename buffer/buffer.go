@@ -5,15 +5,28 @@ package buffer
 
 import (
 	"encoding/binary"
+	"errors"
 	"github.com/sumory/gotty/utils"
 	"io"
 	"math"
 	"unicode/utf8"
 )
 
+//ReadFrom每次读取至少预留的空间
+const minReadFromSize = 512
+
+// Errors
+var (
+	IndexOutOfRangeError = errors.New("Index out of range")
+	VarintOverflowError  = errors.New("Varint overflows a 64-bit integer")
+)
+
 type Buffer struct {
 	Data    []byte
 	ReadPos int
+
+	refs int32 //引用计数，降为0时归还到pool
+	pool *Pool //来源的池，nil表示非池化
 }
 
 func NewBuffer(size, capacity int) *Buffer {
@@ -24,6 +37,7 @@ func newBuffer(data []byte) *Buffer {
 	return &Buffer{
 		Data:    data,
 		ReadPos: 0,
+		refs:    1,
 	}
 }
 
@@ -35,9 +49,11 @@ func (b *Buffer) Reset(size, capacity int) {
 	b.Data = b.Data[:size]
 }
 
+//free 引用计数降为0时调用，池化的buffer归还到池中
 func (b *Buffer) free() {
-	//fmt.Println("buffer free...")
-	//TODO:连接池后续处理
+	if b.pool != nil {
+		b.pool.put(b)
+	}
 }
 
 func (b *Buffer) grows(n int) (i int) {
@@ -192,9 +208,54 @@ func (b *Buffer) WriteFloat64LE(v float64) {
 
 // io.Reader
 func (b *Buffer) Read(p []byte) (int, error) {
-	n, err := b.ReadAt(p, int64(b.ReadPos))
+	if b.ReadPos >= len(b.Data) {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, b.Data[b.ReadPos:])
+	b.ReadPos += n
+	return n, nil
+}
+
+// io.ReaderFrom，读到r结束为止，追加在Data后面
+func (b *Buffer) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		if cap(b.Data)-len(b.Data) < minReadFromSize {
+			b.grows(minReadFromSize)
+			b.Data = b.Data[:len(b.Data)-minReadFromSize]
+		}
+		n, err := r.Read(b.Data[len(b.Data):cap(b.Data)])
+		b.Data = b.Data[:len(b.Data)+n]
+		total += int64(n)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// io.WriterTo，写出未读的部分
+func (b *Buffer) WriteTo(w io.Writer) (int64, error) {
+	remaining := b.Remaining()
+	if remaining <= 0 {
+		return 0, nil
+	}
+	n, err := w.Write(b.Data[b.ReadPos:])
 	b.ReadPos += n
-	return n, err
+	if err == nil && n < remaining {
+		err = io.ErrShortWrite
+	}
+	return int64(n), err
+}
+
+//Remaining 未读的字节数
+func (b *Buffer) Remaining() int {
+	return len(b.Data) - b.ReadPos
 }
 
 // io.ByteReader
@@ -207,14 +268,16 @@ func (b *Buffer) ReadByte() (byte, error) {
 
 // io.ReaderAt
 func (b *Buffer) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, IndexOutOfRangeError
+	}
 	if int(off) >= len(b.Data) {
 		return 0, io.EOF
 	}
-	n := len(p)
-	if n+int(off) > len(b.Data) {
-		n = len(b.Data) - int(off)
+	n := copy(p, b.Data[off:])
+	if n < len(p) {
+		return n, io.EOF
 	}
-	copy(p, b.Data[off:])
 	return n, nil
 }
 
@@ -224,6 +287,9 @@ func (b *Buffer) ReadSlice(start int, step int) []byte {
 
 // io.RuneReader
 func (b *Buffer) ReadRune() (rune, int, error) {
+	if b.ReadPos >= len(b.Data) {
+		return 0, 0, io.EOF
+	}
 	r, n := utf8.DecodeRune(b.Data[b.ReadPos:])
 	b.ReadPos += n
 	return r, n, nil
@@ -0,0 +1,435 @@
+package buffer
+
+import (
+	"encoding/binary"
+	"github.com/sumory/gotty/utils"
+	"io"
+)
+
+//ByteBuf 带独立读写索引的buffer，参考Netty的ByteBuf
+//0 <= readerIndex <= writerIndex <= Capacity()，[readerIndex, writerIndex)为可读部分，之后为可写部分
+//Get*按绝对位置读取，Peek*从readerIndex读取但不移动索引，Read*读取并移动readerIndex
+//越界访问会以IndexOutOfRangeError panic，解析不完整的帧时先用ReadableBytes判断长度
+type ByteBuf struct {
+	data         []byte
+	readerIndex  int
+	writerIndex  int
+	markedReader int
+	markedWriter int
+}
+
+//NewByteBuf 新建容量为capacity的ByteBuf，写入超过容量时自动扩容
+func NewByteBuf(capacity int) *ByteBuf {
+	return &ByteBuf{data: make([]byte, capacity)}
+}
+
+//WrapByteBuf 包装已有数据，不复制，data全部可读，写入超出len(data)时扩容复制，不会覆盖data之后调用方的数据
+func WrapByteBuf(data []byte) *ByteBuf {
+	return &ByteBuf{data: data[:len(data):len(data)], writerIndex: len(data)}
+}
+
+func (b *ByteBuf) check(index, n int) {
+	if index < 0 || n < 0 || index+n > b.writerIndex {
+		panic(IndexOutOfRangeError)
+	}
+}
+
+//Capacity 当前容量
+func (b *ByteBuf) Capacity() int {
+	return len(b.data)
+}
+
+//ReaderIndex 读索引
+func (b *ByteBuf) ReaderIndex() int {
+	return b.readerIndex
+}
+
+//WriterIndex 写索引
+func (b *ByteBuf) WriterIndex() int {
+	return b.writerIndex
+}
+
+//SetReaderIndex 设置读索引，需在0和writerIndex之间
+func (b *ByteBuf) SetReaderIndex(i int) {
+	if i < 0 || i > b.writerIndex {
+		panic(IndexOutOfRangeError)
+	}
+	b.readerIndex = i
+}
+
+//SetWriterIndex 设置写索引，需在readerIndex和容量之间
+func (b *ByteBuf) SetWriterIndex(i int) {
+	if i < b.readerIndex || i > len(b.data) {
+		panic(IndexOutOfRangeError)
+	}
+	b.writerIndex = i
+}
+
+//ReadableBytes 可读字节数
+func (b *ByteBuf) ReadableBytes() int {
+	return b.writerIndex - b.readerIndex
+}
+
+//WritableBytes 不扩容时可写的字节数
+func (b *ByteBuf) WritableBytes() int {
+	return len(b.data) - b.writerIndex
+}
+
+//IsReadable 是否至少有n个字节可读
+func (b *ByteBuf) IsReadable(n int) bool {
+	return b.ReadableBytes() >= n
+}
+
+//MarkReaderIndex 记录当前读索引
+func (b *ByteBuf) MarkReaderIndex() {
+	b.markedReader = b.readerIndex
+}
+
+//ResetReaderIndex 读索引回到上次Mark的位置，常用于数据不足一帧时回退
+func (b *ByteBuf) ResetReaderIndex() {
+	b.SetReaderIndex(b.markedReader)
+}
+
+//MarkWriterIndex 记录当前写索引
+func (b *ByteBuf) MarkWriterIndex() {
+	b.markedWriter = b.writerIndex
+}
+
+//ResetWriterIndex 写索引回到上次Mark的位置
+func (b *ByteBuf) ResetWriterIndex() {
+	b.SetWriterIndex(b.markedWriter)
+}
+
+//Clear 读写索引归零，不清除数据
+func (b *ByteBuf) Clear() {
+	b.readerIndex, b.writerIndex = 0, 0
+	b.markedReader, b.markedWriter = 0, 0
+}
+
+//Bytes 可读部分，与ByteBuf共享数据
+func (b *ByteBuf) Bytes() []byte {
+	return b.data[b.readerIndex:b.writerIndex]
+}
+
+//DiscardReadBytes 丢弃已读部分，将可读部分移到开头以腾出写空间
+func (b *ByteBuf) DiscardReadBytes() {
+	if b.readerIndex == 0 {
+		return
+	}
+	n := copy(b.data, b.data[b.readerIndex:b.writerIndex])
+	b.markedReader = discardMark(b.markedReader, b.readerIndex)
+	b.markedWriter = discardMark(b.markedWriter, b.readerIndex)
+	b.readerIndex, b.writerIndex = 0, n
+}
+
+func discardMark(mark, discarded int) int {
+	if mark < discarded {
+		return 0
+	}
+	return mark - discarded
+}
+
+//EnsureWritable 确保至少可写n个字节，扩容后与之前的Slice和Duplicate不再共享数据
+func (b *ByteBuf) EnsureWritable(n int) {
+	if b.WritableBytes() >= n {
+		return
+	}
+	newCap := len(b.data)*2 + n
+	data := make([]byte, newCap)
+	copy(data, b.data[:b.writerIndex])
+	b.data = data
+}
+
+//Slice 返回[index, index+length)的视图，与原ByteBuf共享数据但索引独立
+//视图的容量即为length，对其写入会扩容为独立数据，不会覆盖原ByteBuf后面的内容
+func (b *ByteBuf) Slice(index, length int) *ByteBuf {
+	b.check(index, length)
+	return &ByteBuf{data: b.data[index : index+length : index+length], writerIndex: length}
+}
+
+//ReadSlice 返回接下来n个字节的视图并移动读索引
+func (b *ByteBuf) ReadSlice(n int) *ByteBuf {
+	s := b.Slice(b.readerIndex, n)
+	b.readerIndex += n
+	return s
+}
+
+//Duplicate 返回共享全部数据、索引独立的副本
+func (b *ByteBuf) Duplicate() *ByteBuf {
+	d := *b
+	return &d
+}
+
+//Copy 复制可读部分到新的ByteBuf
+func (b *ByteBuf) Copy() *ByteBuf {
+	data := make([]byte, b.ReadableBytes())
+	copy(data, b.Bytes())
+	return WrapByteBuf(data)
+}
+
+//Skip 跳过n个字节
+func (b *ByteBuf) Skip(n int) {
+	b.check(b.readerIndex, n)
+	b.readerIndex += n
+}
+
+//IndexOf 从读索引开始查找c，返回绝对位置，未找到返回-1
+func (b *ByteBuf) IndexOf(c byte) int {
+	for i := b.readerIndex; i < b.writerIndex; i++ {
+		if b.data[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+//GetBytes 读取index处的n个字节，与ByteBuf共享数据
+func (b *ByteBuf) GetBytes(index, n int) []byte {
+	b.check(index, n)
+	return b.data[index : index+n]
+}
+
+//Peek 不移动读索引地查看接下来n个字节，与ByteBuf共享数据
+func (b *ByteBuf) Peek(n int) []byte {
+	return b.GetBytes(b.readerIndex, n)
+}
+
+//ReadBytes 读取n个字节，与ByteBuf共享数据
+func (b *ByteBuf) ReadBytes(n int) []byte {
+	p := b.Peek(n)
+	b.readerIndex += n
+	return p
+}
+
+// io.Reader
+func (b *ByteBuf) Read(p []byte) (int, error) {
+	if b.ReadableBytes() == 0 {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, b.Bytes())
+	b.readerIndex += n
+	return n, nil
+}
+
+// io.ByteReader
+func (b *ByteBuf) ReadByte() (byte, error) {
+	if b.ReadableBytes() == 0 {
+		return 0, io.EOF
+	}
+	c := b.data[b.readerIndex]
+	b.readerIndex++
+	return c, nil
+}
+
+// io.Writer
+func (b *ByteBuf) Write(p []byte) (int, error) {
+	b.WriteBytes(p)
+	return len(p), nil
+}
+
+// io.ByteWriter
+func (b *ByteBuf) WriteByte(c byte) error {
+	b.WriteUint8(c)
+	return nil
+}
+
+func (b *ByteBuf) WriteBytes(p []byte) {
+	i := b.grows(len(p))
+	copy(b.data[i:], p)
+}
+
+func (b *ByteBuf) WriteString(s string) {
+	i := b.grows(len(s))
+	copy(b.data[i:], s)
+}
+
+//grows 预留n个字节并移动写索引，返回写入位置
+func (b *ByteBuf) grows(n int) int {
+	b.EnsureWritable(n)
+	i := b.writerIndex
+	b.writerIndex += n
+	return i
+}
+
+func (b *ByteBuf) GetUint8(index int) uint8 {
+	b.check(index, 1)
+	return b.data[index]
+}
+
+func (b *ByteBuf) PeekUint8() uint8 {
+	return b.GetUint8(b.readerIndex)
+}
+
+func (b *ByteBuf) ReadUint8() uint8 {
+	v := b.PeekUint8()
+	b.readerIndex++
+	return v
+}
+
+func (b *ByteBuf) WriteUint8(v uint8) {
+	i := b.grows(1)
+	b.data[i] = v
+}
+
+func (b *ByteBuf) GetUint16BE(index int) uint16 {
+	b.check(index, 2)
+	return binary.BigEndian.Uint16(b.data[index:])
+}
+
+func (b *ByteBuf) PeekUint16BE() uint16 {
+	return b.GetUint16BE(b.readerIndex)
+}
+
+func (b *ByteBuf) ReadUint16BE() uint16 {
+	v := b.PeekUint16BE()
+	b.readerIndex += 2
+	return v
+}
+
+func (b *ByteBuf) WriteUint16BE(v uint16) {
+	i := b.grows(2)
+	binary.BigEndian.PutUint16(b.data[i:], v)
+}
+
+func (b *ByteBuf) GetUint16LE(index int) uint16 {
+	b.check(index, 2)
+	return binary.LittleEndian.Uint16(b.data[index:])
+}
+
+func (b *ByteBuf) PeekUint16LE() uint16 {
+	return b.GetUint16LE(b.readerIndex)
+}
+
+func (b *ByteBuf) ReadUint16LE() uint16 {
+	v := b.PeekUint16LE()
+	b.readerIndex += 2
+	return v
+}
+
+func (b *ByteBuf) WriteUint16LE(v uint16) {
+	i := b.grows(2)
+	binary.LittleEndian.PutUint16(b.data[i:], v)
+}
+
+func (b *ByteBuf) GetUint24BE(index int) uint32 {
+	b.check(index, 3)
+	return utils.GetUint24BE(b.data[index:])
+}
+
+func (b *ByteBuf) PeekUint24BE() uint32 {
+	return b.GetUint24BE(b.readerIndex)
+}
+
+func (b *ByteBuf) ReadUint24BE() uint32 {
+	v := b.PeekUint24BE()
+	b.readerIndex += 3
+	return v
+}
+
+func (b *ByteBuf) WriteUint24BE(v uint32) {
+	i := b.grows(3)
+	utils.PutUint24BE(b.data[i:], v)
+}
+
+func (b *ByteBuf) GetUint24LE(index int) uint32 {
+	b.check(index, 3)
+	return utils.GetUint24LE(b.data[index:])
+}
+
+func (b *ByteBuf) PeekUint24LE() uint32 {
+	return b.GetUint24LE(b.readerIndex)
+}
+
+func (b *ByteBuf) ReadUint24LE() uint32 {
+	v := b.PeekUint24LE()
+	b.readerIndex += 3
+	return v
+}
+
+func (b *ByteBuf) WriteUint24LE(v uint32) {
+	i := b.grows(3)
+	utils.PutUint24LE(b.data[i:], v)
+}
+
+func (b *ByteBuf) GetUint32BE(index int) uint32 {
+	b.check(index, 4)
+	return binary.BigEndian.Uint32(b.data[index:])
+}
+
+func (b *ByteBuf) PeekUint32BE() uint32 {
+	return b.GetUint32BE(b.readerIndex)
+}
+
+func (b *ByteBuf) ReadUint32BE() uint32 {
+	v := b.PeekUint32BE()
+	b.readerIndex += 4
+	return v
+}
+
+func (b *ByteBuf) WriteUint32BE(v uint32) {
+	i := b.grows(4)
+	binary.BigEndian.PutUint32(b.data[i:], v)
+}
+
+func (b *ByteBuf) GetUint32LE(index int) uint32 {
+	b.check(index, 4)
+	return binary.LittleEndian.Uint32(b.data[index:])
+}
+
+func (b *ByteBuf) PeekUint32LE() uint32 {
+	return b.GetUint32LE(b.readerIndex)
+}
+
+func (b *ByteBuf) ReadUint32LE() uint32 {
+	v := b.PeekUint32LE()
+	b.readerIndex += 4
+	return v
+}
+
+func (b *ByteBuf) WriteUint32LE(v uint32) {
+	i := b.grows(4)
+	binary.LittleEndian.PutUint32(b.data[i:], v)
+}
+
+func (b *ByteBuf) GetUint64BE(index int) uint64 {
+	b.check(index, 8)
+	return binary.BigEndian.Uint64(b.data[index:])
+}
+
+func (b *ByteBuf) PeekUint64BE() uint64 {
+	return b.GetUint64BE(b.readerIndex)
+}
+
+func (b *ByteBuf) ReadUint64BE() uint64 {
+	v := b.PeekUint64BE()
+	b.readerIndex += 8
+	return v
+}
+
+func (b *ByteBuf) WriteUint64BE(v uint64) {
+	i := b.grows(8)
+	binary.BigEndian.PutUint64(b.data[i:], v)
+}
+
+func (b *ByteBuf) GetUint64LE(index int) uint64 {
+	b.check(index, 8)
+	return binary.LittleEndian.Uint64(b.data[index:])
+}
+
+func (b *ByteBuf) PeekUint64LE() uint64 {
+	return b.GetUint64LE(b.readerIndex)
+}
+
+func (b *ByteBuf) ReadUint64LE() uint64 {
+	v := b.PeekUint64LE()
+	b.readerIndex += 8
+	return v
+}
+
+func (b *ByteBuf) WriteUint64LE(v uint64) {
+	i := b.grows(8)
+	binary.LittleEndian.PutUint64(b.data[i:], v)
+}
@@ -0,0 +1,167 @@
+package buffer
+
+import (
+	convey "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_ByteBuf(t *testing.T) {
+	convey.Convey("Reader and writer indexes should move independently", t, func() {
+		b := NewByteBuf(4)
+		b.WriteUint32BE(0x12345678)
+		b.WriteUint16LE(0x1234)
+		b.WriteUint24BE(0x123456)
+		b.WriteUint64LE(0x12345678AABBCCDD)
+		b.WriteString("hello")
+		convey.So(b.WriterIndex(), convey.ShouldEqual, 22)
+		convey.So(b.Capacity(), convey.ShouldBeGreaterThanOrEqualTo, 22)
+
+		convey.So(b.PeekUint32BE(), convey.ShouldEqual, 0x12345678)
+		convey.So(b.ReaderIndex(), convey.ShouldEqual, 0)
+		convey.So(b.ReadUint32BE(), convey.ShouldEqual, 0x12345678)
+		convey.So(b.GetUint16LE(4), convey.ShouldEqual, 0x1234)
+
+		b.MarkReaderIndex()
+		convey.So(b.ReadUint16LE(), convey.ShouldEqual, 0x1234)
+		convey.So(b.ReadUint24BE(), convey.ShouldEqual, 0x123456)
+		b.ResetReaderIndex()
+		convey.So(b.ReaderIndex(), convey.ShouldEqual, 4)
+		b.Skip(5)
+		convey.So(b.ReadUint64LE(), convey.ShouldEqual, uint64(0x12345678AABBCCDD))
+		convey.So(string(b.Peek(5)), convey.ShouldEqual, "hello")
+		convey.So(b.IndexOf('l'), convey.ShouldEqual, 19)
+		convey.So(string(b.ReadBytes(5)), convey.ShouldEqual, "hello")
+		convey.So(b.ReadableBytes(), convey.ShouldEqual, 0)
+
+		_, err := b.ReadByte()
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(func() { b.ReadUint8() }, convey.ShouldPanicWith, IndexOutOfRangeError)
+		convey.So(func() { b.SetReaderIndex(23) }, convey.ShouldPanicWith, IndexOutOfRangeError)
+	})
+
+	convey.Convey("Writer mark should roll back a partially written frame", t, func() {
+		b := NewByteBuf(16)
+		b.WriteUint8(1)
+		b.MarkWriterIndex()
+		b.WriteUint32BE(100)
+		b.ResetWriterIndex()
+		convey.So(b.ReadableBytes(), convey.ShouldEqual, 1)
+	})
+
+	convey.Convey("Slice and Duplicate should share data with independent indexes", t, func() {
+		b := WrapByteBuf([]byte("0123456789"))
+		s := b.Slice(2, 4)
+		convey.So(string(s.Bytes()), convey.ShouldEqual, "2345")
+		s.Bytes()[0] = 'x'
+		convey.So(string(b.Bytes()), convey.ShouldEqual, "01x3456789")
+
+		//写入slice不能覆盖原buffer后面的数据
+		s.WriteUint8('y')
+		convey.So(string(b.Bytes()), convey.ShouldEqual, "01x3456789")
+		convey.So(string(s.Bytes()), convey.ShouldEqual, "x345y")
+
+		d := b.Duplicate()
+		d.Skip(3)
+		convey.So(b.ReaderIndex(), convey.ShouldEqual, 0)
+		convey.So(string(d.ReadSlice(2).Bytes()), convey.ShouldEqual, "34")
+		convey.So(d.ReaderIndex(), convey.ShouldEqual, 5)
+
+		c := b.Copy()
+		c.Bytes()[0] = 'z'
+		convey.So(string(b.Peek(1)), convey.ShouldEqual, "0")
+		convey.So(func() { b.Slice(8, 3) }, convey.ShouldPanicWith, IndexOutOfRangeError)
+	})
+
+	convey.Convey("Writes to a wrapped buffer should not touch the caller's spare capacity", t, func() {
+		data := []byte("0123456789")
+		b := WrapByteBuf(data[:4])
+		b.WriteString("xy")
+		convey.So(string(b.Bytes()), convey.ShouldEqual, "0123xy")
+		convey.So(string(data), convey.ShouldEqual, "0123456789")
+	})
+
+	convey.Convey("DiscardReadBytes should compact the readable part", t, func() {
+		b := NewByteBuf(8)
+		b.WriteString("abcdefgh")
+		b.Skip(3)
+		b.MarkReaderIndex()
+		b.Skip(2)
+		b.DiscardReadBytes()
+		convey.So(b.ReaderIndex(), convey.ShouldEqual, 0)
+		convey.So(string(b.Bytes()), convey.ShouldEqual, "fgh")
+		convey.So(b.WritableBytes(), convey.ShouldEqual, 5)
+		b.ResetReaderIndex()
+		convey.So(string(b.Bytes()), convey.ShouldEqual, "fgh")
+	})
+}
+
+func Test_CompositeByteBuf(t *testing.T) {
+	convey.Convey("Composite buffer should read across components", t, func() {
+		first := NewByteBuf(8)
+		first.WriteUint16BE(0x0102)
+		first.WriteUint8(0x03)
+		c := NewCompositeByteBuf(first, WrapByteBuf([]byte{0x04, 0x05}))
+		c.AddBytes(nil)
+		c.AddBytes([]byte("hello world"))
+		convey.So(c.NumComponents(), convey.ShouldEqual, 3)
+		convey.So(c.ReadableBytes(), convey.ShouldEqual, 16)
+
+		convey.So(c.PeekUint32BE(), convey.ShouldEqual, 0x01020304)
+		convey.So(c.GetUint16LE(3), convey.ShouldEqual, 0x0504)
+		convey.So(c.ReadUint8(), convey.ShouldEqual, 0x01)
+		convey.So(c.ReadUint24BE(), convey.ShouldEqual, 0x020304)
+		convey.So(c.IndexOf(' '), convey.ShouldEqual, 10)
+
+		c.MarkReaderIndex()
+		convey.So(string(c.ReadBytes(3)), convey.ShouldEqual, "\x05he")
+		c.ResetReaderIndex()
+		c.Skip(1)
+		s := c.ReadSlice(5)
+		convey.So(string(s.Bytes()), convey.ShouldEqual, "hello")
+		//同一段内的slice不复制
+		s.Bytes()[0] = 'H'
+		convey.So(string(c.GetBytes(5, 5)), convey.ShouldEqual, "Hello")
+
+		c.DiscardReadComponents()
+		convey.So(c.NumComponents(), convey.ShouldEqual, 1)
+		convey.So(c.ReaderIndex(), convey.ShouldEqual, 5)
+		convey.So(string(c.Consolidate().Bytes()), convey.ShouldEqual, " world")
+
+		rest, err := ioutil.ReadAll(c)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(string(rest), convey.ShouldEqual, " world")
+		convey.So(func() { c.ReadUint8() }, convey.ShouldPanicWith, IndexOutOfRangeError)
+	})
+
+	convey.Convey("Frames should be parsed incrementally as chunks arrive", t, func() {
+		frame := NewByteBuf(16)
+		frame.WriteUint16BE(5)
+		frame.WriteString("abcde")
+		frame.WriteUint16BE(3)
+		frame.WriteString("xyz")
+		data := frame.Bytes()
+
+		var frames []string
+		c := NewCompositeByteBuf()
+		for i := 0; i < len(data); i += 3 {
+			end := i + 3
+			if end > len(data) {
+				end = len(data)
+			}
+			c.AddBytes(data[i:end])
+			for c.IsReadable(2) {
+				c.MarkReaderIndex()
+				n := int(c.ReadUint16BE())
+				if !c.IsReadable(n) {
+					c.ResetReaderIndex()
+					break
+				}
+				frames = append(frames, string(c.ReadBytes(n)))
+			}
+			c.DiscardReadComponents()
+		}
+		convey.So(frames, convey.ShouldResemble, []string{"abcde", "xyz"})
+		convey.So(c.ReadableBytes(), convey.ShouldEqual, 0)
+	})
+}
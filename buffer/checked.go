@@ -0,0 +1,419 @@
+package buffer
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+//need 检查是否还有n个字节可读
+func (b *Buffer) need(n int) error {
+	if n < 0 || b.ReadPos < 0 || b.ReadPos+n > len(b.Data) {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+//TryReadBytes 带长度检查的ReadBytes，数据不足时返回io.ErrUnexpectedEOF且不移动读位置
+//TryRead*系列与Read*相同，只是不会因输入过短而panic，适合解析不可信的数据
+func (b *Buffer) TryReadBytes(n int) ([]byte, error) {
+	if err := b.need(n); err != nil {
+		return nil, err
+	}
+	return b.ReadBytes(n), nil
+}
+
+func (b *Buffer) TryReadString(n int) (string, error) {
+	if err := b.need(n); err != nil {
+		return "", err
+	}
+	return b.ReadString(n), nil
+}
+
+func (b *Buffer) TryReadVarint() (int64, error) {
+	if err := b.need(0); err != nil {
+		return 0, err
+	}
+	r, n := binary.Varint(b.Data[b.ReadPos:])
+	return r, b.advanceVarint(n)
+}
+
+func (b *Buffer) TryReadUvarint() (uint64, error) {
+	if err := b.need(0); err != nil {
+		return 0, err
+	}
+	r, n := binary.Uvarint(b.Data[b.ReadPos:])
+	return r, b.advanceVarint(n)
+}
+
+//advanceVarint 按binary.Uvarint的返回值移动读位置，n为0表示数据不足，小于0表示溢出
+func (b *Buffer) advanceVarint(n int) error {
+	if n == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	if n < 0 {
+		return VarintOverflowError
+	}
+	b.ReadPos += n
+	return nil
+}
+
+func (b *Buffer) TryReadUint8() (uint8, error) {
+	if err := b.need(1); err != nil {
+		return 0, err
+	}
+	return b.ReadUint8(), nil
+}
+
+func (b *Buffer) TryReadUint16BE() (uint16, error) {
+	if err := b.need(2); err != nil {
+		return 0, err
+	}
+	return b.ReadUint16BE(), nil
+}
+
+func (b *Buffer) TryReadUint16LE() (uint16, error) {
+	if err := b.need(2); err != nil {
+		return 0, err
+	}
+	return b.ReadUint16LE(), nil
+}
+
+func (b *Buffer) TryReadUint24BE() (uint32, error) {
+	if err := b.need(3); err != nil {
+		return 0, err
+	}
+	return b.ReadUint24BE(), nil
+}
+
+func (b *Buffer) TryReadUint24LE() (uint32, error) {
+	if err := b.need(3); err != nil {
+		return 0, err
+	}
+	return b.ReadUint24LE(), nil
+}
+
+func (b *Buffer) TryReadUint32BE() (uint32, error) {
+	if err := b.need(4); err != nil {
+		return 0, err
+	}
+	return b.ReadUint32BE(), nil
+}
+
+func (b *Buffer) TryReadUint32LE() (uint32, error) {
+	if err := b.need(4); err != nil {
+		return 0, err
+	}
+	return b.ReadUint32LE(), nil
+}
+
+func (b *Buffer) TryReadUint40BE() (uint64, error) {
+	if err := b.need(5); err != nil {
+		return 0, err
+	}
+	return b.ReadUint40BE(), nil
+}
+
+func (b *Buffer) TryReadUint40LE() (uint64, error) {
+	if err := b.need(5); err != nil {
+		return 0, err
+	}
+	return b.ReadUint40LE(), nil
+}
+
+func (b *Buffer) TryReadUint48BE() (uint64, error) {
+	if err := b.need(6); err != nil {
+		return 0, err
+	}
+	return b.ReadUint48BE(), nil
+}
+
+func (b *Buffer) TryReadUint48LE() (uint64, error) {
+	if err := b.need(6); err != nil {
+		return 0, err
+	}
+	return b.ReadUint48LE(), nil
+}
+
+func (b *Buffer) TryReadUint56BE() (uint64, error) {
+	if err := b.need(7); err != nil {
+		return 0, err
+	}
+	return b.ReadUint56BE(), nil
+}
+
+func (b *Buffer) TryReadUint56LE() (uint64, error) {
+	if err := b.need(7); err != nil {
+		return 0, err
+	}
+	return b.ReadUint56LE(), nil
+}
+
+func (b *Buffer) TryReadUint64BE() (uint64, error) {
+	if err := b.need(8); err != nil {
+		return 0, err
+	}
+	return b.ReadUint64BE(), nil
+}
+
+func (b *Buffer) TryReadUint64LE() (uint64, error) {
+	if err := b.need(8); err != nil {
+		return 0, err
+	}
+	return b.ReadUint64LE(), nil
+}
+
+func (b *Buffer) TryReadFloat32BE() (float32, error) {
+	if err := b.need(4); err != nil {
+		return 0, err
+	}
+	return b.ReadFloat32BE(), nil
+}
+
+func (b *Buffer) TryReadFloat32LE() (float32, error) {
+	if err := b.need(4); err != nil {
+		return 0, err
+	}
+	return b.ReadFloat32LE(), nil
+}
+
+func (b *Buffer) TryReadFloat64BE() (float64, error) {
+	if err := b.need(8); err != nil {
+		return 0, err
+	}
+	return b.ReadFloat64BE(), nil
+}
+
+func (b *Buffer) TryReadFloat64LE() (float64, error) {
+	if err := b.need(8); err != nil {
+		return 0, err
+	}
+	return b.ReadFloat64LE(), nil
+}
+
+//StickyReader 粘滞错误模式的读取器，第一次读取失败后记录错误，之后的读取都返回零值
+//连续解析多个字段后只需检查一次Err
+//	r := b.Sticky()
+//	seq := r.ReadUint32BE()
+//	op := r.ReadUint16BE()
+//	if r.Err() != nil { ... }
+type StickyReader struct {
+	b   *Buffer
+	err error
+}
+
+//Sticky 返回以粘滞错误模式读取b的读取器，读位置与b共享
+func (b *Buffer) Sticky() *StickyReader {
+	return &StickyReader{b: b}
+}
+
+//Err 第一次读取失败的错误
+func (r *StickyReader) Err() error {
+	return r.err
+}
+
+func (r *StickyReader) ReadBytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	v, err := r.b.TryReadBytes(n)
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadString(n int) string {
+	if r.err != nil {
+		return ""
+	}
+	v, err := r.b.TryReadString(n)
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadVarint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadVarint()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUvarint()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint8() uint8 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint8()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint16BE() uint16 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint16BE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint16LE() uint16 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint16LE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint24BE() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint24BE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint24LE() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint24LE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint32BE() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint32BE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint32LE() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint32LE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint40BE() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint40BE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint40LE() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint40LE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint48BE() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint48BE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint48LE() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint48LE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint56BE() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint56BE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint56LE() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint56LE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint64BE() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint64BE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadUint64LE() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadUint64LE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadFloat32BE() float32 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadFloat32BE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadFloat32LE() float32 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadFloat32LE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadFloat64BE() float64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadFloat64BE()
+	r.err = err
+	return v
+}
+
+func (r *StickyReader) ReadFloat64LE() float64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.b.TryReadFloat64LE()
+	r.err = err
+	return v
+}
@@ -0,0 +1,147 @@
+package buffer
+
+import (
+	"bytes"
+	convey "github.com/smartystreets/goconvey/convey"
+	"github.com/sumory/gotty/utils"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+//verifySticky 按PrepareBuffer的顺序读取，返回读取失败的错误
+func verifySticky(buffer *Buffer) error {
+	r := buffer.Sticky()
+	ok := r.ReadVarint() == 0x12345678AABBCCDD
+	ok = r.ReadUvarint() == 0x12345678AABBCCDD && ok
+	ok = r.ReadUint8() == 0x12 && ok
+	ok = r.ReadUint16LE() == 0x1234 && ok
+	ok = r.ReadUint16BE() == 0x1234 && ok
+	ok = r.ReadUint24LE() == 0x123456 && ok
+	ok = r.ReadUint24BE() == 0x123456 && ok
+	ok = r.ReadUint32LE() == 0x12345678 && ok
+	ok = r.ReadUint32BE() == 0x12345678 && ok
+	ok = r.ReadUint40LE() == 0x12345678AA && ok
+	ok = r.ReadUint40BE() == 0x12345678AA && ok
+	ok = r.ReadUint48LE() == 0x12345678AABB && ok
+	ok = r.ReadUint48BE() == 0x12345678AABB && ok
+	ok = r.ReadUint56LE() == 0x12345678AABBCC && ok
+	ok = r.ReadUint56BE() == 0x12345678AABBCC && ok
+	ok = r.ReadUint64LE() == 0x12345678AABBCCDD && ok
+	ok = r.ReadUint64BE() == 0x12345678AABBCCDD && ok
+	ok = r.ReadFloat32LE() == 88.01 && ok
+	ok = r.ReadFloat64LE() == 99.02 && ok
+	ok = r.ReadFloat32BE() == 88.01 && ok
+	ok = r.ReadFloat64BE() == 99.02 && ok
+	ok = r.ReadString(6) == "Hello1" && ok
+	ok = bytes.Equal(r.ReadBytes(6), []byte("Hello2")) && ok
+	if r.Err() != nil {
+		return r.Err()
+	}
+	if !ok {
+		return io.ErrNoProgress
+	}
+	return nil
+}
+
+func Test_CheckedRead(t *testing.T) {
+	convey.Convey("Sticky reader should read a complete buffer", t, func() {
+		buffer := NewBuffer(0, 0)
+		PrepareBuffer(buffer)
+		convey.So(verifySticky(buffer), convey.ShouldBeNil)
+	})
+
+	convey.Convey("Every truncation should fail with ErrUnexpectedEOF instead of panicking", t, func() {
+		full := NewBuffer(0, 0)
+		PrepareBuffer(full)
+		//最后的rune不在verifySticky中读取
+		end := len(full.Data) - 3
+		for i := 0; i < end; i++ {
+			truncated := newBuffer(full.Data[:i])
+			convey.So(verifySticky(truncated), convey.ShouldEqual, io.ErrUnexpectedEOF)
+		}
+	})
+
+	convey.Convey("Checked reads should not move the position on failure", t, func() {
+		buffer := newBuffer([]byte{1, 2, 3})
+		_, err := buffer.TryReadUint32BE()
+		convey.So(err, convey.ShouldEqual, io.ErrUnexpectedEOF)
+		convey.So(buffer.ReadPos, convey.ShouldEqual, 0)
+		v, err := buffer.TryReadUint16BE()
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(v, convey.ShouldEqual, 0x0102)
+		_, err = buffer.TryReadBytes(2)
+		convey.So(err, convey.ShouldEqual, io.ErrUnexpectedEOF)
+		_, err = buffer.TryReadBytes(-1)
+		convey.So(err, convey.ShouldEqual, io.ErrUnexpectedEOF)
+		convey.So(buffer.Remaining(), convey.ShouldEqual, 1)
+	})
+
+	convey.Convey("Overlong varint should be rejected", t, func() {
+		buffer := newBuffer(bytes.Repeat([]byte{0xFF}, 11))
+		_, err := buffer.TryReadUvarint()
+		convey.So(err, convey.ShouldEqual, VarintOverflowError)
+		_, err = newBuffer([]byte{0x80, 0x80}).TryReadUvarint()
+		convey.So(err, convey.ShouldEqual, io.ErrUnexpectedEOF)
+	})
+}
+
+func Test_BufferIO(t *testing.T) {
+	convey.Convey("Buffer should work as an io.Reader", t, func() {
+		content := []byte(strings.Repeat("0123456789", 100))
+		convey.So(iotest.TestReader(newBuffer(content), content), convey.ShouldBeNil)
+
+		buffer := newBuffer([]byte("abc"))
+		p := make([]byte, 5)
+		n, err := buffer.ReadAt(p, 1)
+		convey.So(n, convey.ShouldEqual, 2)
+		convey.So(err, convey.ShouldEqual, io.EOF)
+		buffer.ReadPos = 3
+		_, _, err = buffer.ReadRune()
+		convey.So(err, convey.ShouldEqual, io.EOF)
+	})
+
+	convey.Convey("ReadFrom and WriteTo should move data through the buffer", t, func() {
+		content := strings.Repeat("gotty", 1000)
+		buffer := NewBuffer(0, 0)
+		buffer.WriteString("head:")
+		n, err := io.Copy(buffer, iotest.OneByteReader(strings.NewReader(content)))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(n, convey.ShouldEqual, len(content))
+
+		convey.So(buffer.ReadString(5), convey.ShouldEqual, "head:")
+		var out bytes.Buffer
+		n, err = io.Copy(&out, buffer)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(n, convey.ShouldEqual, len(content))
+		convey.So(out.String(), convey.ShouldEqual, content)
+		convey.So(buffer.Remaining(), convey.ShouldEqual, 0)
+
+		_, err = NewBuffer(0, 0).ReadFrom(iotest.ErrReader(io.ErrClosedPipe))
+		convey.So(err, convey.ShouldEqual, io.ErrClosedPipe)
+	})
+}
+
+func Test_BufferBits(t *testing.T) {
+	convey.Convey("Bit reader and writer should work over Buffer", t, func() {
+		buffer := NewBuffer(0, 0)
+		buffer.WriteUint8(0x7E)
+		bw := utils.NewBitWriter(buffer, utils.MSBFirst)
+		bw.WriteBit(true)
+		bw.WriteBits(0x3, 3)
+		bw.WriteBits(0xABC, 12)
+		bw.Flush()
+		buffer.WriteUint16BE(0x1234)
+
+		convey.So(buffer.ReadUint8(), convey.ShouldEqual, 0x7E)
+		br := utils.NewBitReader(buffer, utils.MSBFirst)
+		flag, _ := br.ReadBit()
+		kind, _ := br.ReadBits(3)
+		value, _ := br.ReadBits(12)
+		convey.So(flag, convey.ShouldBeTrue)
+		convey.So(kind, convey.ShouldEqual, 0x3)
+		convey.So(value, convey.ShouldEqual, 0xABC)
+		convey.So(buffer.ReadUint16BE(), convey.ShouldEqual, 0x1234)
+	})
+}
@@ -0,0 +1,362 @@
+package buffer
+
+import (
+	"encoding/binary"
+	"github.com/sumory/gotty/utils"
+	"io"
+)
+
+//CompositeByteBuf 将多个buffer组合为一个逻辑上连续的只读buffer，添加时不复制数据
+//适合累积从连接读到的多段数据再按帧解析，读取跨段的数据时才会复制
+type CompositeByteBuf struct {
+	components   [][]byte
+	offsets      []int //每段在逻辑buffer中的起始位置
+	length       int
+	readerIndex  int
+	markedReader int
+}
+
+//NewCompositeByteBuf 新建组合buffer，bufs的可读部分依次加入
+func NewCompositeByteBuf(bufs ...*ByteBuf) *CompositeByteBuf {
+	c := &CompositeByteBuf{}
+	for _, b := range bufs {
+		c.AddComponent(b)
+	}
+	return c
+}
+
+//AddComponent 加入b的可读部分，与b共享数据，不移动b的索引
+func (c *CompositeByteBuf) AddComponent(b *ByteBuf) {
+	c.AddBytes(b.Bytes())
+}
+
+//AddBytes 加入一段数据，不复制
+func (c *CompositeByteBuf) AddBytes(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	c.components = append(c.components, p)
+	c.offsets = append(c.offsets, c.length)
+	c.length += len(p)
+}
+
+//NumComponents 组成的段数
+func (c *CompositeByteBuf) NumComponents() int {
+	return len(c.components)
+}
+
+//ReaderIndex 读索引
+func (c *CompositeByteBuf) ReaderIndex() int {
+	return c.readerIndex
+}
+
+//SetReaderIndex 设置读索引
+func (c *CompositeByteBuf) SetReaderIndex(i int) {
+	if i < 0 || i > c.length {
+		panic(IndexOutOfRangeError)
+	}
+	c.readerIndex = i
+}
+
+//ReadableBytes 可读字节数
+func (c *CompositeByteBuf) ReadableBytes() int {
+	return c.length - c.readerIndex
+}
+
+//IsReadable 是否至少有n个字节可读
+func (c *CompositeByteBuf) IsReadable(n int) bool {
+	return c.ReadableBytes() >= n
+}
+
+//MarkReaderIndex 记录当前读索引
+func (c *CompositeByteBuf) MarkReaderIndex() {
+	c.markedReader = c.readerIndex
+}
+
+//ResetReaderIndex 读索引回到上次Mark的位置
+func (c *CompositeByteBuf) ResetReaderIndex() {
+	c.SetReaderIndex(c.markedReader)
+}
+
+//Skip 跳过n个字节
+func (c *CompositeByteBuf) Skip(n int) {
+	c.check(c.readerIndex, n)
+	c.readerIndex += n
+}
+
+//DiscardReadComponents 移除已完全读完的段，索引随之前移
+func (c *CompositeByteBuf) DiscardReadComponents() {
+	i := 0
+	for i < len(c.components) && c.offsets[i]+len(c.components[i]) <= c.readerIndex {
+		i++
+	}
+	if i == 0 {
+		return
+	}
+	discarded := c.offsets[i-1] + len(c.components[i-1])
+	n := copy(c.components, c.components[i:])
+	for j := n; j < len(c.components); j++ {
+		c.components[j] = nil
+	}
+	c.components = c.components[:n]
+	copy(c.offsets, c.offsets[i:])
+	c.offsets = c.offsets[:n]
+	for j := range c.offsets {
+		c.offsets[j] -= discarded
+	}
+	c.length -= discarded
+	c.readerIndex -= discarded
+	c.markedReader = discardMark(c.markedReader, discarded)
+}
+
+func (c *CompositeByteBuf) check(index, n int) {
+	if index < 0 || n < 0 || index+n > c.length {
+		panic(IndexOutOfRangeError)
+	}
+}
+
+//locate 返回index所在的段和段内偏移
+func (c *CompositeByteBuf) locate(index int) (int, int) {
+	lo, hi := 0, len(c.offsets)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if c.offsets[mid] <= index {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo, index - c.offsets[lo]
+}
+
+//GetBytes 读取index处的n个字节，位于同一段内时与该段共享数据，跨段时复制
+func (c *CompositeByteBuf) GetBytes(index, n int) []byte {
+	c.check(index, n)
+	if n == 0 {
+		return nil
+	}
+	i, off := c.locate(index)
+	if off+n <= len(c.components[i]) {
+		return c.components[i][off : off+n]
+	}
+	p := make([]byte, n)
+	for copied := 0; copied < n; i++ {
+		copied += copy(p[copied:], c.components[i][off:])
+		off = 0
+	}
+	return p
+}
+
+//Peek 不移动读索引地查看接下来n个字节
+func (c *CompositeByteBuf) Peek(n int) []byte {
+	return c.GetBytes(c.readerIndex, n)
+}
+
+//ReadBytes 读取n个字节
+func (c *CompositeByteBuf) ReadBytes(n int) []byte {
+	p := c.Peek(n)
+	c.readerIndex += n
+	return p
+}
+
+//Slice 返回[index, index+length)的ByteBuf视图，位于同一段内时不复制
+func (c *CompositeByteBuf) Slice(index, length int) *ByteBuf {
+	p := c.GetBytes(index, length)
+	return WrapByteBuf(p)
+}
+
+//ReadSlice 返回接下来n个字节的视图并移动读索引
+func (c *CompositeByteBuf) ReadSlice(n int) *ByteBuf {
+	s := c.Slice(c.readerIndex, n)
+	c.readerIndex += n
+	return s
+}
+
+//Consolidate 将可读部分复制为一个连续的ByteBuf
+func (c *CompositeByteBuf) Consolidate() *ByteBuf {
+	b := NewByteBuf(c.ReadableBytes())
+	c.GetTo(c.readerIndex, b)
+	return b
+}
+
+//GetTo 将index之后的全部数据写入b
+func (c *CompositeByteBuf) GetTo(index int, b *ByteBuf) {
+	c.check(index, 0)
+	if index == c.length {
+		return
+	}
+	i, off := c.locate(index)
+	for ; i < len(c.components); i++ {
+		b.WriteBytes(c.components[i][off:])
+		off = 0
+	}
+}
+
+//IndexOf 从读索引开始查找b，返回逻辑位置，未找到返回-1
+func (c *CompositeByteBuf) IndexOf(b byte) int {
+	if c.ReadableBytes() == 0 {
+		return -1
+	}
+	i, off := c.locate(c.readerIndex)
+	for ; i < len(c.components); i++ {
+		for j := off; j < len(c.components[i]); j++ {
+			if c.components[i][j] == b {
+				return c.offsets[i] + j
+			}
+		}
+		off = 0
+	}
+	return -1
+}
+
+// io.Reader
+func (c *CompositeByteBuf) Read(p []byte) (int, error) {
+	n := c.ReadableBytes()
+	if n == 0 {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	if n > len(p) {
+		n = len(p)
+	}
+	copy(p, c.ReadBytes(n))
+	return n, nil
+}
+
+// io.ByteReader
+func (c *CompositeByteBuf) ReadByte() (byte, error) {
+	if c.ReadableBytes() == 0 {
+		return 0, io.EOF
+	}
+	return c.ReadUint8(), nil
+}
+
+func (c *CompositeByteBuf) GetUint8(index int) uint8 {
+	c.check(index, 1)
+	i, off := c.locate(index)
+	return c.components[i][off]
+}
+
+func (c *CompositeByteBuf) PeekUint8() uint8 {
+	return c.GetUint8(c.readerIndex)
+}
+
+func (c *CompositeByteBuf) ReadUint8() uint8 {
+	v := c.PeekUint8()
+	c.readerIndex++
+	return v
+}
+
+func (c *CompositeByteBuf) GetUint16BE(index int) uint16 {
+	return binary.BigEndian.Uint16(c.GetBytes(index, 2))
+}
+
+func (c *CompositeByteBuf) PeekUint16BE() uint16 {
+	return c.GetUint16BE(c.readerIndex)
+}
+
+func (c *CompositeByteBuf) ReadUint16BE() uint16 {
+	v := c.PeekUint16BE()
+	c.readerIndex += 2
+	return v
+}
+
+func (c *CompositeByteBuf) GetUint16LE(index int) uint16 {
+	return binary.LittleEndian.Uint16(c.GetBytes(index, 2))
+}
+
+func (c *CompositeByteBuf) PeekUint16LE() uint16 {
+	return c.GetUint16LE(c.readerIndex)
+}
+
+func (c *CompositeByteBuf) ReadUint16LE() uint16 {
+	v := c.PeekUint16LE()
+	c.readerIndex += 2
+	return v
+}
+
+func (c *CompositeByteBuf) GetUint24BE(index int) uint32 {
+	return utils.GetUint24BE(c.GetBytes(index, 3))
+}
+
+func (c *CompositeByteBuf) PeekUint24BE() uint32 {
+	return c.GetUint24BE(c.readerIndex)
+}
+
+func (c *CompositeByteBuf) ReadUint24BE() uint32 {
+	v := c.PeekUint24BE()
+	c.readerIndex += 3
+	return v
+}
+
+func (c *CompositeByteBuf) GetUint24LE(index int) uint32 {
+	return utils.GetUint24LE(c.GetBytes(index, 3))
+}
+
+func (c *CompositeByteBuf) PeekUint24LE() uint32 {
+	return c.GetUint24LE(c.readerIndex)
+}
+
+func (c *CompositeByteBuf) ReadUint24LE() uint32 {
+	v := c.PeekUint24LE()
+	c.readerIndex += 3
+	return v
+}
+
+func (c *CompositeByteBuf) GetUint32BE(index int) uint32 {
+	return binary.BigEndian.Uint32(c.GetBytes(index, 4))
+}
+
+func (c *CompositeByteBuf) PeekUint32BE() uint32 {
+	return c.GetUint32BE(c.readerIndex)
+}
+
+func (c *CompositeByteBuf) ReadUint32BE() uint32 {
+	v := c.PeekUint32BE()
+	c.readerIndex += 4
+	return v
+}
+
+func (c *CompositeByteBuf) GetUint32LE(index int) uint32 {
+	return binary.LittleEndian.Uint32(c.GetBytes(index, 4))
+}
+
+func (c *CompositeByteBuf) PeekUint32LE() uint32 {
+	return c.GetUint32LE(c.readerIndex)
+}
+
+func (c *CompositeByteBuf) ReadUint32LE() uint32 {
+	v := c.PeekUint32LE()
+	c.readerIndex += 4
+	return v
+}
+
+func (c *CompositeByteBuf) GetUint64BE(index int) uint64 {
+	return binary.BigEndian.Uint64(c.GetBytes(index, 8))
+}
+
+func (c *CompositeByteBuf) PeekUint64BE() uint64 {
+	return c.GetUint64BE(c.readerIndex)
+}
+
+func (c *CompositeByteBuf) ReadUint64BE() uint64 {
+	v := c.PeekUint64BE()
+	c.readerIndex += 8
+	return v
+}
+
+func (c *CompositeByteBuf) GetUint64LE(index int) uint64 {
+	return binary.LittleEndian.Uint64(c.GetBytes(index, 8))
+}
+
+func (c *CompositeByteBuf) PeekUint64LE() uint64 {
+	return c.GetUint64LE(c.readerIndex)
+}
+
+func (c *CompositeByteBuf) ReadUint64LE() uint64 {
+	v := c.PeekUint64LE()
+	c.readerIndex += 8
+	return v
+}
@@ -0,0 +1,41 @@
+package buffer
+
+import (
+	"sync/atomic"
+)
+
+//LeakReport 泄漏的buffer，被GC回收时引用计数仍大于0
+type LeakReport struct {
+	Capacity int
+	Refs     int
+	Stack    string //从池中取出时的调用栈
+}
+
+//LeakHandler 发现泄漏时的回调
+type LeakHandler func(report LeakReport)
+
+var (
+	leakSampleRate uint64 = 16 //每多少次Get采样一次调用栈
+	leakSampled    uint64
+	leakCount      uint64
+	leakHandler    atomic.Value
+)
+
+//SetLeakSampleRate 设置泄漏检测的采样频率，每rate次Get记录一次调用栈，1为全部记录
+//泄漏检测只在以gottydebug标签编译时生效
+func SetLeakSampleRate(rate int) {
+	if rate < 1 {
+		rate = 1
+	}
+	atomic.StoreUint64(&leakSampleRate, uint64(rate))
+}
+
+//SetLeakHandler 设置发现泄漏时的回调，默认打印错误日志
+func SetLeakHandler(handler LeakHandler) {
+	leakHandler.Store(handler)
+}
+
+//LeakCount 已发现的泄漏个数
+func LeakCount() uint64 {
+	return atomic.LoadUint64(&leakCount)
+}
@@ -0,0 +1,61 @@
+//go:build gottydebug
+
+package buffer
+
+import (
+	log "github.com/sumory/log4go"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+//LeakDetection 是否启用了泄漏检测
+const LeakDetection = true
+
+//trackAcquire 按采样频率记录取出时的调用栈，buffer未释放就被GC回收时报告泄漏
+func trackAcquire(b *Buffer) {
+	n := atomic.AddUint64(&leakSampled, 1)
+	if n%atomic.LoadUint64(&leakSampleRate) != 0 {
+		return
+	}
+
+	pcs := make([]uintptr, 32)
+	pcs = pcs[:runtime.Callers(3, pcs)]
+	runtime.SetFinalizer(b, func(b *Buffer) {
+		if refs := b.RefCount(); refs > 0 {
+			reportLeak(LeakReport{Capacity: cap(b.Data), Refs: refs, Stack: formatStack(pcs)})
+		}
+	})
+}
+
+func trackRelease(b *Buffer) {
+	runtime.SetFinalizer(b, nil)
+}
+
+func reportLeak(report LeakReport) {
+	atomic.AddUint64(&leakCount, 1)
+	if handler, ok := leakHandler.Load().(LeakHandler); ok && handler != nil {
+		handler(report)
+		return
+	}
+	log.Error("buffer leak, capacity: %d, refs: %d, acquired at:\n%s", report.Capacity, report.Refs, report.Stack)
+}
+
+func formatStack(pcs []uintptr) string {
+	var sb strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		sb.WriteString(frame.Function)
+		sb.WriteString("\n\t")
+		sb.WriteString(frame.File)
+		sb.WriteString(":")
+		sb.WriteString(strconv.Itoa(frame.Line))
+		sb.WriteString("\n")
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
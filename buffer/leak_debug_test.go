@@ -0,0 +1,49 @@
+//go:build gottydebug
+
+package buffer
+
+import (
+	convey "github.com/smartystreets/goconvey/convey"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_LeakDetector(t *testing.T) {
+	convey.Convey("Unreleased buffers should be reported with the acquiring stack", t, func() {
+		SetLeakSampleRate(1)
+		defer SetLeakSampleRate(16)
+		reports := make(chan LeakReport, 1)
+		SetLeakHandler(func(report LeakReport) {
+			select {
+			case reports <- report:
+			default:
+			}
+		})
+		defer SetLeakHandler(nil)
+
+		released := Get(0, 128)
+		released.Release()
+		leakBuffer()
+
+		var report LeakReport
+		deadline := time.Now().Add(2 * time.Second)
+		for report.Stack == "" && time.Now().Before(deadline) {
+			runtime.GC()
+			select {
+			case report = <-reports:
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		convey.So(report.Refs, convey.ShouldEqual, 1)
+		convey.So(report.Capacity, convey.ShouldEqual, 512)
+		convey.So(strings.Contains(report.Stack, "leakBuffer"), convey.ShouldBeTrue)
+		convey.So(LeakCount(), convey.ShouldBeGreaterThanOrEqualTo, 1)
+	})
+}
+
+//go:noinline
+func leakBuffer() {
+	Get(0, 500).WriteString("leaked")
+}
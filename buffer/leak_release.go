@@ -0,0 +1,10 @@
+//go:build !gottydebug
+
+package buffer
+
+//LeakDetection 是否启用了泄漏检测
+const LeakDetection = false
+
+func trackAcquire(b *Buffer) {}
+
+func trackRelease(b *Buffer) {}
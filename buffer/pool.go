@@ -0,0 +1,112 @@
+package buffer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+//Pool 按容量分级的Buffer池，容量为2的幂，从minSize到maxSize
+//从池中取出的Buffer引用计数为1，共享时调用Retain，用完调用Release，计数降为0时归还
+type Pool struct {
+	minShift uint
+	classes  []sync.Pool
+}
+
+//DefaultPool 默认池，容量64B~1MB
+var DefaultPool = NewPool(64, 1024*1024)
+
+//NewPool 新建Buffer池，minSize和maxSize向上取整为2的幂
+func NewPool(minSize, maxSize int) *Pool {
+	minShift := shiftOf(minSize)
+	maxShift := shiftOf(maxSize)
+	return &Pool{
+		minShift: minShift,
+		classes:  make([]sync.Pool, maxShift-minShift+1),
+	}
+}
+
+//shiftOf 不小于size的最小2的幂的指数
+func shiftOf(size int) uint {
+	shift := uint(0)
+	for 1<<shift < size {
+		shift++
+	}
+	return shift
+}
+
+//Get 取出长度为size、容量至少为capacity的Buffer，超过池的最大容量时直接分配
+func (p *Pool) Get(size, capacity int) *Buffer {
+	if capacity < size {
+		capacity = size
+	}
+	shift := shiftOf(capacity)
+	if shift < p.minShift {
+		shift = p.minShift
+	}
+	idx := int(shift - p.minShift)
+	if idx >= len(p.classes) {
+		return NewBuffer(size, capacity)
+	}
+
+	var b *Buffer
+	if v := p.classes[idx].Get(); v != nil {
+		b = v.(*Buffer)
+		b.Data = b.Data[:size]
+	} else {
+		b = NewBuffer(size, 1<<shift)
+		b.pool = p
+	}
+	b.ReadPos = 0
+	atomic.StoreInt32(&b.refs, 1)
+	trackAcquire(b)
+	return b
+}
+
+//put 按当前容量归还，写入时扩容过的buffer归入不超过其容量的最大级别
+func (p *Pool) put(b *Buffer) {
+	shift := shiftOf(cap(b.Data) + 1)
+	if shift == 0 {
+		return
+	}
+	shift-- //不超过cap的最大2的幂
+	if shift < p.minShift {
+		return
+	}
+	idx := int(shift - p.minShift)
+	if idx >= len(p.classes) {
+		return
+	}
+	b.Data = b.Data[: 0 : 1<<shift]
+	b.ReadPos = 0
+	p.classes[idx].Put(b)
+}
+
+//Get 从默认池取出Buffer，用法同NewBuffer，用完需调用Release
+func Get(size, capacity int) *Buffer {
+	return DefaultPool.Get(size, capacity)
+}
+
+//Retain 增加引用计数，对已释放的buffer调用会panic
+func (b *Buffer) Retain() *Buffer {
+	if atomic.AddInt32(&b.refs, 1) <= 1 {
+		panic("buffer: retain after release")
+	}
+	return b
+}
+
+//Release 减少引用计数，降为0时归还到池中，之后不能再使用该buffer
+func (b *Buffer) Release() {
+	refs := atomic.AddInt32(&b.refs, -1)
+	if refs < 0 {
+		panic("buffer: released too many times")
+	}
+	if refs == 0 {
+		trackRelease(b)
+		b.free()
+	}
+}
+
+//RefCount 当前引用计数
+func (b *Buffer) RefCount() int {
+	return int(atomic.LoadInt32(&b.refs))
+}
@@ -0,0 +1,79 @@
+package buffer
+
+import (
+	convey "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_Pool(t *testing.T) {
+	convey.Convey("Buffers should be rounded up to a size class", t, func() {
+		pool := NewPool(64, 4096)
+		b := pool.Get(10, 0)
+		convey.So(len(b.Data), convey.ShouldEqual, 10)
+		convey.So(cap(b.Data), convey.ShouldEqual, 64)
+		b.Release()
+
+		b = pool.Get(0, 1000)
+		convey.So(len(b.Data), convey.ShouldEqual, 0)
+		convey.So(cap(b.Data), convey.ShouldEqual, 1024)
+		b.Release()
+
+		b = pool.Get(8192, 0)
+		convey.So(cap(b.Data), convey.ShouldEqual, 8192)
+		convey.So(b.pool, convey.ShouldBeNil)
+		b.Release()
+	})
+
+	convey.Convey("Buffer should return to the pool when the last reference is released", t, func() {
+		pool := NewPool(64, 4096)
+		b := pool.Get(0, 100)
+		b.WriteString("hello")
+		convey.So(b.RefCount(), convey.ShouldEqual, 1)
+		convey.So(b.Retain(), convey.ShouldEqual, b)
+		convey.So(b.RefCount(), convey.ShouldEqual, 2)
+
+		b.Release()
+		convey.So(b.RefCount(), convey.ShouldEqual, 1)
+		convey.So(b.ReadString(5), convey.ShouldEqual, "hello")
+		b.Release()
+		convey.So(b.RefCount(), convey.ShouldEqual, 0)
+		convey.So(len(b.Data), convey.ShouldEqual, 0)
+		convey.So(b.ReadPos, convey.ShouldEqual, 0)
+
+		convey.So(func() { b.Release() }, convey.ShouldPanic)
+		convey.So(func() { b.Retain() }, convey.ShouldPanic)
+	})
+
+	convey.Convey("Grown buffers should go back to the class their capacity fits", t, func() {
+		pool := NewPool(64, 4096)
+		b := pool.Get(0, 64)
+		b.WriteBytes(make([]byte, 300))
+		convey.So(cap(b.Data), convey.ShouldBeGreaterThanOrEqualTo, 300)
+		b.Release()
+		convey.So(cap(b.Data), convey.ShouldEqual, 256)
+	})
+
+	convey.Convey("Non-pooled buffers can be released too", t, func() {
+		b := NewBuffer(0, 16)
+		b.Release()
+		convey.So(b.RefCount(), convey.ShouldEqual, 0)
+	})
+}
+
+var benchSink *Buffer
+
+func BenchmarkPoolGetRelease_1K(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		bf := Get(0, 1024)
+		benchSink = bf
+		bf.Release()
+	}
+}
+
+func BenchmarkNewBuffer_1K(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchSink = NewBuffer(0, 1024)
+	}
+}
@@ -18,6 +18,10 @@ type GottyClient struct {
 	session    *session.Session
 	config     *config.GottyConfig
 	handler    func(session *session.Session, p codec.Packet) //包处理函数
+
+	filters      []session.FilterFactory //过滤器，重连时重新创建
+	eventHandler session.EventHandler
+	executor     session.Executor //包处理执行器，重连后沿用
 }
 
 func NewGottyClient(conn *net.TCPConn, //
@@ -31,6 +35,7 @@ func NewGottyClient(conn *net.TCPConn, //
 	client := &GottyClient{
 		heartbeat: 0,
 		conn:      conn,
+		codec:     codec,
 		session:   session,
 		config:    config,
 		handler:   handler,
@@ -51,6 +56,24 @@ func (client *GottyClient) Idle() bool {
 	return client.session.Idle()
 }
 
+//AddFilter 添加过滤器，需在Start之前调用
+func (client *GottyClient) AddFilter(factory session.FilterFactory) {
+	client.filters = append(client.filters, factory)
+	client.session.AddFilter(factory(client.session))
+}
+
+//SetEventHandler 设置session事件处理函数
+func (client *GottyClient) SetEventHandler(h session.EventHandler) {
+	client.eventHandler = h
+	client.session.SetEventHandler(h)
+}
+
+//SetExecutor 设置包处理执行器，需在Start之前调用
+func (client *GottyClient) SetExecutor(e session.Executor) {
+	client.executor = e
+	client.session.SetExecutor(e)
+}
+
 func (client *GottyClient) Start() {
 
 	//重新初始化
@@ -70,20 +93,16 @@ func (client *GottyClient) Start() {
 func (client *GottyClient) dispatchPacket() {
 	//解析
 	for nil != client.session && !client.session.Closed() {
-		p := <-client.session.ReadChannel
+		var p codec.Packet
+		select {
+		case p = <-client.session.ReadChannel:
+		case <-client.session.Done():
+			return
+		}
 		if nil == p {
 			continue
 		}
-
-		//模拟queue/pool
-		client.config.DispatcherQueueSize <- 1
-		go func() {
-			defer func() {
-				<-client.config.DispatcherQueueSize
-			}()
-
-			client.handler(client.session, p)
-		}()
+		client.session.Dispatch(p)
 	}
 }
 
@@ -101,6 +120,11 @@ func (client *GottyClient) reconnect() (bool, error) {
 	//重置
 	client.conn = conn
 	client.session = session.NewSession(client.conn, client.codec, client.config, client.handler)
+	for _, factory := range client.filters {
+		client.session.AddFilter(factory(client.session))
+	}
+	client.session.SetEventHandler(client.eventHandler)
+	client.session.SetExecutor(client.executor)
 	client.Start()
 	return true, nil
 }
@@ -2,6 +2,7 @@ package codec
 
 import (
 	"bufio"
+	"net"
 )
 
 //具体业务实体需要实现此接口
@@ -23,3 +24,14 @@ type Codec interface {
 	//解码，数据包 -> 实体
 	Unmarshal(p Packet, m Message) error
 }
+
+//BufferedWriter 可只写入缓冲而不flush的编解码器，session取空写队列后统一flush
+type BufferedWriter interface {
+	WriteBuffered(bWriter *bufio.Writer, p Packet) error
+}
+
+//VectorWriter 可将包编码为多段数据的编解码器，session将一批包合并后通过writev写出，
+//较大的包体直接引用而不复制
+type VectorWriter interface {
+	AppendBuffers(bufs net.Buffers, p Packet) (net.Buffers, error)
+}
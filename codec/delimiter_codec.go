@@ -0,0 +1,203 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/sumory/gotty/buffer"
+	log "github.com/sumory/log4go"
+	"io"
+)
+
+//DelimiterCodec 按分隔符分帧的文本协议编解码器，参考Netty的DelimiterBasedFrameDecoder，产生RawPacket
+//有多个分隔符时取得到的帧最短的那个；帧长度不含分隔符，不能超过maxFrameLength：
+//failFast为true时一发现超长就返回TooLongFrameError，session随即关闭连接；
+//为false时丢弃超长帧直到下一个分隔符，不返回错误，继续读取下一帧
+//DecodeFrame不保存连接的状态，丢弃时在缓冲中留着超长帧末尾的一段，直到分隔符到达后一起丢弃
+type DelimiterCodec struct {
+	name           string
+	delimiters     [][]byte
+	maxDelimLen    int
+	maxFrameLength int
+	stripDelimiter bool //解码时是否去掉分隔符
+	failFast       bool
+}
+
+//NewDelimiterCodec 新建分隔符编解码器，写出时在没有以分隔符结尾的包后加上第一个分隔符
+func NewDelimiterCodec(maxFrameLength int, stripDelimiter, failFast bool, delimiters ...[]byte) *DelimiterCodec {
+	if len(delimiters) == 0 {
+		panic("codec: no delimiter")
+	}
+	dc := &DelimiterCodec{
+		name:           "delimiter codec",
+		maxFrameLength: maxFrameLength,
+		stripDelimiter: stripDelimiter,
+		failFast:       failFast,
+	}
+	for _, d := range delimiters {
+		if len(d) == 0 {
+			panic("codec: empty delimiter")
+		}
+		dc.delimiters = append(dc.delimiters, append([]byte(nil), d...))
+		if len(d) > dc.maxDelimLen {
+			dc.maxDelimLen = len(d)
+		}
+	}
+	return dc
+}
+
+//NewLineCodec 新建按行分帧的编解码器，同时识别\r\n和\n，写出时使用\r\n
+func NewLineCodec(maxLineLength int, stripDelimiter, failFast bool) *DelimiterCodec {
+	dc := NewDelimiterCodec(maxLineLength, stripDelimiter, failFast, []byte("\r\n"), []byte("\n"))
+	dc.name = "line codec"
+	return dc
+}
+
+func (dc *DelimiterCodec) Name() string {
+	return dc.name
+}
+
+//indexOf 从from开始查找使帧最短的分隔符，返回帧长度和分隔符长度，没有时返回-1
+func (dc *DelimiterCodec) indexOf(data []byte, from int) (int, int) {
+	end, delimLen := -1, 0
+	for _, d := range dc.delimiters {
+		i := bytes.Index(data[from:], d)
+		if i >= 0 && (end < 0 || from+i < end) {
+			end, delimLen = from+i, len(d)
+		}
+	}
+	return end, delimLen
+}
+
+//tooLong 没有分隔符时数据是否已经超长，分隔符可能还没收全，多留出一个分隔符的长度
+func (dc *DelimiterCodec) tooLong(n int) bool {
+	return n >= dc.maxFrameLength+dc.maxDelimLen
+}
+
+//frame 取出分隔符之前的帧，不去掉分隔符时包含分隔符
+func (dc *DelimiterCodec) frame(data []byte, end, delimLen int) RawPacket {
+	if !dc.stripDelimiter {
+		end += delimLen
+	}
+	return RawPacket{Data: append([]byte(nil), data[:end]...)}
+}
+
+//Read 读取到分隔符为止的一帧
+func (dc *DelimiterCodec) Read(bReader *bufio.Reader) (Packet, error) {
+	var acc []byte //已从bReader取出、还没有找到分隔符的数据
+	discarding := false
+	for {
+		if _, err := bReader.Peek(1); err != nil {
+			if err == io.EOF && (len(acc) > 0 || discarding) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		buf, _ := bReader.Peek(bReader.Buffered())
+
+		//之前的数据中没有分隔符，只需从可能跨越两段的位置开始查找
+		from, data := 0, buf
+		if len(acc) > 0 {
+			from = len(acc) - dc.maxDelimLen + 1
+			if from < 0 {
+				from = 0
+			}
+			data = append(acc, buf...)
+		}
+		if end, delimLen := dc.indexOf(data, from); end >= 0 {
+			bReader.Discard(end + delimLen - len(acc))
+			if !discarding && end <= dc.maxFrameLength {
+				return dc.frame(data, end, delimLen), nil
+			}
+			if dc.failFast {
+				return nil, TooLongFrameError
+			}
+			log.Warn("%s discarded a frame longer than %d bytes", dc.name, dc.maxFrameLength)
+			acc, discarding = nil, false
+			continue
+		}
+
+		bReader.Discard(len(buf))
+		if discarding || dc.tooLong(len(data)) {
+			if dc.failFast {
+				return nil, TooLongFrameError
+			}
+			//丢弃超长的数据，只保留可能是分隔符开头的字节
+			discarding = true
+			data = data[len(data)-dc.maxDelimLen+1:]
+		}
+		acc = append(acc[:0:0], data...)
+	}
+}
+
+//DecodeFrame 实现FrameDecoder
+//failFast为false时，还没有分隔符的超长帧只保留末尾maxFrameLength+maxDelimLen个字节，
+//分隔符到达时帧长度必然超过maxFrameLength，整帧被丢弃，累积的数据不会超过这个长度
+func (dc *DelimiterCodec) DecodeFrame(in *buffer.ByteBuf) (Packet, error) {
+	for {
+		data := in.Bytes()
+		end, delimLen := dc.indexOf(data, 0)
+		if end < 0 {
+			if dc.tooLong(len(data)) {
+				if dc.failFast {
+					in.Skip(len(data))
+					return nil, TooLongFrameError
+				}
+				in.Skip(len(data) - dc.maxFrameLength - dc.maxDelimLen)
+			}
+			return nil, nil
+		}
+		in.Skip(end + delimLen)
+		if end <= dc.maxFrameLength {
+			return dc.frame(data, end, delimLen), nil
+		}
+		if dc.failFast {
+			return nil, TooLongFrameError
+		}
+		log.Warn("%s discarded a frame longer than %d bytes", dc.name, dc.maxFrameLength)
+	}
+}
+
+//Write 将包写出
+func (dc *DelimiterCodec) Write(bWriter *bufio.Writer, p Packet) error {
+	if err := dc.WriteBuffered(bWriter, p); err != nil {
+		return err
+	}
+	return bWriter.Flush()
+}
+
+//WriteBuffered 写入包及分隔符，不flush
+func (dc *DelimiterCodec) WriteBuffered(bWriter *bufio.Writer, p Packet) error {
+	rp, ok := p.(RawPacket)
+	if !ok {
+		return PacketNotRawError
+	}
+	data := rp.Data
+	delimited := false
+	for _, d := range dc.delimiters {
+		if bytes.HasSuffix(data, d) {
+			delimited = true
+			data = data[:len(data)-len(d)]
+			break
+		}
+	}
+	if len(data) > dc.maxFrameLength {
+		return TooLongFrameError
+	}
+	if delimited {
+		_, err := bWriter.Write(rp.Data)
+		return err
+	}
+	bWriter.Write(data)
+	_, err := bWriter.Write(dc.delimiters[0])
+	return err
+}
+
+//Marshal 将消息的字节转为RawPacket，文本可使用TextMessage、StringMsg或ByteMsg
+func (dc *DelimiterCodec) Marshal(m Message) (Packet, error) {
+	return rawMarshal(m)
+}
+
+//Unmarshal 将包转为业务实体，文本可使用TextMessage接收
+func (dc *DelimiterCodec) Unmarshal(p Packet, m Message) error {
+	return p.Transform(m)
+}
@@ -0,0 +1,210 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/sumory/gotty/buffer"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+//readFrames 反复调用Read直到出错，返回读到的帧和最后的错误
+func readFrames(c Codec, r io.Reader) ([]string, error) {
+	bReader := bufio.NewReaderSize(r, 16)
+	var frames []string
+	for {
+		p, err := c.Read(bReader)
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, p.(RawPacket).String())
+	}
+}
+
+//decodeFrames 每次喂入一个字节，返回解出的帧和第一个错误
+func decodeFrames(c FrameDecoder, data string) ([]string, error) {
+	sd := NewStreamDecoder(c)
+	var frames []string
+	for i := 0; i < len(data); i++ {
+		packets, err := sd.Feed([]byte{data[i]})
+		for _, p := range packets {
+			frames = append(frames, p.(RawPacket).String())
+		}
+		if err != nil {
+			return frames, err
+		}
+	}
+	return frames, nil
+}
+
+func Test_LineCodec(t *testing.T) {
+	input := "PING\r\nSET a 1\nlong line over sixteen bytes\r\n\r\nEND\n"
+
+	convey.Convey("Lines should be split on \\r\\n and \\n", t, func() {
+		lc := NewLineCodec(64, true, false)
+		want := []string{"PING", "SET a 1", "long line over sixteen bytes", "", "END"}
+
+		frames, err := readFrames(lc, strings.NewReader(input))
+		convey.So(err, convey.ShouldEqual, io.EOF)
+		convey.So(frames, convey.ShouldResemble, want)
+
+		frames, err = readFrames(lc, iotest.OneByteReader(strings.NewReader(input)))
+		convey.So(err, convey.ShouldEqual, io.EOF)
+		convey.So(frames, convey.ShouldResemble, want)
+
+		frames, err = decodeFrames(lc, input)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(frames, convey.ShouldResemble, want)
+	})
+
+	convey.Convey("Delimiters should be kept when not stripped", t, func() {
+		lc := NewLineCodec(64, false, false)
+		frames, err := readFrames(lc, strings.NewReader(input))
+		convey.So(err, convey.ShouldEqual, io.EOF)
+		convey.So(frames, convey.ShouldResemble, []string{"PING\r\n", "SET a 1\n", "long line over sixteen bytes\r\n", "\r\n", "END\n"})
+	})
+
+	convey.Convey("A partial last line should be an unexpected EOF", t, func() {
+		frames, err := readFrames(NewLineCodec(64, true, false), strings.NewReader("a\nbc"))
+		convey.So(frames, convey.ShouldResemble, []string{"a"})
+		convey.So(err, convey.ShouldEqual, io.ErrUnexpectedEOF)
+	})
+
+	convey.Convey("Writing should add \\r\\n unless the packet already ends with a delimiter", t, func() {
+		lc := NewLineCodec(8, true, false)
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		p, _ := lc.Marshal(StringMsg("PING"))
+		convey.So(lc.Write(w, p), convey.ShouldBeNil)
+		p, _ = lc.Marshal(ByteMsg([]byte("PONG\n")))
+		convey.So(lc.Write(w, p), convey.ShouldBeNil)
+		convey.So(out.String(), convey.ShouldEqual, "PING\r\nPONG\n")
+
+		convey.So(lc.Write(w, NewRawPacket([]byte("123456789"))), convey.ShouldEqual, TooLongFrameError)
+		convey.So(lc.Write(w, NewRawPacket([]byte("12345678\r\n"))), convey.ShouldBeNil)
+		convey.So(lc.Write(w, newFrameCheckPacket(1, "x")), convey.ShouldEqual, PacketNotRawError)
+	})
+
+	convey.Convey("Decoded lines should be convertible to TextMessage", t, func() {
+		lc := NewLineCodec(64, true, false)
+		p, err := lc.Read(bufio.NewReader(strings.NewReader("PING\r\n")))
+		convey.So(err, convey.ShouldBeNil)
+		tm := &TextMessage{}
+		convey.So(lc.Unmarshal(p, tm), convey.ShouldBeNil)
+		convey.So(tm.Text, convey.ShouldEqual, "PING")
+
+		out, err := lc.Marshal(NewTextMessage("PONG"))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(out.(RawPacket).String(), convey.ShouldEqual, "PONG")
+		convey.So(tm.FromPacket(newFrameCheckPacket(1, "x")), convey.ShouldEqual, PacketNotRawError)
+	})
+}
+
+func Test_DelimiterCodec(t *testing.T) {
+	convey.Convey("The delimiter giving the shortest frame should win", t, func() {
+		dc := NewDelimiterCodec(64, true, false, []byte(";"), []byte("END"), []byte{0})
+		input := "aENDb;c\x00dEND;"
+		want := []string{"a", "b", "c", "d", ""}
+
+		frames, err := readFrames(dc, strings.NewReader(input))
+		convey.So(err, convey.ShouldEqual, io.EOF)
+		convey.So(frames, convey.ShouldResemble, want)
+
+		frames, err = decodeFrames(dc, input)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(frames, convey.ShouldResemble, want)
+	})
+
+	convey.Convey("Delimiters split across reads should be found", t, func() {
+		dc := NewDelimiterCodec(64, true, false, []byte("<EOM>"))
+		input := strings.Repeat("x", 14) + "<EOM>" + strings.Repeat("y", 30) + "<EOM>"
+		frames, err := readFrames(dc, iotest.HalfReader(strings.NewReader(input)))
+		convey.So(err, convey.ShouldEqual, io.EOF)
+		convey.So(frames, convey.ShouldResemble, []string{strings.Repeat("x", 14), strings.Repeat("y", 30)})
+	})
+
+	convey.Convey("Overlong frames should fail fast or be discarded up to the delimiter", t, func() {
+		input := "ok;" + strings.Repeat("z", 100) + ";next;"
+
+		fast := NewDelimiterCodec(8, true, true, []byte(";"))
+		frames, err := readFrames(fast, strings.NewReader(input))
+		convey.So(frames, convey.ShouldResemble, []string{"ok"})
+		convey.So(err, convey.ShouldEqual, TooLongFrameError)
+
+		//未收到分隔符前就发现超长，不必等待整帧
+		r := bufio.NewReaderSize(io.MultiReader(strings.NewReader(strings.Repeat("z", 9)), blockingReader{}), 16)
+		_, err = fast.Read(r)
+		convey.So(err, convey.ShouldEqual, TooLongFrameError)
+
+		//丢弃超长帧后不返回错误，session不会因此关闭
+		slow := NewDelimiterCodec(8, true, false, []byte(";"))
+		frames, err = readFrames(slow, strings.NewReader(input))
+		convey.So(frames, convey.ShouldResemble, []string{"ok", "next"})
+		convey.So(err, convey.ShouldEqual, io.EOF)
+
+		//分隔符在最大长度之后才出现
+		_, err = fast.Read(bufio.NewReader(strings.NewReader("123456789;")))
+		convey.So(err, convey.ShouldEqual, TooLongFrameError)
+		p, err := slow.Read(bufio.NewReader(strings.NewReader("123456789;ok;")))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.(RawPacket).String(), convey.ShouldEqual, "ok")
+		_, err = NewStreamDecoder(fast).Feed([]byte("123456789;"))
+		convey.So(err, convey.ShouldEqual, TooLongFrameError)
+		packets, err := NewStreamDecoder(slow).Feed([]byte("123456789;ok;"))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(packets), convey.ShouldEqual, 1)
+		convey.So(packets[0].(RawPacket).String(), convey.ShouldEqual, "ok")
+
+		frames, err = decodeFrames(fast, input)
+		convey.So(frames, convey.ShouldResemble, []string{"ok"})
+		convey.So(err, convey.ShouldEqual, TooLongFrameError)
+		frames, err = decodeFrames(slow, input)
+		convey.So(frames, convey.ShouldResemble, []string{"ok", "next"})
+		convey.So(err, convey.ShouldBeNil)
+	})
+
+	convey.Convey("DecodeFrame should discard an overlong frame before its delimiter arrives", t, func() {
+		lc := NewLineCodec(8, true, false)
+		in := buffer.NewByteBuf(64)
+		in.WriteString("ok\n" + strings.Repeat("z", 20))
+		p, err := lc.DecodeFrame(in)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.(RawPacket).String(), convey.ShouldEqual, "ok")
+		//超长的部分被跳过，只留下一段末尾
+		p, err = lc.DecodeFrame(in)
+		convey.So(p, convey.ShouldBeNil)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(in.ReadableBytes(), convey.ShouldEqual, 8+2)
+
+		//帧尾不足最大长度，仍随超长帧一起丢弃
+		in.WriteString("zz\r")
+		p, err = lc.DecodeFrame(in)
+		convey.So(p, convey.ShouldBeNil)
+		convey.So(err, convey.ShouldBeNil)
+		in.WriteString("\nnext\r\n")
+		p, err = lc.DecodeFrame(in)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.(RawPacket).String(), convey.ShouldEqual, "next")
+		convey.So(in.ReadableBytes(), convey.ShouldEqual, 0)
+	})
+
+	convey.Convey("A frame of exactly max length should be accepted", t, func() {
+		dc := NewDelimiterCodec(8, true, true, []byte("\r\n"))
+		frames, err := readFrames(dc, strings.NewReader("12345678\r\n"))
+		convey.So(err, convey.ShouldEqual, io.EOF)
+		convey.So(frames, convey.ShouldResemble, []string{"12345678"})
+		frames, err = decodeFrames(dc, "12345678\r\n")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(frames, convey.ShouldResemble, []string{"12345678"})
+	})
+}
+
+//blockingReader 模拟一直没有后续数据的连接
+type blockingReader struct{}
+
+func (blockingReader) Read(p []byte) (int, error) {
+	select {}
+}
@@ -14,5 +14,16 @@ var (
 	HeaderTooLargeError = errors.New("Header is larger than total packet")
 	HeaderTooSmallError = errors.New("Header size should not be less then zero")
 
+	LengthFieldConfigError  = errors.New("Invalid length field codec config")
+	InvalidFrameLengthError = errors.New("Invalid frame length")
+	PacketNotRawError       = errors.New("Packet is not a RawPacket")
+	StrippedPrefixError     = errors.New("Cannot encode a frame whose stripped prefix is not exactly the length field")
+	TooLongFrameError       = errors.New("Frame is longer than max frame length")
+	MalformedVarintError    = errors.New("Malformed varint length prefix")
+	UnknownRecordTypeError  = errors.New("Unknown fixed-length record type")
+	RecordSizeError         = errors.New("Record size does not match its type")
+	RespProtocolError       = errors.New("Invalid RESP data")
+	PacketNotRespError      = errors.New("Packet is not a RespValue")
+
 	UnImplementedError = errors.New("not implemented or not support")
 )
@@ -0,0 +1,120 @@
+package codec
+
+import (
+	"bufio"
+	"github.com/sumory/gotty/buffer"
+	"io"
+)
+
+//FixedLengthCodec 定长记录编解码器，产生RawPacket，Data为包含类型字节在内的整条记录
+//可按记录的第一个字节（类型）设置不同的长度，没有设置的类型使用默认长度，默认长度为0时返回UnknownRecordTypeError
+type FixedLengthCodec struct {
+	name      string
+	recordLen int      //默认记录长度
+	sizes     [256]int //按类型字节设置的记录长度，0表示未设置
+	typed     bool     //是否设置了按类型的长度
+}
+
+//NewFixedLengthCodec 新建定长记录编解码器
+func NewFixedLengthCodec(recordLen int) *FixedLengthCodec {
+	return &FixedLengthCodec{
+		name:      "fixed length codec",
+		recordLen: recordLen,
+	}
+}
+
+//SetRecordSize 设置类型字节为typ的记录长度，size包含类型字节本身
+func (fc *FixedLengthCodec) SetRecordSize(typ byte, size int) *FixedLengthCodec {
+	if size < 1 {
+		panic("codec: record size must include the type byte")
+	}
+	fc.sizes[typ] = size
+	fc.typed = true
+	return fc
+}
+
+func (fc *FixedLengthCodec) Name() string {
+	return fc.name
+}
+
+//recordSize 根据类型字节取得记录长度
+func (fc *FixedLengthCodec) recordSize(typ byte) (int, error) {
+	if fc.typed && fc.sizes[typ] > 0 {
+		return fc.sizes[typ], nil
+	}
+	if fc.recordLen <= 0 {
+		return 0, UnknownRecordTypeError
+	}
+	return fc.recordLen, nil
+}
+
+//Read 读取一条记录
+func (fc *FixedLengthCodec) Read(bReader *bufio.Reader) (Packet, error) {
+	head, err := bReader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	size, err := fc.recordSize(head[0])
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(bReader, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return RawPacket{Data: data}, nil
+}
+
+//DecodeFrame 实现FrameDecoder
+func (fc *FixedLengthCodec) DecodeFrame(in *buffer.ByteBuf) (Packet, error) {
+	if !in.IsReadable(1) {
+		return nil, nil
+	}
+	size, err := fc.recordSize(in.PeekUint8())
+	if err != nil || !in.IsReadable(size) {
+		return nil, err
+	}
+	//in的数据之后会被覆盖，需复制
+	return RawPacket{Data: append([]byte(nil), in.ReadBytes(size)...)}, nil
+}
+
+//Write 将包写出
+func (fc *FixedLengthCodec) Write(bWriter *bufio.Writer, p Packet) error {
+	if err := fc.WriteBuffered(bWriter, p); err != nil {
+		return err
+	}
+	return bWriter.Flush()
+}
+
+//WriteBuffered 校验记录长度后写入，不flush
+func (fc *FixedLengthCodec) WriteBuffered(bWriter *bufio.Writer, p Packet) error {
+	rp, ok := p.(RawPacket)
+	if !ok {
+		return PacketNotRawError
+	}
+	if len(rp.Data) == 0 {
+		return RecordSizeError
+	}
+	size, err := fc.recordSize(rp.Data[0])
+	if err != nil {
+		return err
+	}
+	if len(rp.Data) != size {
+		return RecordSizeError
+	}
+	_, err = bWriter.Write(rp.Data)
+	return err
+}
+
+//Marshal 将消息的字节转为RawPacket
+func (fc *FixedLengthCodec) Marshal(m Message) (Packet, error) {
+	return rawMarshal(m)
+}
+
+//Unmarshal 将包转为业务实体
+func (fc *FixedLengthCodec) Unmarshal(p Packet, m Message) error {
+	return p.Transform(m)
+}
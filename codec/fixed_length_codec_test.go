@@ -0,0 +1,102 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/smartystreets/goconvey/convey"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func Test_FixedLengthCodec(t *testing.T) {
+	convey.Convey("Fixed 64-byte records should be read whole", t, func() {
+		fc := NewFixedLengthCodec(64)
+		input := strings.Repeat("a", 64) + strings.Repeat("b", 64)
+		r := bufio.NewReaderSize(iotest.OneByteReader(strings.NewReader(input)), 16)
+		for _, c := range "ab" {
+			p, err := fc.Read(r)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(p.(RawPacket).String(), convey.ShouldEqual, strings.Repeat(string(c), 64))
+		}
+		_, err := fc.Read(r)
+		convey.So(err, convey.ShouldEqual, io.EOF)
+
+		_, err = fc.Read(bufio.NewReader(strings.NewReader(strings.Repeat("a", 63))))
+		convey.So(err, convey.ShouldEqual, io.ErrUnexpectedEOF)
+	})
+
+	convey.Convey("Record sizes should follow the leading type byte", t, func() {
+		fc := NewFixedLengthCodec(0).SetRecordSize(0x01, 64).SetRecordSize(0x02, 128).SetRecordSize(0x03, 1)
+		records := []string{
+			"\x01" + strings.Repeat("x", 63),
+			"\x03",
+			"\x02" + strings.Repeat("y", 127),
+			"\x01" + strings.Repeat("z", 63),
+		}
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		for _, rec := range records {
+			p, err := fc.Marshal(StringMsg(rec))
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(fc.WriteBuffered(w, p), convey.ShouldBeNil)
+		}
+		w.Flush()
+		convey.So(out.String(), convey.ShouldEqual, strings.Join(records, ""))
+
+		r := bufio.NewReaderSize(iotest.HalfReader(bytes.NewReader(out.Bytes())), 16)
+		for _, rec := range records {
+			p, err := fc.Read(r)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(p.(RawPacket).String(), convey.ShouldEqual, rec)
+		}
+
+		sd := NewStreamDecoder(fc)
+		var decoded []string
+		for _, c := range out.Bytes() {
+			packets, err := sd.Feed([]byte{c})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range packets {
+				decoded = append(decoded, p.(RawPacket).String())
+			}
+		}
+		convey.So(decoded, convey.ShouldResemble, records)
+	})
+
+	convey.Convey("Decoded records should not alias the fed data", t, func() {
+		fc := NewFixedLengthCodec(0).SetRecordSize('A', 4).SetRecordSize('B', 2)
+		packets, err := feedAndOverwrite(fc, []byte("A123B1"))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(packets), convey.ShouldEqual, 2)
+		convey.So(packets[0].(RawPacket).String(), convey.ShouldEqual, "A123")
+		convey.So(packets[1].(RawPacket).String(), convey.ShouldEqual, "B1")
+	})
+
+	convey.Convey("Unknown types should use the default size or fail", t, func() {
+		strict := NewFixedLengthCodec(0).SetRecordSize('A', 4)
+		_, err := strict.Read(bufio.NewReader(strings.NewReader("B123")))
+		convey.So(err, convey.ShouldEqual, UnknownRecordTypeError)
+		_, err = NewStreamDecoder(strict).Feed([]byte("B123"))
+		convey.So(err, convey.ShouldEqual, UnknownRecordTypeError)
+
+		fallback := NewFixedLengthCodec(2).SetRecordSize('A', 4)
+		r := bufio.NewReader(strings.NewReader("A123B1"))
+		p, _ := fallback.Read(r)
+		convey.So(p.(RawPacket).String(), convey.ShouldEqual, "A123")
+		p, _ = fallback.Read(r)
+		convey.So(p.(RawPacket).String(), convey.ShouldEqual, "B1")
+	})
+
+	convey.Convey("Writes with the wrong size should be rejected", t, func() {
+		fc := NewFixedLengthCodec(0).SetRecordSize('A', 4)
+		w := bufio.NewWriter(io.Discard)
+		convey.So(fc.Write(w, NewRawPacket([]byte("A12"))), convey.ShouldEqual, RecordSizeError)
+		convey.So(fc.Write(w, NewRawPacket(nil)), convey.ShouldEqual, RecordSizeError)
+		convey.So(fc.Write(w, NewRawPacket([]byte("B123"))), convey.ShouldEqual, UnknownRecordTypeError)
+		convey.So(fc.Write(w, newFrameCheckPacket(1, "x")), convey.ShouldEqual, PacketNotRawError)
+		convey.So(fc.Write(w, NewRawPacket([]byte("A123"))), convey.ShouldBeNil)
+	})
+}
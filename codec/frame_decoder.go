@@ -0,0 +1,226 @@
+package codec
+
+import (
+	"bufio"
+	"github.com/sumory/gotty/buffer"
+	"io"
+)
+
+//FrameDecoder 增量解码接口，不依赖阻塞读取，可用于事件循环或以任意分段喂入数据做测试
+//DecodeFrame从in的读索引处解析一个包，数据不足一个包时返回nil, nil，此时可以已经跳过了无效的字节
+//in中的数据之后会被覆盖，返回的包不能引用它
+type FrameDecoder interface {
+	DecodeFrame(in *buffer.ByteBuf) (Packet, error)
+}
+
+//DecoderFactory 可选接口，需要在调用之间保存解析进度的codec为每个连接新建一个FrameDecoder
+//StreamDecoder和ReadState使用新建的FrameDecoder，它返回nil, nil之后，下次传入的数据以上次未消费的数据开头
+type DecoderFactory interface {
+	NewFrameDecoder() FrameDecoder
+}
+
+//connDecoder 一个连接使用的FrameDecoder
+func connDecoder(decoder FrameDecoder) FrameDecoder {
+	if df, ok := decoder.(DecoderFactory); ok {
+		return df.NewFrameDecoder()
+	}
+	return decoder
+}
+
+//FrameLimiter 可选接口，限制一个帧的最大字节数，返回0表示不限制
+//只在单个字段上校验长度的FrameDecoder实现它，ReadFrame和StreamDecoder累积的不完整数据超过该长度时返回PacketTooLargeError
+type FrameLimiter interface {
+	MaxFrameLength() int
+}
+
+//frameLimit decoder限制的帧长度，0表示不限制
+func frameLimit(decoder FrameDecoder) int {
+	if fl, ok := decoder.(FrameLimiter); ok {
+		return fl.MaxFrameLength()
+	}
+	return 0
+}
+
+//StreamDecoder 累积数据的增量解码器，每个连接一个，参考Netty的ByteToMessageDecoder
+//没有不完整的包时直接在喂入的数据上解析，只有剩余不足一个包的数据时才分配累积缓冲，空闲连接不占用读缓冲
+type StreamDecoder struct {
+	decoder    FrameDecoder
+	limit      int             //不完整的包的最大长度，0表示不限制
+	cumulation *buffer.ByteBuf //不完整的包，没有时为nil
+}
+
+//NewStreamDecoder 新建增量解码器，decoder实现FrameLimiter时累积的数据不超过其限制
+func NewStreamDecoder(decoder FrameDecoder) *StreamDecoder {
+	return &StreamDecoder{decoder: connDecoder(decoder), limit: frameLimit(decoder)}
+}
+
+//Feed 喂入新读到的数据，返回其中所有完整的包，不完整的部分复制保留到下次，data随后可以复用
+//返回错误时数据流已无法继续解析，应关闭连接
+func (sd *StreamDecoder) Feed(data []byte) ([]Packet, error) {
+	in := sd.cumulation
+	if in == nil {
+		in = buffer.WrapByteBuf(data)
+	} else {
+		in.WriteBytes(data)
+	}
+
+	var packets []Packet
+	var err error
+	for in.ReadableBytes() > 0 {
+		var p Packet
+		if p, err = sd.decoder.DecodeFrame(in); err != nil || p == nil {
+			break
+		}
+		packets = append(packets, p)
+	}
+	if err == nil && sd.limit > 0 && in.ReadableBytes() > sd.limit {
+		err = PacketTooLargeError
+	}
+
+	switch {
+	case in.ReadableBytes() == 0:
+		sd.cumulation = nil
+	case in != sd.cumulation:
+		sd.cumulation = in.Copy()
+	case in.ReaderIndex() > in.Capacity()/2:
+		//已读部分超过一半时整理，避免累积缓冲无限增长
+		in.DiscardReadBytes()
+	}
+	return packets, err
+}
+
+//Buffered 累积的尚未解析的字节数
+func (sd *StreamDecoder) Buffered() int {
+	if sd.cumulation == nil {
+		return 0
+	}
+	return sd.cumulation.ReadableBytes()
+}
+
+//Reset 丢弃累积的数据
+func (sd *StreamDecoder) Reset() {
+	sd.cumulation = nil
+}
+
+//ReadState 一个连接的读取状态，保存已从bufio.Reader取出、还没有解析的数据及解析进度，由session为每个连接持有
+//codec在多个连接间共享，不能自己保存这些数据
+type ReadState struct {
+	pending []byte
+	owner   FrameDecoder //decoder由owner新建
+	decoder FrameDecoder
+}
+
+//Buffered 保存的尚未解析的字节数
+func (rs *ReadState) Buffered() int {
+	if rs == nil {
+		return 0
+	}
+	return len(rs.pending)
+}
+
+//take 取出保存的数据
+func (rs *ReadState) take() []byte {
+	if rs == nil {
+		return nil
+	}
+	pending := rs.pending
+	rs.pending = nil
+	return pending
+}
+
+//keep 保存解析出包后剩余的数据，state为nil时丢弃
+func (rs *ReadState) keep(pending []byte) {
+	if rs != nil && len(pending) > 0 {
+		rs.pending = pending
+	}
+}
+
+//frameDecoder 该连接上解析decoder的帧使用的FrameDecoder，decoder实现DecoderFactory时新建一个保存下来
+func (rs *ReadState) frameDecoder(decoder FrameDecoder) FrameDecoder {
+	if _, ok := decoder.(DecoderFactory); !ok || rs == nil {
+		return connDecoder(decoder)
+	}
+	if rs.owner != decoder {
+		rs.owner, rs.decoder = decoder, connDecoder(decoder)
+	}
+	return rs.decoder
+}
+
+//StatefulReader 读取时可能从bufio.Reader多取出数据的codec，session为每个连接保存ReadState并调用ReadWithState代替Read
+//直接调用Read时多取出的数据会被丢弃
+type StatefulReader interface {
+	ReadWithState(bReader *bufio.Reader, state *ReadState) (Packet, error)
+}
+
+//ReadFrame 用FrameDecoder从阻塞的bufio.Reader中读取一个包，使增量解码器可以实现Codec.Read
+//数据尽量在bufio的缓冲中原地解析，包超过缓冲区大小时才复制累积，只消费属于该包的字节
+//每次等到新数据后按全部已缓冲的数据解析一次，大包的解析次数与读取次数相当
+func ReadFrame(bReader *bufio.Reader, decoder FrameDecoder) (Packet, error) {
+	return ReadFrameWithState(bReader, decoder, nil)
+}
+
+//ReadFrameWithState 同ReadFrame，先解析state中保存的数据，解析出包后累积数据中剩余的部分保存回state
+//decoder实现FrameLimiter时，不完整的包超过其限制返回PacketTooLargeError
+func ReadFrameWithState(bReader *bufio.Reader, decoder FrameDecoder, state *ReadState) (Packet, error) {
+	limit := frameLimit(decoder)
+	decoder = state.frameDecoder(decoder)
+	acc := state.take() //已从bReader取出但还不够一个包的数据
+	want := 1
+	if len(acc) > 0 {
+		//保存的数据中可能已有完整的包，先不等待新数据
+		want = 0
+	}
+	for {
+		_, err := bReader.Peek(want)
+		peek, _ := bReader.Peek(bReader.Buffered())
+		window := peek
+		if len(acc) > 0 {
+			//保留append扩容后的底层数组，acc增长的复制总量是线性的
+			window = append(acc, peek...)
+			acc = window[:len(acc)]
+		}
+
+		if len(window) > 0 {
+			in := buffer.WrapByteBuf(window)
+			p, derr := decoder.DecodeFrame(in)
+			if derr != nil {
+				return nil, derr
+			}
+			//解析消费或跳过的字节先从acc中扣除，其余从bReader中丢弃
+			skipped := in.ReaderIndex()
+			if skipped <= len(acc) {
+				acc = acc[skipped:]
+			} else {
+				bReader.Discard(skipped - len(acc))
+				peek = peek[skipped-len(acc):]
+				acc = nil
+			}
+			if p != nil {
+				state.keep(acc)
+				return p, nil
+			}
+			window = window[skipped:]
+			if limit > 0 && len(window) > limit {
+				return nil, PacketTooLargeError
+			}
+		}
+
+		switch {
+		case err == io.EOF && len(window) > 0:
+			return nil, io.ErrUnexpectedEOF
+		case err != nil:
+			return nil, err
+		case len(peek) >= bReader.Size():
+			//缓冲区已满仍不够一个包，移入acc后继续读
+			if len(acc) == 0 {
+				acc = append([]byte(nil), window...)
+			} else {
+				acc = window
+			}
+			bReader.Discard(len(peek))
+			want = 1
+		default:
+			want = len(peek) + 1
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/sumory/gotty/buffer"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func frameDecoderPackets() []LengthBasedPacket {
+	return []LengthBasedPacket{
+		newFrameCheckPacket(1, ""),
+		newFrameCheckPacket(2, "hello"),
+		newFrameCheckPacket(3, strings.Repeat("x", 100)),
+		newFrameCheckPacket(4, "world"),
+	}
+}
+
+//feedChunks 按chunk大小分段喂入，返回解出的包的body
+func feedChunks(sd *StreamDecoder, data []byte, chunk int) ([]string, error) {
+	var bodies []string
+	for i := 0; i < len(data); i += chunk {
+		end := i + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		packets, err := sd.Feed(data[i:end])
+		if err != nil {
+			return bodies, err
+		}
+		for _, p := range packets {
+			bodies = append(bodies, string(p.(LengthBasedPacket).Body.Data))
+		}
+	}
+	return bodies, nil
+}
+
+func Test_StreamDecoder(t *testing.T) {
+	packets := frameDecoderPackets()
+	var expected []string
+	for _, p := range packets {
+		expected = append(expected, string(p.Body.Data))
+	}
+
+	convey.Convey("Any chunking of the stream should decode the same packets", t, func() {
+		for _, lbc := range []*LengthBasedCodec{
+			NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil),
+			NewLengthBasedCodec(binary.LittleEndian, 64*1024, nil, nil).EnableZeroCopy(),
+			NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0xCAFEBABE),
+		} {
+			data := writeFrames(lbc, packets...)
+			for chunk := 1; chunk <= len(data); chunk++ {
+				sd := NewStreamDecoder(lbc)
+				bodies, err := feedChunks(sd, data, chunk)
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(bodies, convey.ShouldResemble, expected)
+				convey.So(sd.Buffered(), convey.ShouldEqual, 0)
+			}
+		}
+	})
+
+	convey.Convey("Partial frames should stay buffered", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		data := writeFrames(lbc, packets[1])
+		sd := NewStreamDecoder(lbc)
+		out, err := sd.Feed(data[:len(data)-1])
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(out), convey.ShouldEqual, 0)
+		convey.So(sd.Buffered(), convey.ShouldEqual, len(data)-1)
+		sd.Reset()
+		convey.So(sd.Buffered(), convey.ShouldEqual, 0)
+	})
+
+	convey.Convey("Checked decoder should resynchronize after garbage", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0xCAFEBABE)
+		first := writeFrames(lbc, newFrameCheckPacket(1, "hello"))
+		first[len(first)-6] ^= 0xFF
+		data := append([]byte("garbage"), first...)
+		data = append(data, writeFrames(lbc, newFrameCheckPacket(2, "world"))...)
+
+		for chunk := 1; chunk <= len(data); chunk++ {
+			bodies, err := feedChunks(NewStreamDecoder(lbc), data, chunk)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(bodies, convey.ShouldResemble, []string{"world"})
+		}
+	})
+
+	convey.Convey("Invalid lengths should fail the stream", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 64, nil, nil)
+		data := writeFrames(NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil), packets[2])
+		_, err := NewStreamDecoder(lbc).Feed(data)
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+	})
+}
+
+func Test_ReadFrame(t *testing.T) {
+	convey.Convey("ReadFrame should read packets through the incremental decoder", t, func() {
+		for _, lbc := range []*LengthBasedCodec{
+			NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil),
+			NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0xCAFEBABE),
+		} {
+			packets := frameDecoderPackets()
+			data := append([]byte("junk"), writeFrames(lbc, packets...)...)
+			if !lbc.frameCheck {
+				data = data[4:]
+			}
+			for _, r := range []io.Reader{
+				bytes.NewReader(data),
+				iotest.OneByteReader(bytes.NewReader(data)),
+				iotest.HalfReader(bytes.NewReader(data)),
+			} {
+				//最小的缓冲区，较大的包需要跨越多次填充
+				bReader := bufio.NewReaderSize(r, 16)
+				for _, expected := range packets {
+					p, err := ReadFrame(bReader, lbc)
+					convey.So(err, convey.ShouldBeNil)
+					convey.So(p.(LengthBasedPacket).Header.Sequence, convey.ShouldEqual, expected.Header.Sequence)
+					convey.So(string(p.(LengthBasedPacket).Body.Data), convey.ShouldEqual, string(expected.Body.Data))
+				}
+				_, err := ReadFrame(bReader, lbc)
+				convey.So(err, convey.ShouldEqual, io.EOF)
+			}
+		}
+	})
+
+	convey.Convey("Frames much larger than the buffer should be decoded once per fill", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 1<<20, nil, nil)
+		body := bytes.Repeat([]byte("z"), 256*1024)
+		p := newFrameCheckPacket(1, string(body))
+		data := writeFrames(lbc, p)
+		cd := &countingDecoder{decoder: lbc}
+		got, err := ReadFrame(bufio.NewReaderSize(bytes.NewReader(data), 4096), cd)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(got.(LengthBasedPacket).Body.Data, convey.ShouldResemble, body)
+		convey.So(cd.calls, convey.ShouldBeLessThan, 2*len(data)/4096+4)
+	})
+
+	convey.Convey("Frames taken from the reader together should not lose the bytes after the first one", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		packets := frameDecoderPackets()
+		//前两个包已在一次读取中取出，保存在state里，其余仍在reader中
+		held := writeFrames(lbc, packets[:2]...)
+		bReader := bufio.NewReaderSize(bytes.NewReader(writeFrames(lbc, packets[2:]...)), 16)
+		state := &ReadState{pending: held}
+		for _, expected := range packets {
+			p, err := ReadFrameWithState(bReader, lbc, state)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(p.(LengthBasedPacket).Header.Sequence, convey.ShouldEqual, expected.Header.Sequence)
+			convey.So(string(p.(LengthBasedPacket).Body.Data), convey.ShouldEqual, string(expected.Body.Data))
+		}
+		convey.So(state.Buffered(), convey.ShouldEqual, 0)
+		_, err := ReadFrameWithState(bReader, lbc, state)
+		convey.So(err, convey.ShouldEqual, io.EOF)
+	})
+
+	convey.Convey("Truncated stream should return ErrUnexpectedEOF", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		data := writeFrames(lbc, frameDecoderPackets()[2])
+		_, err := ReadFrame(bufio.NewReaderSize(bytes.NewReader(data[:50]), 16), lbc)
+		convey.So(err, convey.ShouldEqual, io.ErrUnexpectedEOF)
+	})
+}
+
+//countingDecoder 统计DecodeFrame的调用次数
+type countingDecoder struct {
+	decoder FrameDecoder
+	calls   int
+}
+
+func (cd *countingDecoder) DecodeFrame(in *buffer.ByteBuf) (Packet, error) {
+	cd.calls++
+	return cd.decoder.DecodeFrame(in)
+}
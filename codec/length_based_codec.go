@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/binary"
 	"fmt"
+	"github.com/sumory/gotty/buffer"
 	log "github.com/sumory/log4go"
 	"io"
 )
@@ -15,6 +16,12 @@ type LengthBasedCodec struct {
 	maxSize   int              //包最大长度
 	encoder   Encoder
 	decoder   Decoder
+
+	frameCheck bool       //是否启用同步标记和CRC32校验
+	magic      uint32     //同步标记
+	stats      FrameStats //帧校验统计
+
+	zeroCopy bool //解码时packet直接引用池化的帧缓冲
 }
 
 //NewLengthBasedCodec 新建定长编解码器
@@ -32,68 +39,72 @@ func (lbc *LengthBasedCodec) Name() string {
 	return lbc.name
 }
 
-//Read 从连接中读取packet
+//Read 从连接中读取packet，启用帧校验时重新同步多读出的数据会被丢弃，session使用ReadWithState
 func (lbc *LengthBasedCodec) Read(bReader *bufio.Reader) (Packet, error) {
-	//读包总大小
-	totalLen := make([]byte, packetBytesLen, packetBytesLen)
-	if _, err := io.ReadFull(bReader, totalLen); err != nil {
-		return nil, err
-	}
+	return lbc.ReadWithState(bReader, nil)
+}
 
-	tLen := lbc.byteOrder.Uint32(totalLen) //包总长度
-	if lbc.maxSize > 0 && int(tLen) > lbc.maxSize {
-		return nil, PacketTooLargeError
-	}
-	if tLen < packetMetaLen { //至少是codec.PacketMeta的大小
-		return nil, PacketTooSmallError
+//ReadWithState 实现StatefulReader，启用帧校验时大帧校验失败后已读出、还没有解析的数据保存在state中
+func (lbc *LengthBasedCodec) ReadWithState(bReader *bufio.Reader, state *ReadState) (Packet, error) {
+	if lbc.frameCheck {
+		return lbc.readChecked(bReader, state)
 	}
 
-	//读包头大小
-	headerLen := make([]byte, packetBytesLen, packetBytesLen)
-	if _, err := io.ReadFull(bReader, headerLen); err != nil {
+	//一次Peek取得总长度和包头长度
+	meta, err := bReader.Peek(packetMetaLen)
+	if err != nil {
 		return nil, err
 	}
-	hLen := lbc.byteOrder.Uint32(headerLen)
-	if hLen > tLen-packetMetaLen {
-		return nil, HeaderTooLargeError
+	tLen := lbc.byteOrder.Uint32(meta) //包总长度
+	if err := lbc.checkTotalLen(tLen); err != nil {
+		return nil, err
 	}
-	if hLen < packetMinHeaderLen {
-		return nil, HeaderTooSmallError
+	hLen := lbc.byteOrder.Uint32(meta[packetBytesLen:])
+	if err := checkHeaderLen(tLen, hLen); err != nil {
+		return nil, err
 	}
+	bReader.Discard(packetMetaLen)
 
-	headerAndBodyLen := tLen - packetMetaLen
-	headerAndBody := make([]byte, headerAndBodyLen)
-	tmp := headerAndBody
-	hasRead := 0
-	for {
-		log.Debug("read packget headerAndBody, hasRead: %d", hasRead)
-		onceRead, err := bReader.Read(tmp)
-		if err != nil {
-			return nil, err
-		}
-		hasRead += onceRead
-		log.Debug("read packget headerAndBody, hasRead: %d  onceRead: %d", hasRead, onceRead)
-		if hasRead < int(headerAndBodyLen) {
-			tmp = headerAndBody[hasRead:]
-			continue
-		} else {
-			break
-		}
+	frame := buffer.Get(int(tLen-packetMetaLen), 0)
+	if _, err := io.ReadFull(bReader, frame.Data); err != nil {
+		frame.Release()
+		return nil, err
 	}
+	return lbc.decode(tLen, hLen, frame.Data, frame)
+}
 
-	//组装packet
-	packet := LengthBasedPacket{}
-	if err := packet.Decode(lbc.byteOrder, tLen, hLen, headerAndBody); err != nil {
-		return nil, err
+//checkTotalLen 校验包总长度
+func (lbc *LengthBasedCodec) checkTotalLen(tLen uint32) error {
+	if lbc.maxSize > 0 && int(tLen) > lbc.maxSize {
+		return PacketTooLargeError
 	}
+	if tLen < packetMetaLen { //至少是codec.PacketMeta的大小
+		return PacketTooSmallError
+	}
+	return nil
+}
 
-	log.Debug("read packet data, totallen: %d, headerLen: %d, headerAndBody: %v， packet.Header.Extra:%v",
-		tLen, hLen, headerAndBody, packet.Header.Extra)
-	return packet, nil
+//checkHeaderLen 校验包头长度
+func checkHeaderLen(tLen, hLen uint32) error {
+	if hLen > tLen-packetMetaLen {
+		return HeaderTooLargeError
+	}
+	if hLen < packetMinHeaderLen {
+		return HeaderTooSmallError
+	}
+	return nil
 }
 
 //Write 将包写出
 func (lbc *LengthBasedCodec) Write(bWriter *bufio.Writer, lbp Packet) error {
+	if err := lbc.WriteBuffered(bWriter, lbp); err != nil {
+		return err
+	}
+	return bWriter.Flush()
+}
+
+//WriteBuffered 将包写入bWriter但不flush
+func (lbc *LengthBasedCodec) WriteBuffered(bWriter *bufio.Writer, lbp Packet) error {
 	p, ok := lbp.(LengthBasedPacket)
 	if !ok {
 		return fmt.Errorf("packet is not length based")
@@ -102,13 +113,15 @@ func (lbc *LengthBasedCodec) Write(bWriter *bufio.Writer, lbp Packet) error {
 		return PacketTooLargeError
 	}
 
-	pBytes, err := p.Encode(lbc.byteOrder)
-	log.Debug("write packet, length: %d, value: %v", len(pBytes), pBytes)
-
-	if err != nil {
-		log.Warn("packet encode error, %s", err)
-		return err
+	size := int(p.Meta.TotalLen)
+	if lbc.frameCheck {
+		size += frameMarkerLen + frameChecksumLen
 	}
+	bf := buffer.Get(0, size)
+	defer bf.Release()
+	lbc.encodeFrame(p, bf)
+	pBytes := bf.Data
+	log.Debug("write packet, length: %d, value: %v", len(pBytes), pBytes)
 
 	tmp := pBytes
 	pBytesLen := len(pBytes)
@@ -134,8 +147,6 @@ func (lbc *LengthBasedCodec) Write(bWriter *bufio.Writer, lbp Packet) error {
 			break
 		}
 	}
-
-	bWriter.Flush()
 	return nil
 }
 
@@ -0,0 +1,115 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/smartystreets/goconvey/convey"
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test_LengthBasedCodecPooled(t *testing.T) {
+	convey.Convey("Decoded packets should not share the pooled read buffer", t, func() {
+		for _, lbc := range []*LengthBasedCodec{
+			NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil),
+			NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0xCAFEBABE),
+		} {
+			first := newFrameCheckPacket(1, strings.Repeat("a", 8192))
+			second := newFrameCheckPacket(2, strings.Repeat("b", 8192))
+			r := bufio.NewReaderSize(bytes.NewReader(writeFrames(lbc, first, second)), 4096)
+
+			p1, err := lbc.Read(r)
+			convey.So(err, convey.ShouldBeNil)
+			p2, err := lbc.Read(r)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(string(p1.(LengthBasedPacket).Body.Data), convey.ShouldEqual, string(first.Body.Data))
+			convey.So(string(p2.(LengthBasedPacket).Body.Data), convey.ShouldEqual, string(second.Body.Data))
+		}
+	})
+}
+
+func Test_LengthBasedCodecZeroCopy(t *testing.T) {
+	convey.Convey("Zero-copy packets should share a pooled frame until released", t, func() {
+		for _, lbc := range []*LengthBasedCodec{
+			NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableZeroCopy(),
+			NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0xCAFEBABE).EnableZeroCopy(),
+		} {
+			small := newFrameCheckPacket(1, "small")
+			large := newFrameCheckPacket(2, strings.Repeat("L", 8192))
+			r := bufio.NewReaderSize(bytes.NewReader(writeFrames(lbc, small, large)), 4096)
+
+			p, err := lbc.Read(r)
+			convey.So(err, convey.ShouldBeNil)
+			lbp := p.(LengthBasedPacket)
+			convey.So(lbp.Header.Sequence, convey.ShouldEqual, 1)
+			convey.So(string(lbp.Header.Extra), convey.ShouldEqual, "extra")
+			convey.So(cap(lbp.Header.Extra), convey.ShouldEqual, 5)
+			convey.So(string(lbp.Body.Data), convey.ShouldEqual, "small")
+			convey.So(lbp.frame.RefCount(), convey.ShouldEqual, 1)
+			convey.So(&lbp.Body.Data[0], convey.ShouldEqual, &lbp.frame.Data[len(lbp.frame.Data)-5])
+
+			lbp.Retain()
+			lbp.Release()
+			convey.So(lbp.frame.RefCount(), convey.ShouldEqual, 1)
+			lbp.Release()
+			convey.So(lbp.frame.RefCount(), convey.ShouldEqual, 0)
+
+			p, err = lbc.Read(r)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(string(p.(LengthBasedPacket).Body.Data), convey.ShouldEqual, string(large.Body.Data))
+			p.(Releaser).Release()
+		}
+	})
+
+	convey.Convey("Truncated input should return an error and not leak the frame", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableZeroCopy()
+		data := writeFrames(lbc, newFrameCheckPacket(1, "payload"))
+		_, err := lbc.Read(bufio.NewReader(bytes.NewReader(data[:len(data)-1])))
+		convey.So(err, convey.ShouldEqual, io.ErrUnexpectedEOF)
+		_, err = lbc.Read(bufio.NewReader(bytes.NewReader(data[:5])))
+		convey.So(err, convey.ShouldEqual, io.EOF)
+	})
+}
+
+//repeatReader 无限重复同一段数据
+type repeatReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.data[r.pos:])
+		n += c
+		r.pos = (r.pos + c) % len(r.data)
+	}
+	return n, nil
+}
+
+func benchmarkCodecRead(b *testing.B, size int, zeroCopy bool) {
+	lbc := NewLengthBasedCodec(binary.BigEndian, 1024*1024, nil, nil)
+	if zeroCopy {
+		lbc.EnableZeroCopy()
+	}
+	p := newFrameCheckPacket(1, strings.Repeat("x", size))
+	var r io.Reader = &repeatReader{data: writeFrames(lbc, p)}
+	bReader := bufio.NewReaderSize(r, 64*1024)
+	b.SetBytes(int64(p.Meta.TotalLen))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		p, err := lbc.Read(bReader)
+		if err != nil {
+			b.Fatal(err)
+		}
+		p.(Releaser).Release()
+	}
+}
+
+func BenchmarkLengthBasedRead_64B(b *testing.B)          { benchmarkCodecRead(b, 64, false) }
+func BenchmarkLengthBasedRead_ZeroCopy_64B(b *testing.B) { benchmarkCodecRead(b, 64, true) }
+func BenchmarkLengthBasedRead_16K(b *testing.B)          { benchmarkCodecRead(b, 16*1024, false) }
+func BenchmarkLengthBasedRead_ZeroCopy_16K(b *testing.B) { benchmarkCodecRead(b, 16*1024, true) }
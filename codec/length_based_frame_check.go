@@ -0,0 +1,155 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/sumory/gotty/buffer"
+	log "github.com/sumory/log4go"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+)
+
+const (
+	frameMarkerLen   = 4 //同步标记长度
+	frameChecksumLen = 4 //CRC32长度
+)
+
+//FrameStats 帧校验统计
+type FrameStats struct {
+	DroppedFrames uint64 //标记、长度或CRC校验失败而丢弃的帧数
+	SkippedBytes  uint64 //重新同步时跳过的字节数
+}
+
+//EnableFrameCheck 启用带同步标记和CRC32尾部的帧格式，收发双方需使用相同的magic
+//帧格式: magic(4) + packet + crc32(4)，crc32覆盖packet部分
+//读取时遇到标记或校验失败不会返回错误，而是向后扫描到下一个有效的同步标记
+func (lbc *LengthBasedCodec) EnableFrameCheck(magic uint32) *LengthBasedCodec {
+	lbc.frameCheck = true
+	lbc.magic = magic
+	return lbc
+}
+
+//FrameStats 获取帧校验统计
+func (lbc *LengthBasedCodec) FrameStats() FrameStats {
+	return FrameStats{
+		DroppedFrames: atomic.LoadUint64(&lbc.stats.DroppedFrames),
+		SkippedBytes:  atomic.LoadUint64(&lbc.stats.SkippedBytes),
+	}
+}
+
+//encodeFrame 将packet编码到bf中，启用帧校验时加上同步标记和CRC32
+func (lbc *LengthBasedCodec) encodeFrame(p LengthBasedPacket, bf *buffer.Buffer) {
+	if !lbc.frameCheck {
+		p.encodeTo(lbc.byteOrder, bf)
+		return
+	}
+	start := len(bf.Data)
+	bf.Data = appendUint32(bf.Data, lbc.byteOrder, lbc.magic)
+	p.encodeTo(lbc.byteOrder, bf)
+	crc := crc32.ChecksumIEEE(bf.Data[start+frameMarkerLen:])
+	bf.Data = appendUint32(bf.Data, lbc.byteOrder, crc)
+}
+
+//readChecked 读取带校验的帧，校验失败时重新同步
+func (lbc *LengthBasedCodec) readChecked(bReader *bufio.Reader, state *ReadState) (Packet, error) {
+	if state.Buffered() > 0 {
+		return ReadFrameWithState(bReader, lbc, state)
+	}
+	marker := make([]byte, frameMarkerLen)
+	lbc.byteOrder.PutUint32(marker, lbc.magic)
+
+	for {
+		head, err := bReader.Peek(frameMarkerLen + packetMetaLen)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(head[:frameMarkerLen], marker) {
+			lbc.resync(bReader, marker)
+			continue
+		}
+
+		tLen := lbc.byteOrder.Uint32(head[frameMarkerLen:])
+		hLen := lbc.byteOrder.Uint32(head[frameMarkerLen+packetBytesLen:])
+		if err = lbc.checkTotalLen(tLen); err == nil {
+			err = checkHeaderLen(tLen, hLen)
+		}
+		if err != nil {
+			log.Warn("frame check failed, tLen: %d, hLen: %d, err: %s", tLen, hLen, err)
+			lbc.dropFrame(bReader)
+			continue
+		}
+
+		frameLen := frameMarkerLen + int(tLen) + frameChecksumLen
+		var frame []byte
+		var bf *buffer.Buffer
+		if frameLen <= bReader.Size() {
+			//帧可以完整放入缓冲区，校验失败时只跳过标记继续扫描
+			if frame, err = bReader.Peek(frameLen); err != nil {
+				return nil, err
+			}
+			if !lbc.checksumOK(frame) {
+				log.Warn("frame crc32 mismatch, tLen: %d", tLen)
+				lbc.dropFrame(bReader)
+				continue
+			}
+			bReader.Discard(frameLen)
+		} else {
+			//超过缓冲区大小的帧只能整体读出，校验失败时整帧丢弃
+			bf = buffer.Get(frameLen, frameLen)
+			frame = bf.Data
+			if _, err = io.ReadFull(bReader, frame); err != nil {
+				bf.Release()
+				return nil, err
+			}
+			if !lbc.checksumOK(frame) {
+				//长度可能已损坏，读出的数据中可能还有之后的有效帧，从下一个字节开始重新扫描
+				log.Warn("frame crc32 mismatch, tLen: %d", tLen)
+				rest := append([]byte(nil), frame[1:]...)
+				bf.Release()
+				atomic.AddUint64(&lbc.stats.DroppedFrames, 1)
+				atomic.AddUint64(&lbc.stats.SkippedBytes, 1)
+				return lbc.readLeftover(bReader, state, rest)
+			}
+		}
+
+		headerAndBody := frame[frameMarkerLen+packetMetaLen : frameLen-frameChecksumLen]
+		return lbc.decode(tLen, hLen, headerAndBody, bf)
+	}
+}
+
+//readLeftover 在已从bReader读出的数据中重新同步并解析，不够一帧时继续读取
+//解析出包后剩余的数据保存在state中，下次读取时先解析；state为nil时丢弃
+func (lbc *LengthBasedCodec) readLeftover(bReader *bufio.Reader, state *ReadState, rest []byte) (Packet, error) {
+	if state == nil {
+		state = &ReadState{}
+	}
+	state.pending = rest
+	return ReadFrameWithState(bReader, lbc, state)
+}
+
+func (lbc *LengthBasedCodec) checksumOK(frame []byte) bool {
+	payload := frame[frameMarkerLen : len(frame)-frameChecksumLen]
+	return lbc.byteOrder.Uint32(frame[len(frame)-frameChecksumLen:]) == crc32.ChecksumIEEE(payload)
+}
+
+//dropFrame 丢弃当前标记，从下一个字节开始重新同步
+func (lbc *LengthBasedCodec) dropFrame(bReader *bufio.Reader) {
+	atomic.AddUint64(&lbc.stats.DroppedFrames, 1)
+	bReader.Discard(1)
+	atomic.AddUint64(&lbc.stats.SkippedBytes, 1)
+}
+
+//resync 在已缓冲的数据中查找下一个同步标记，跳过之前的字节
+func (lbc *LengthBasedCodec) resync(bReader *bufio.Reader, marker []byte) {
+	buffered, _ := bReader.Peek(bReader.Buffered())
+	skip := 1
+	if i := bytes.Index(buffered[1:], marker); i >= 0 {
+		skip += i
+	} else if len(buffered) > frameMarkerLen {
+		//标记可能跨越缓冲区末尾，保留最后不足一个标记长度的字节
+		skip = len(buffered) - frameMarkerLen + 1
+	}
+	n, _ := bReader.Discard(skip)
+	atomic.AddUint64(&lbc.stats.SkippedBytes, uint64(n))
+}
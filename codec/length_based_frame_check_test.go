@@ -0,0 +1,127 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/smartystreets/goconvey/convey"
+	"io"
+	"testing"
+)
+
+func newFrameCheckPacket(seq uint32, data string) LengthBasedPacket {
+	header := &LengthBasedPacketHeader{
+		Sequence:  seq,
+		Operation: 1,
+		Version:   0,
+		Extra:     []byte("extra"),
+	}
+	body := &LengthBasedPacketBody{
+		Data: []byte(data),
+	}
+	meta := &LengthBasedPacketMeta{
+		TotalLen:  uint32(8 + header.Len() + body.Len()),
+		HeaderLen: uint32(header.Len()),
+	}
+	return LengthBasedPacket{Meta: meta, Header: header, Body: body}
+}
+
+func writeFrames(lbc *LengthBasedCodec, packets ...LengthBasedPacket) []byte {
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	for _, p := range packets {
+		lbc.Write(w, p)
+	}
+	w.Flush()
+	return out.Bytes()
+}
+
+func Test_LengthBasedFrameCheck(t *testing.T) {
+	convey.Convey("Checked frames should round trip", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0xCAFEBABE)
+		data := writeFrames(lbc, newFrameCheckPacket(1, "a"), newFrameCheckPacket(2, "bb"))
+
+		r := bufio.NewReader(bytes.NewReader(data))
+		p, err := lbc.Read(r)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.(LengthBasedPacket).Header.Sequence, convey.ShouldEqual, 1)
+		p, err = lbc.Read(r)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(string(p.(LengthBasedPacket).Body.Data), convey.ShouldEqual, "bb")
+		_, err = lbc.Read(r)
+		convey.So(err, convey.ShouldEqual, io.EOF)
+		convey.So(lbc.FrameStats().DroppedFrames, convey.ShouldEqual, 0)
+	})
+
+	convey.Convey("Corrupted frame should be dropped and stream resynchronized", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0xCAFEBABE)
+		first := writeFrames(lbc, newFrameCheckPacket(1, "hello"))
+		second := writeFrames(lbc, newFrameCheckPacket(2, "world"))
+		first[len(first)-6] ^= 0xFF //破坏body
+
+		data := append([]byte("garbage"), first...)
+		data = append(data, second...)
+		r := bufio.NewReader(bytes.NewReader(data))
+		p, err := lbc.Read(r)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.(LengthBasedPacket).Header.Sequence, convey.ShouldEqual, 2)
+		convey.So(string(p.(LengthBasedPacket).Body.Data), convey.ShouldEqual, "world")
+
+		stats := lbc.FrameStats()
+		convey.So(stats.DroppedFrames, convey.ShouldEqual, 1)
+		convey.So(stats.SkippedBytes, convey.ShouldEqual, len("garbage")+len(first))
+	})
+
+	convey.Convey("Corrupted length prefix should not misframe following packets", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0xCAFEBABE)
+		first := writeFrames(lbc, newFrameCheckPacket(1, "hello"))
+		second := writeFrames(lbc, newFrameCheckPacket(2, "world"))
+		first[frameMarkerLen+3] += 3 //包总长度被篡改
+
+		r := bufio.NewReader(bytes.NewReader(append(first, second...)))
+		p, err := lbc.Read(r)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.(LengthBasedPacket).Header.Sequence, convey.ShouldEqual, 2)
+		convey.So(lbc.FrameStats().DroppedFrames, convey.ShouldEqual, 1)
+	})
+
+	convey.Convey("Frames larger than the read buffer should be checked too", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0xCAFEBABE)
+		big := string(bytes.Repeat([]byte("x"), 8*1024))
+		first := writeFrames(lbc, newFrameCheckPacket(1, big))
+		second := writeFrames(lbc, newFrameCheckPacket(2, big))
+		first[100] ^= 0xFF
+
+		r := bufio.NewReaderSize(bytes.NewReader(append(first, second...)), 4096)
+		p, err := lbc.Read(r)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.(LengthBasedPacket).Header.Sequence, convey.ShouldEqual, 2)
+		convey.So(lbc.FrameStats().DroppedFrames, convey.ShouldEqual, 1)
+	})
+}
+
+func Test_LengthBasedFrameCheckLeftover(t *testing.T) {
+	convey.Convey("A corrupted length larger than the read buffer should not swallow the following frames", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0xCAFEBABE)
+		data := writeFrames(lbc, newFrameCheckPacket(1, "hello"))
+		binary.BigEndian.PutUint32(data[frameMarkerLen:], 6000)
+		body := string(bytes.Repeat([]byte("y"), 2000))
+		for seq := uint32(2); seq <= 6; seq++ {
+			data = append(data, writeFrames(lbc, newFrameCheckPacket(seq, body))...)
+		}
+
+		r := bufio.NewReaderSize(bytes.NewReader(data), 4096)
+		state := &ReadState{}
+		for seq := uint32(2); seq <= 6; seq++ {
+			p, err := lbc.ReadWithState(r, state)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(p.(LengthBasedPacket).Header.Sequence, convey.ShouldEqual, seq)
+			convey.So(string(p.(LengthBasedPacket).Body.Data), convey.ShouldEqual, body)
+		}
+		_, err := lbc.ReadWithState(r, state)
+		convey.So(err, convey.ShouldEqual, io.EOF)
+		convey.So(lbc.FrameStats().DroppedFrames, convey.ShouldEqual, 1)
+		//只跳过被丢弃的首帧
+		convey.So(lbc.FrameStats().SkippedBytes, convey.ShouldEqual, uint64(len(writeFrames(lbc, newFrameCheckPacket(1, "hello")))))
+	})
+}
@@ -0,0 +1,85 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/sumory/gotty/buffer"
+	"sync/atomic"
+)
+
+//DecodeFrame 实现FrameDecoder，启用帧校验时跳过无效的字节重新同步
+func (lbc *LengthBasedCodec) DecodeFrame(in *buffer.ByteBuf) (Packet, error) {
+	if lbc.frameCheck {
+		return lbc.decodeCheckedFrame(in)
+	}
+	if !in.IsReadable(packetMetaLen) {
+		return nil, nil
+	}
+
+	meta := in.Peek(packetMetaLen)
+	tLen := lbc.byteOrder.Uint32(meta)
+	if err := lbc.checkTotalLen(tLen); err != nil {
+		return nil, err
+	}
+	hLen := lbc.byteOrder.Uint32(meta[packetBytesLen:])
+	if err := checkHeaderLen(tLen, hLen); err != nil {
+		return nil, err
+	}
+	if !in.IsReadable(int(tLen)) {
+		return nil, nil
+	}
+	in.Skip(packetMetaLen)
+	return lbc.decode(tLen, hLen, in.ReadBytes(int(tLen)-packetMetaLen), nil)
+}
+
+//decodeCheckedFrame 解析带同步标记和CRC32的帧，与readChecked的处理一致
+func (lbc *LengthBasedCodec) decodeCheckedFrame(in *buffer.ByteBuf) (Packet, error) {
+	marker := make([]byte, frameMarkerLen)
+	lbc.byteOrder.PutUint32(marker, lbc.magic)
+
+	for in.IsReadable(frameMarkerLen + packetMetaLen) {
+		head := in.Peek(frameMarkerLen + packetMetaLen)
+		if !bytes.Equal(head[:frameMarkerLen], marker) {
+			skip := 1
+			if i := bytes.Index(in.Bytes()[1:], marker); i >= 0 {
+				skip += i
+			} else {
+				//标记可能还没收全，保留最后不足一个标记长度的字节
+				skip = in.ReadableBytes() - frameMarkerLen + 1
+			}
+			in.Skip(skip)
+			atomic.AddUint64(&lbc.stats.SkippedBytes, uint64(skip))
+			continue
+		}
+
+		tLen := lbc.byteOrder.Uint32(head[frameMarkerLen:])
+		hLen := lbc.byteOrder.Uint32(head[frameMarkerLen+packetBytesLen:])
+		err := lbc.checkTotalLen(tLen)
+		if err == nil {
+			err = checkHeaderLen(tLen, hLen)
+		}
+		if err != nil {
+			lbc.skipFrame(in)
+			continue
+		}
+
+		frameLen := frameMarkerLen + int(tLen) + frameChecksumLen
+		if !in.IsReadable(frameLen) {
+			return nil, nil
+		}
+		frame := in.Peek(frameLen)
+		if !lbc.checksumOK(frame) {
+			lbc.skipFrame(in)
+			continue
+		}
+		in.Skip(frameLen)
+		return lbc.decode(tLen, hLen, frame[frameMarkerLen+packetMetaLen:frameLen-frameChecksumLen], nil)
+	}
+	return nil, nil
+}
+
+//skipFrame 丢弃当前标记，从下一个字节开始重新同步
+func (lbc *LengthBasedCodec) skipFrame(in *buffer.ByteBuf) {
+	atomic.AddUint64(&lbc.stats.DroppedFrames, 1)
+	in.Skip(1)
+	atomic.AddUint64(&lbc.stats.SkippedBytes, 1)
+}
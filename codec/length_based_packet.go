@@ -56,6 +56,16 @@ type LengthBasedPacket struct {
 	Meta   *LengthBasedPacketMeta
 	Header *LengthBasedPacketHeader
 	Body   *LengthBasedPacketBody
+
+	frame *buffer.Buffer //零拷贝解码时Extra和Body所在的池化缓冲
+}
+
+//Len 编码后的总长度
+func (packet LengthBasedPacket) Len() int {
+	if packet.Meta == nil {
+		return 0
+	}
+	return int(packet.Meta.TotalLen)
 }
 
 //NewPacket 新建packet
@@ -116,7 +126,7 @@ func NewLengthBasedPacketFromBinary(bo binary.ByteOrder, data []byte) *LengthBas
 	return p
 }
 
-func (packet LengthBasedPacket) Decode(bo binary.ByteOrder, totalLen, headerLen uint32, headerAndBody []byte) error {
+func (packet *LengthBasedPacket) Decode(bo binary.ByteOrder, totalLen, headerLen uint32, headerAndBody []byte) error {
 	meta := &LengthBasedPacketMeta{
 		TotalLen:  totalLen,
 		HeaderLen: headerLen,
@@ -144,9 +154,14 @@ func (packet LengthBasedPacket) Decode(bo binary.ByteOrder, totalLen, headerLen
 }
 
 func (packet LengthBasedPacket) Encode(bo binary.ByteOrder) ([]byte, error) {
-	tLen := packet.Meta.TotalLen
-	bf := buffer.NewBuffer(0, int(tLen))
+	bf := buffer.NewBuffer(0, int(packet.Meta.TotalLen))
+	packet.encodeTo(bo, bf)
+	return bf.Data[:], nil
+}
 
+//encodeTo 将packet追加写入bf
+func (packet LengthBasedPacket) encodeTo(bo binary.ByteOrder, bf *buffer.Buffer) {
+	tLen := packet.Meta.TotalLen
 	if bo == binary.BigEndian {
 		bf.WriteUint32BE(tLen)
 		bf.WriteUint32BE(packet.Meta.HeaderLen)
@@ -168,8 +183,6 @@ func (packet LengthBasedPacket) Encode(bo binary.ByteOrder) ([]byte, error) {
 	bf.Write(packet.Header.Extra)
 	//写body
 	bf.Write(packet.Body.Data)
-
-	return bf.Data[:], nil
 }
 
 func (packet LengthBasedPacket) Transform(m Message) error {
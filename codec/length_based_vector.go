@@ -0,0 +1,68 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"net"
+)
+
+//vectorBodyThreshold 包体不小于该长度时单独作为一段写出，否则复制到包头段中，避免过多的小段
+const vectorBodyThreshold = 512
+
+//AppendBuffers 将包编码为包头和包体两段追加到bufs，包体不复制
+func (lbc *LengthBasedCodec) AppendBuffers(bufs net.Buffers, lbp Packet) (net.Buffers, error) {
+	p, ok := lbp.(LengthBasedPacket)
+	if !ok {
+		return bufs, fmt.Errorf("packet is not length based")
+	}
+	if lbc.maxSize > 0 && int(p.Meta.TotalLen) > lbc.maxSize {
+		return bufs, PacketTooLargeError
+	}
+
+	body := p.Body.Data
+	headLen := frameMarkerLen + packetMetaLen + packetMinHeaderLen + len(p.Header.Extra) + frameChecksumLen
+	if len(body) < vectorBodyThreshold {
+		headLen += len(body)
+	}
+	head := make([]byte, 0, headLen)
+	if lbc.frameCheck {
+		head = appendUint32(head, lbc.byteOrder, lbc.magic)
+	}
+	head = appendUint32(head, lbc.byteOrder, p.Meta.TotalLen)
+	head = appendUint32(head, lbc.byteOrder, p.Meta.HeaderLen)
+	head = appendUint32(head, lbc.byteOrder, p.Header.Sequence)
+	head = appendUint16(head, lbc.byteOrder, p.Header.Operation)
+	head = appendUint16(head, lbc.byteOrder, p.Header.Version)
+	head = append(head, p.Header.Extra...)
+	if len(body) < vectorBodyThreshold {
+		head = append(head, body...)
+		body = nil
+	}
+
+	if !lbc.frameCheck {
+		if body == nil {
+			return append(bufs, head), nil
+		}
+		return append(bufs, head, body), nil
+	}
+
+	crc := crc32.ChecksumIEEE(head[frameMarkerLen:])
+	crc = crc32.Update(crc, crc32.IEEETable, body)
+	if body == nil {
+		return append(bufs, appendUint32(head, lbc.byteOrder, crc)), nil
+	}
+	return append(bufs, head, body, appendUint32(make([]byte, 0, frameChecksumLen), lbc.byteOrder, crc)), nil
+}
+
+func appendUint32(b []byte, bo binary.ByteOrder, v uint32) []byte {
+	var tmp [4]byte
+	bo.PutUint32(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+func appendUint16(b []byte, bo binary.ByteOrder, v uint16) []byte {
+	var tmp [2]byte
+	bo.PutUint16(tmp[:], v)
+	return append(b, tmp[:]...)
+}
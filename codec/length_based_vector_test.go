@@ -0,0 +1,71 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_LengthBasedVector(t *testing.T) {
+	small := newFrameCheckPacket(1, "small")
+	large := newFrameCheckPacket(2, strings.Repeat("L", 4096))
+
+	convey.Convey("Vectored encoding should match buffered encoding", t, func() {
+		for _, lbc := range []*LengthBasedCodec{
+			NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil),
+			NewLengthBasedCodec(binary.LittleEndian, 64*1024, nil, nil),
+			NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0xCAFEBABE),
+		} {
+			bufs, err := lbc.AppendBuffers(nil, small)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(bufs), convey.ShouldEqual, 1)
+			bufs, err = lbc.AppendBuffers(bufs, large)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(bufs), convey.ShouldBeGreaterThan, 2)
+			//大的包体直接引用
+			convey.So(&bufs[2][0], convey.ShouldEqual, &large.Body.Data[0])
+
+			var out bytes.Buffer
+			bufs.WriteTo(&out)
+			convey.So(out.Bytes(), convey.ShouldResemble, writeFrames(lbc, small, large))
+		}
+	})
+
+	convey.Convey("Oversized packet should be rejected", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 1024, nil, nil)
+		bufs, err := lbc.AppendBuffers(nil, large)
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+		convey.So(len(bufs), convey.ShouldEqual, 0)
+	})
+}
+
+func benchmarkCodecWrite(b *testing.B, size int, batch int, vectored bool) {
+	lbc := NewLengthBasedCodec(binary.BigEndian, 1024*1024, nil, nil)
+	p := newFrameCheckPacket(1, strings.Repeat("x", size))
+	w := bufio.NewWriterSize(ioutil.Discard, 64*1024)
+	b.SetBytes(int64(p.Meta.TotalLen))
+	b.ReportAllocs()
+
+	var bufs net.Buffers
+	for i := 0; i < b.N; i++ {
+		if vectored {
+			bufs, _ = lbc.AppendBuffers(bufs, p)
+		} else {
+			lbc.Write(w, p)
+		}
+		if vectored && (i+1)%batch == 0 {
+			bufs.WriteTo(ioutil.Discard)
+			bufs = bufs[:0]
+		}
+	}
+}
+
+func BenchmarkLengthBasedWrite_64B(b *testing.B)         { benchmarkCodecWrite(b, 64, 1, false) }
+func BenchmarkLengthBasedAppendBuffers_64B(b *testing.B) { benchmarkCodecWrite(b, 64, 64, true) }
+func BenchmarkLengthBasedWrite_16K(b *testing.B)         { benchmarkCodecWrite(b, 16*1024, 1, false) }
+func BenchmarkLengthBasedAppendBuffers_16K(b *testing.B) { benchmarkCodecWrite(b, 16*1024, 64, true) }
@@ -0,0 +1,73 @@
+package codec
+
+import (
+	"github.com/sumory/gotty/buffer"
+)
+
+//EnableZeroCopy 启用零拷贝解码：每个包从池中取一块帧缓冲，Extra和Body直接引用其中的数据
+//包处理完后需调用Release归还，session在处理函数返回或包被丢弃后会自动调用
+//处理函数返回后还要使用包数据时，需先调用Retain，用完再Release；通过session写出的包由session持有到写出为止
+//被filter吞掉或替换的包不会归还，由GC回收，不影响正确性
+func (lbc *LengthBasedCodec) EnableZeroCopy() *LengthBasedCodec {
+	lbc.zeroCopy = true
+	return lbc
+}
+
+//lengthBasedParts 零拷贝解码时packet的三部分一次分配
+type lengthBasedParts struct {
+	meta   LengthBasedPacketMeta
+	header LengthBasedPacketHeader
+	body   LengthBasedPacketBody
+}
+
+//decode 由headerAndBody组装packet，frame为headerAndBody所在的池化缓冲，可以为nil
+//零拷贝模式下packet引用frame，frame为nil时先复制到池化缓冲；否则复制数据后归还frame
+func (lbc *LengthBasedCodec) decode(tLen, hLen uint32, headerAndBody []byte, frame *buffer.Buffer) (Packet, error) {
+	packet := LengthBasedPacket{}
+	if !lbc.zeroCopy {
+		err := packet.Decode(lbc.byteOrder, tLen, hLen, headerAndBody)
+		if frame != nil {
+			frame.Release()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return packet, nil
+	}
+
+	if frame == nil {
+		frame = buffer.Get(len(headerAndBody), 0)
+		copy(frame.Data, headerAndBody)
+		headerAndBody = frame.Data
+	}
+	parts := &lengthBasedParts{}
+	parts.meta.TotalLen = tLen
+	parts.meta.HeaderLen = hLen
+	parts.header.Sequence = lbc.byteOrder.Uint32(headerAndBody[0:4])
+	parts.header.Operation = lbc.byteOrder.Uint16(headerAndBody[4:6])
+	parts.header.Version = lbc.byteOrder.Uint16(headerAndBody[6:8])
+	//限制容量，append时不会覆盖后面的数据
+	parts.header.Extra = headerAndBody[packetMinHeaderLen:hLen:hLen]
+	parts.body.Data = headerAndBody[hLen:]
+
+	packet.Meta = &parts.meta
+	packet.Header = &parts.header
+	packet.Body = &parts.body
+	packet.frame = frame
+	return packet, nil
+}
+
+//Release 归还零拷贝解码引用的帧缓冲，之后不能再访问Extra和Body，非零拷贝的包调用无影响
+func (packet LengthBasedPacket) Release() {
+	if packet.frame != nil {
+		packet.frame.Release()
+	}
+}
+
+//Retain 增加帧缓冲的引用计数，每次Retain需对应一次Release
+func (packet LengthBasedPacket) Retain() LengthBasedPacket {
+	if packet.frame != nil {
+		packet.frame.Retain()
+	}
+	return packet
+}
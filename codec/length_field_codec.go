@@ -0,0 +1,227 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/sumory/gotty/buffer"
+	"github.com/sumory/gotty/utils"
+	"io"
+)
+
+//LengthFieldConfig 长度字段的位置和含义，参考Netty的LengthFieldBasedFrameDecoder
+//帧长度 = 长度字段的值 + LengthAdjustment，LengthIncludesSelf为false时再加上长度字段结束处的偏移
+type LengthFieldConfig struct {
+	ByteOrder           binary.ByteOrder //长度字段的大小端，默认大端
+	MaxFrameLength      int              //帧的最大长度，包含被去掉的前缀
+	LengthFieldOffset   int              //长度字段在帧中的偏移
+	LengthFieldLength   int              //长度字段的字节数：1、2、3、4或8
+	LengthAdjustment    int              //长度字段的值与实际长度的差，如长度字段之后还有不计入长度的包头
+	LengthIncludesSelf  bool             //长度字段的值是否已包含长度字段及其之前的字节，即整个帧的长度
+	InitialBytesToStrip int              //解码后从帧开头去掉的字节数
+}
+
+//LengthFieldCodec 按可配置的长度字段分帧的编解码器，适配各种设备的私有协议，产生RawPacket
+//写出时InitialBytesToStrip为0则包中是完整的帧，只填入长度字段；
+//等于长度字段结束处的偏移且LengthFieldOffset为0时在包前加上长度字段；其他情况无法还原被去掉的字节，不支持写出
+type LengthFieldCodec struct {
+	name         string
+	cfg          LengthFieldConfig
+	fieldEnd     int //长度字段结束处的偏移
+	littleEndian bool
+}
+
+//NewLengthFieldCodec 新建长度字段编解码器，配置无效时返回LengthFieldConfigError
+func NewLengthFieldCodec(cfg LengthFieldConfig) (*LengthFieldCodec, error) {
+	switch cfg.LengthFieldLength {
+	case 1, 2, 3, 4, 8:
+	default:
+		return nil, LengthFieldConfigError
+	}
+	if cfg.ByteOrder == nil {
+		cfg.ByteOrder = binary.BigEndian
+	}
+	fieldEnd := cfg.LengthFieldOffset + cfg.LengthFieldLength
+	if cfg.MaxFrameLength <= 0 || cfg.LengthFieldOffset < 0 || cfg.InitialBytesToStrip < 0 ||
+		fieldEnd > cfg.MaxFrameLength || cfg.InitialBytesToStrip > cfg.MaxFrameLength {
+		return nil, LengthFieldConfigError
+	}
+	return &LengthFieldCodec{
+		name:         "length field codec",
+		cfg:          cfg,
+		fieldEnd:     fieldEnd,
+		littleEndian: cfg.ByteOrder == binary.LittleEndian,
+	}, nil
+}
+
+func (lfc *LengthFieldCodec) Name() string {
+	return lfc.name
+}
+
+//getLength 读取长度字段
+func (lfc *LengthFieldCodec) getLength(b []byte) uint64 {
+	bo := lfc.cfg.ByteOrder
+	switch lfc.cfg.LengthFieldLength {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(bo.Uint16(b))
+	case 3:
+		if lfc.littleEndian {
+			return uint64(utils.GetUint24LE(b))
+		}
+		return uint64(utils.GetUint24BE(b))
+	case 4:
+		return uint64(bo.Uint32(b))
+	default:
+		return bo.Uint64(b)
+	}
+}
+
+//putLength 写入长度字段，v超出字段能表示的范围时返回PacketTooLargeError
+func (lfc *LengthFieldCodec) putLength(b []byte, v uint64) error {
+	size := lfc.cfg.LengthFieldLength
+	if size < 8 && v >= 1<<(8*uint(size)) {
+		return PacketTooLargeError
+	}
+	bo := lfc.cfg.ByteOrder
+	switch size {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		bo.PutUint16(b, uint16(v))
+	case 3:
+		if lfc.littleEndian {
+			utils.PutUint24LE(b, uint32(v))
+		} else {
+			utils.PutUint24BE(b, uint32(v))
+		}
+	case 4:
+		bo.PutUint32(b, uint32(v))
+	default:
+		bo.PutUint64(b, v)
+	}
+	return nil
+}
+
+//frameLength 根据帧开头到长度字段结束的字节计算帧长度，在分配内存之前校验最大长度
+func (lfc *LengthFieldCodec) frameLength(head []byte) (int, error) {
+	v := lfc.getLength(head[lfc.cfg.LengthFieldOffset:])
+	adj := lfc.cfg.LengthAdjustment
+	if adj < 0 {
+		adj = -adj
+	}
+	//先排除过大的值，避免8字节长度在下面的计算中溢出
+	if v > uint64(lfc.cfg.MaxFrameLength+lfc.fieldEnd+adj) {
+		return 0, PacketTooLargeError
+	}
+	frameLen := int64(v) + int64(lfc.cfg.LengthAdjustment)
+	if !lfc.cfg.LengthIncludesSelf {
+		frameLen += int64(lfc.fieldEnd)
+	}
+	if frameLen > int64(lfc.cfg.MaxFrameLength) {
+		return 0, PacketTooLargeError
+	}
+	if frameLen < int64(lfc.fieldEnd) || frameLen < int64(lfc.cfg.InitialBytesToStrip) {
+		return 0, InvalidFrameLengthError
+	}
+	return int(frameLen), nil
+}
+
+//Read 从连接中读取一帧，去掉InitialBytesToStrip个字节后作为RawPacket返回
+func (lfc *LengthFieldCodec) Read(bReader *bufio.Reader) (Packet, error) {
+	head, err := bReader.Peek(lfc.fieldEnd)
+	if err != nil {
+		return nil, err
+	}
+	frameLen, err := lfc.frameLength(head)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := bReader.Discard(lfc.cfg.InitialBytesToStrip); err != nil {
+		return nil, err
+	}
+	data := make([]byte, frameLen-lfc.cfg.InitialBytesToStrip)
+	if _, err := io.ReadFull(bReader, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return RawPacket{Data: data}, nil
+}
+
+//DecodeFrame 实现FrameDecoder
+func (lfc *LengthFieldCodec) DecodeFrame(in *buffer.ByteBuf) (Packet, error) {
+	if !in.IsReadable(lfc.fieldEnd) {
+		return nil, nil
+	}
+	frameLen, err := lfc.frameLength(in.Peek(lfc.fieldEnd))
+	if err != nil {
+		return nil, err
+	}
+	if !in.IsReadable(frameLen) {
+		return nil, nil
+	}
+	in.Skip(lfc.cfg.InitialBytesToStrip)
+	//in的数据之后会被覆盖，需复制
+	return RawPacket{Data: append([]byte(nil), in.ReadBytes(frameLen-lfc.cfg.InitialBytesToStrip)...)}, nil
+}
+
+//Write 将包写出
+func (lfc *LengthFieldCodec) Write(bWriter *bufio.Writer, p Packet) error {
+	if err := lfc.WriteBuffered(bWriter, p); err != nil {
+		return err
+	}
+	return bWriter.Flush()
+}
+
+//WriteBuffered 填入或加上长度字段后写入bWriter，不flush
+func (lfc *LengthFieldCodec) WriteBuffered(bWriter *bufio.Writer, p Packet) error {
+	rp, ok := p.(RawPacket)
+	if !ok {
+		return PacketNotRawError
+	}
+	strip := lfc.cfg.InitialBytesToStrip
+	prepend := strip == lfc.fieldEnd && lfc.cfg.LengthFieldOffset == 0
+	if strip != 0 && !prepend {
+		return StrippedPrefixError
+	}
+
+	frameLen := len(rp.Data) + strip
+	if frameLen > lfc.cfg.MaxFrameLength {
+		return PacketTooLargeError
+	}
+	if frameLen < lfc.fieldEnd {
+		return InvalidFrameLengthError
+	}
+	v := frameLen - lfc.cfg.LengthAdjustment
+	if !lfc.cfg.LengthIncludesSelf {
+		v -= lfc.fieldEnd
+	}
+	if v < 0 {
+		return InvalidFrameLengthError
+	}
+	var field [8]byte
+	if err := lfc.putLength(field[:], uint64(v)); err != nil {
+		return err
+	}
+
+	body := rp.Data
+	if !prepend {
+		bWriter.Write(body[:lfc.cfg.LengthFieldOffset])
+		body = body[lfc.fieldEnd:]
+	}
+	bWriter.Write(field[:lfc.cfg.LengthFieldLength])
+	_, err := bWriter.Write(body)
+	return err
+}
+
+//Marshal 将消息的字节转为RawPacket
+func (lfc *LengthFieldCodec) Marshal(m Message) (Packet, error) {
+	return rawMarshal(m)
+}
+
+//Unmarshal 将包转为业务实体
+func (lfc *LengthFieldCodec) Unmarshal(p Packet, m Message) error {
+	return p.Transform(m)
+}
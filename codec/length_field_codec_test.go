@@ -0,0 +1,195 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/smartystreets/goconvey/convey"
+	"io"
+	"testing"
+)
+
+func readRaw(c Codec, data []byte) (string, error) {
+	p, err := c.Read(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return "", err
+	}
+	return string(p.(RawPacket).Data), nil
+}
+
+func writeRaw(c Codec, data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	err := c.Write(bufio.NewWriter(&out), NewRawPacket(data))
+	return out.Bytes(), err
+}
+
+//feedAndOverwrite 一次喂入完整的帧后覆盖喂入的数据，模拟事件循环复用读缓冲，解出的包不应受影响
+func feedAndOverwrite(d FrameDecoder, frame []byte) ([]Packet, error) {
+	data := append([]byte(nil), frame...)
+	packets, err := NewStreamDecoder(d).Feed(data)
+	for i := range data {
+		data[i] = 'X'
+	}
+	return packets, err
+}
+
+func Test_LengthFieldCodec(t *testing.T) {
+	const hello = "HELLO, WORLD"
+
+	//Netty LengthFieldBasedFrameDecoder文档中的例子
+	cases := []struct {
+		name  string
+		cfg   LengthFieldConfig
+		frame string
+		want  string
+	}{
+		{"2 bytes length, no strip",
+			LengthFieldConfig{LengthFieldLength: 2},
+			"\x00\x0C" + hello, "\x00\x0C" + hello},
+		{"2 bytes length, strip the length",
+			LengthFieldConfig{LengthFieldLength: 2, InitialBytesToStrip: 2},
+			"\x00\x0C" + hello, hello},
+		{"length includes itself",
+			LengthFieldConfig{LengthFieldLength: 2, LengthIncludesSelf: true},
+			"\x00\x0E" + hello, "\x00\x0E" + hello},
+		{"length includes itself by adjustment",
+			LengthFieldConfig{LengthFieldLength: 2, LengthAdjustment: -2},
+			"\x00\x0E" + hello, "\x00\x0E" + hello},
+		{"3 bytes length after a header",
+			LengthFieldConfig{LengthFieldOffset: 2, LengthFieldLength: 3},
+			"\xCA\xFE\x00\x00\x0C" + hello, "\xCA\xFE\x00\x00\x0C" + hello},
+		{"header after the length",
+			LengthFieldConfig{LengthFieldLength: 3, LengthAdjustment: 2},
+			"\x00\x00\x0C\xCA\xFE" + hello, "\x00\x00\x0C\xCA\xFE" + hello},
+		{"headers around the length, strip the first header and the length",
+			LengthFieldConfig{LengthFieldOffset: 1, LengthFieldLength: 2, LengthAdjustment: 1, InitialBytesToStrip: 3},
+			"\xCA\x00\x0C\xFE" + hello, "\xFE" + hello},
+		{"length of the whole frame after a header",
+			LengthFieldConfig{LengthFieldOffset: 1, LengthFieldLength: 2, LengthAdjustment: -3, InitialBytesToStrip: 3},
+			"\xCA\x00\x10\xFE" + hello, "\xFE" + hello},
+		{"1 byte length",
+			LengthFieldConfig{LengthFieldLength: 1, InitialBytesToStrip: 1},
+			"\x0C" + hello, hello},
+		{"4 bytes little endian length",
+			LengthFieldConfig{ByteOrder: binary.LittleEndian, LengthFieldLength: 4, InitialBytesToStrip: 4},
+			"\x0C\x00\x00\x00" + hello, hello},
+		{"8 bytes little endian length including itself",
+			LengthFieldConfig{ByteOrder: binary.LittleEndian, LengthFieldLength: 8, LengthIncludesSelf: true, InitialBytesToStrip: 8},
+			"\x14\x00\x00\x00\x00\x00\x00\x00" + hello, hello},
+		{"3 bytes little endian length",
+			LengthFieldConfig{ByteOrder: binary.LittleEndian, LengthFieldLength: 3, InitialBytesToStrip: 3},
+			"\x0C\x00\x00" + hello, hello},
+	}
+
+	convey.Convey("Frames should be decoded as in the Netty examples", t, func() {
+		for _, c := range cases {
+			c.cfg.MaxFrameLength = 1024
+			lfc, err := NewLengthFieldCodec(c.cfg)
+			convey.So(err, convey.ShouldBeNil)
+
+			//后面跟着下一帧的开头，只应读取一帧
+			got, err := readRaw(lfc, []byte(c.frame+c.frame[:3]))
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(got, convey.ShouldEqual, c.want)
+
+			stream := []byte(c.frame + c.frame)
+			for chunk := 1; chunk <= len(stream); chunk++ {
+				sd := NewStreamDecoder(lfc)
+				var frames []string
+				for i := 0; i < len(stream); i += chunk {
+					end := i + chunk
+					if end > len(stream) {
+						end = len(stream)
+					}
+					packets, err := sd.Feed(stream[i:end])
+					convey.So(err, convey.ShouldBeNil)
+					for _, p := range packets {
+						frames = append(frames, string(p.(RawPacket).Data))
+					}
+				}
+				convey.So(frames, convey.ShouldResemble, []string{c.want, c.want})
+				convey.So(sd.Buffered(), convey.ShouldEqual, 0)
+			}
+		}
+	})
+
+	convey.Convey("Decoded frames should not alias the fed data", t, func() {
+		for _, c := range cases {
+			c.cfg.MaxFrameLength = 1024
+			lfc, _ := NewLengthFieldCodec(c.cfg)
+			packets, err := feedAndOverwrite(lfc, []byte(c.frame))
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(packets), convey.ShouldEqual, 1)
+			convey.So(string(packets[0].(RawPacket).Data), convey.ShouldEqual, c.want)
+		}
+	})
+
+	convey.Convey("Writing should restore the frame when the prefix can be rebuilt", t, func() {
+		for _, c := range cases {
+			c.cfg.MaxFrameLength = 1024
+			lfc, _ := NewLengthFieldCodec(c.cfg)
+			out, err := writeRaw(lfc, []byte(c.want))
+			if c.cfg.InitialBytesToStrip != 0 && c.cfg.LengthFieldOffset != 0 {
+				convey.So(err, convey.ShouldEqual, StrippedPrefixError)
+				continue
+			}
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(string(out), convey.ShouldEqual, c.frame)
+		}
+	})
+
+	convey.Convey("Invalid configs should be rejected", t, func() {
+		for _, cfg := range []LengthFieldConfig{
+			{MaxFrameLength: 1024, LengthFieldLength: 5},
+			{MaxFrameLength: 1024, LengthFieldLength: 0},
+			{MaxFrameLength: 0, LengthFieldLength: 2},
+			{MaxFrameLength: 1024, LengthFieldLength: 2, LengthFieldOffset: -1},
+			{MaxFrameLength: 1024, LengthFieldLength: 2, InitialBytesToStrip: -1},
+			{MaxFrameLength: 4, LengthFieldLength: 4, LengthFieldOffset: 1},
+		} {
+			_, err := NewLengthFieldCodec(cfg)
+			convey.So(err, convey.ShouldEqual, LengthFieldConfigError)
+		}
+	})
+
+	convey.Convey("Oversized and undersized lengths should fail before reading the body", t, func() {
+		lfc, _ := NewLengthFieldCodec(LengthFieldConfig{MaxFrameLength: 64, LengthFieldLength: 8})
+		_, err := readRaw(lfc, []byte("\xFF\xFF\xFF\xFF\xFF\xFF\xFF\xFF"))
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+		_, err = readRaw(lfc, []byte("\x00\x00\x00\x00\x00\x00\x00\x39"))
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+		_, err = NewStreamDecoder(lfc).Feed([]byte("\x80\x00\x00\x00\x00\x00\x00\x00"))
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+
+		got, err := readRaw(lfc, append([]byte("\x00\x00\x00\x00\x00\x00\x00\x38"), make([]byte, 56)...))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(got), convey.ShouldEqual, 64)
+
+		lfc, _ = NewLengthFieldCodec(LengthFieldConfig{MaxFrameLength: 64, LengthFieldLength: 2, LengthAdjustment: -4})
+		_, err = readRaw(lfc, []byte("\x00\x01"))
+		convey.So(err, convey.ShouldEqual, InvalidFrameLengthError)
+
+		lfc, _ = NewLengthFieldCodec(LengthFieldConfig{MaxFrameLength: 64, LengthFieldLength: 1, LengthIncludesSelf: true, InitialBytesToStrip: 4})
+		_, err = readRaw(lfc, []byte("\x02\x00\x00\x00"))
+		convey.So(err, convey.ShouldEqual, InvalidFrameLengthError)
+	})
+
+	convey.Convey("Truncated frames and bad writes should return errors", t, func() {
+		lfc, _ := NewLengthFieldCodec(LengthFieldConfig{MaxFrameLength: 300, LengthFieldLength: 1, InitialBytesToStrip: 1})
+		_, err := readRaw(lfc, []byte("\x0Cabc"))
+		convey.So(err, convey.ShouldEqual, io.ErrUnexpectedEOF)
+		_, err = readRaw(lfc, nil)
+		convey.So(err, convey.ShouldEqual, io.EOF)
+
+		_, err = writeRaw(lfc, make([]byte, 256))
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+		_, err = writeRaw(lfc, make([]byte, 300))
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+		convey.So(lfc.Write(bufio.NewWriter(&bytes.Buffer{}), newFrameCheckPacket(1, "x")), convey.ShouldEqual, PacketNotRawError)
+
+		p, err := lfc.Marshal(StringMsg("hi"))
+		convey.So(err, convey.ShouldBeNil)
+		out, _ := writeRaw(lfc, p.(RawPacket).Data)
+		convey.So(string(out), convey.ShouldEqual, "\x02hi")
+	})
+}
@@ -60,6 +60,42 @@ func (sm *SimpleMessage) Bytes() ([]byte, error) {
 	return []byte(sm.msg), nil
 }
 
+// ~================= text message =======================
+
+//TextMessage 文本消息，可与RawPacket互相转换，用于按行或分隔符分帧的文本协议
+type TextMessage struct {
+	Text string
+}
+
+func NewTextMessage(text string) *TextMessage {
+	return &TextMessage{Text: text}
+}
+
+func (tm *TextMessage) Length() int {
+	return len(tm.Text)
+}
+
+//FromPacket 从RawPacket取出文本，其他包返回PacketNotRawError
+func (tm *TextMessage) FromPacket(p Packet) error {
+	switch rp := p.(type) {
+	case RawPacket:
+		tm.Text = string(rp.Data)
+	case *RawPacket:
+		tm.Text = string(rp.Data)
+	default:
+		return PacketNotRawError
+	}
+	return nil
+}
+
+func (tm *TextMessage) ToPacket() (Packet, error) {
+	return RawPacket{Data: []byte(tm.Text)}, nil
+}
+
+func (tm *TextMessage) Bytes() ([]byte, error) {
+	return []byte(tm.Text), nil
+}
+
 // ~================= func message =======================
 type MessageFunc func() ([]byte, error)
 
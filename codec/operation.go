@@ -0,0 +1,28 @@
+package codec
+
+//保留的控制操作码，0xFF00 ~ 0xFFFF 由框架内部使用，业务包不应占用
+const (
+	OperationReservedMin uint16 = 0xFF00
+	OperationFragment    uint16 = 0xFF01 //分片
+	OperationBusy        uint16 = 0xFF02 //服务繁忙或过载，请求未被处理，Sequence与请求相同
+
+	//文件传输
+	OperationFileOffer  uint16 = 0xFF10 //发送方请求传输
+	OperationFileAccept uint16 = 0xFF11 //接收方同意，携带续传偏移
+	OperationFileReject uint16 = 0xFF12 //接收方拒绝
+	OperationFileChunk  uint16 = 0xFF13 //数据块
+	OperationFileAck    uint16 = 0xFF14 //累计确认
+	OperationFileResult uint16 = 0xFF15 //接收方校验结果
+
+	//多路复用流
+	OperationStreamOpen   uint16 = 0xFF20 //打开流
+	OperationStreamData   uint16 = 0xFF21 //流数据
+	OperationStreamWindow uint16 = 0xFF22 //增加对方的发送窗口
+	OperationStreamClose  uint16 = 0xFF23 //半关闭，不再发送数据
+	OperationStreamReset  uint16 = 0xFF24 //异常终止
+)
+
+//IsReservedOperation 是否为框架保留的操作码
+func IsReservedOperation(op uint16) bool {
+	return op >= OperationReservedMin
+}
@@ -9,3 +9,13 @@ type Packet interface {
 	Encode(bo binary.ByteOrder) ([]byte, error) // packet --> bytes
 	Transform(m Message) error                  // packet --> message
 }
+
+//Releaser 引用池化内存的包，处理完后调用Release归还
+type Releaser interface {
+	Release()
+}
+
+//Sized 可获知编码后长度的包，session据此按字节统计读写水位
+type Sized interface {
+	Len() int
+}
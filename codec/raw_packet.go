@@ -0,0 +1,44 @@
+package codec
+
+import (
+	"encoding/binary"
+)
+
+//RawPacket 原始帧数据包，不解析内部结构，由LengthFieldCodec等通用帧编解码器产生
+type RawPacket struct {
+	Data []byte
+}
+
+//NewRawPacket 新建原始帧数据包
+func NewRawPacket(data []byte) RawPacket {
+	return RawPacket{Data: data}
+}
+
+//Encode 返回原始数据
+func (p RawPacket) Encode(bo binary.ByteOrder) ([]byte, error) {
+	return p.Data, nil
+}
+
+//Transform 转为业务实体
+func (p RawPacket) Transform(m Message) error {
+	return m.FromPacket(p)
+}
+
+//Len 数据长度
+func (p RawPacket) Len() int {
+	return len(p.Data)
+}
+
+//String 按文本返回数据
+func (p RawPacket) String() string {
+	return string(p.Data)
+}
+
+//rawMarshal 将消息的字节转为RawPacket
+func rawMarshal(m Message) (Packet, error) {
+	data, err := m.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return RawPacket{Data: data}, nil
+}
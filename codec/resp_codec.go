@@ -0,0 +1,454 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/sumory/gotty/buffer"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	respMaxDepth       = 64 //聚合类型的最大嵌套层数
+	respMaxFrameFactor = 4  //默认的整个值的最大长度为maxSize的倍数
+	respMaxPrealloc    = 64 //聚合类型预先分配的最大元素个数，其余随数据到达再扩容
+)
+
+//respIncomplete 数据不足一个完整的值
+var respIncomplete = errors.New("incomplete")
+
+//RespCodec Redis协议编解码器，解析RESP2和RESP3的所有类型，产生RespValue
+//不以类型字节开头的行按内联命令解析为批量字符串数组，如telnet发送的PING；空行被忽略
+//maxSize限制单个批量字符串、单行及聚合类型的元素数，在分配内存之前校验；整个值的长度另由maxFrame限制
+//默认按RESP2编码，RESP3的类型转为RESP2中相近的类型，EnableResp3之后按RESP3编码
+//不支持RESP3的流式字符串和流式聚合类型
+type RespCodec struct {
+	name     string
+	maxSize  int
+	maxFrame int //整个值的最大长度，0表示不限制
+	resp3    bool
+}
+
+//NewRespCodec 新建RESP编解码器，整个值的最大长度默认为maxSize的4倍，乘积超出int32时不限制
+func NewRespCodec(maxSize int) *RespCodec {
+	rc := &RespCodec{
+		name:    "resp codec",
+		maxSize: maxSize,
+	}
+	if maxSize < math.MaxInt32/respMaxFrameFactor {
+		rc.maxFrame = maxSize * respMaxFrameFactor
+	}
+	return rc
+}
+
+//SetMaxFrameLength 设置整个值的最大长度，0表示不限制
+func (rc *RespCodec) SetMaxFrameLength(maxFrame int) *RespCodec {
+	rc.maxFrame = maxFrame
+	return rc
+}
+
+//MaxFrameLength 实现FrameLimiter，未读完的值超过该长度时读取失败
+func (rc *RespCodec) MaxFrameLength() int {
+	return rc.maxFrame
+}
+
+//EnableResp3 写出时使用RESP3编码
+func (rc *RespCodec) EnableResp3() *RespCodec {
+	rc.resp3 = true
+	return rc
+}
+
+func (rc *RespCodec) Name() string {
+	return rc.name
+}
+
+//Read 从连接中读取一个值，流水线发来的多个命令逐个读出，多读出的数据会被丢弃，session使用ReadWithState
+func (rc *RespCodec) Read(bReader *bufio.Reader) (Packet, error) {
+	return ReadFrame(bReader, rc)
+}
+
+//ReadWithState 实现StatefulReader，值大于bufio缓冲区时多读出的后续命令保存在state中
+func (rc *RespCodec) ReadWithState(bReader *bufio.Reader, state *ReadState) (Packet, error) {
+	return ReadFrameWithState(bReader, rc, state)
+}
+
+//DecodeFrame 实现FrameDecoder，数据不足一个完整的值时不消费任何字节
+//不保存进度，每次从头确认值是否完整，连接上的解码使用NewFrameDecoder
+func (rc *RespCodec) DecodeFrame(in *buffer.ByteBuf) (Packet, error) {
+	return rc.NewFrameDecoder().DecodeFrame(in)
+}
+
+//NewFrameDecoder 实现DecoderFactory，新建的解码器记住已确认完整的部分，值分多次到达时总的解析量与其长度成正比
+func (rc *RespCodec) NewFrameDecoder() FrameDecoder {
+	return &respDecoder{rc: rc}
+}
+
+//respDecoder 一个连接的RESP解码器，先只按长度跳过已到达的元素确认值已完整，再一次构建整个值
+type respDecoder struct {
+	rc    *RespCodec
+	pos   int   //未消费的数据中已跳过的完整元素的长度
+	stack []int //pos处各层聚合类型还差的元素个数，为空表示还没开始跳过
+}
+
+//DecodeFrame 实现FrameDecoder
+func (d *respDecoder) DecodeFrame(in *buffer.ByteBuf) (Packet, error) {
+	for {
+		data := in.Bytes()
+		if len(data) == 0 {
+			return nil, nil
+		}
+		p := respParser{data: data, maxSize: d.rc.maxSize}
+		if !isRespType(data[0]) {
+			//内联命令或空行
+			v, err := p.parseTop()
+			if err == respIncomplete {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			in.Skip(p.pos)
+			if v == nil {
+				continue
+			}
+			return *v, nil
+		}
+
+		if complete, err := d.skip(data); err != nil || !complete {
+			return nil, err
+		}
+		d.pos, d.stack = 0, d.stack[:0]
+		v, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		in.Skip(p.pos)
+		return v, nil
+	}
+}
+
+//skip 从上次的进度继续跳过data中完整的元素，不构建值，整个值都已到达时返回true
+//只校验长度，其余错误在构建值时返回
+func (d *respDecoder) skip(data []byte) (bool, error) {
+	if len(d.stack) == 0 {
+		d.pos, d.stack = 0, append(d.stack, 1)
+	}
+	p := respParser{data: data, pos: d.pos, maxSize: d.rc.maxSize}
+	for len(d.stack) > 0 {
+		top := len(d.stack) - 1
+		if d.stack[top] == 0 {
+			d.stack = d.stack[:top]
+			continue
+		}
+		n, attr, err := p.skipOne()
+		if err == respIncomplete {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		//属性之后还有它所附加的值
+		if !attr {
+			d.stack[top]--
+		}
+		if n > 0 {
+			if len(d.stack) > respMaxDepth {
+				return false, RespProtocolError
+			}
+			d.stack = append(d.stack, n)
+		}
+		d.pos = p.pos
+	}
+	return true, nil
+}
+
+//Write 将值写出
+func (rc *RespCodec) Write(bWriter *bufio.Writer, p Packet) error {
+	if err := rc.WriteBuffered(bWriter, p); err != nil {
+		return err
+	}
+	return bWriter.Flush()
+}
+
+//WriteBuffered 编码后写入bWriter，不flush
+func (rc *RespCodec) WriteBuffered(bWriter *bufio.Writer, p Packet) error {
+	v, ok := p.(RespValue)
+	if !ok {
+		return PacketNotRespError
+	}
+	b, err := v.AppendResp(nil, rc.resp3)
+	if err != nil {
+		return err
+	}
+	_, err = bWriter.Write(b)
+	return err
+}
+
+//Marshal 将消息的字节转为批量字符串
+func (rc *RespCodec) Marshal(m Message) (Packet, error) {
+	data, err := m.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return NewRespBulk(string(data)), nil
+}
+
+//Unmarshal 将值转为业务实体
+func (rc *RespCodec) Unmarshal(p Packet, m Message) error {
+	return p.Transform(m)
+}
+
+//respParser 在一段完整或不完整的数据上解析RESP值
+type respParser struct {
+	data    []byte
+	pos     int
+	maxSize int
+}
+
+//parseTop 解析顶层的值或内联命令，空行返回nil
+func (p *respParser) parseTop() (*RespValue, error) {
+	if p.pos >= len(p.data) {
+		return nil, respIncomplete
+	}
+	if isRespType(p.data[p.pos]) {
+		v, err := p.parse(0)
+		return &v, err
+	}
+
+	i := bytes.IndexByte(p.data[p.pos:], '\n')
+	if i < 0 {
+		if len(p.data)-p.pos > p.maxSize {
+			return nil, PacketTooLargeError
+		}
+		return nil, respIncomplete
+	}
+	line := strings.TrimSuffix(string(p.data[p.pos:p.pos+i]), "\r")
+	p.pos += i + 1
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return nil, nil
+	}
+	v := NewRespCommand(args...)
+	return &v, nil
+}
+
+//line 读取到\r\n为止的一行
+func (p *respParser) line() (string, error) {
+	i := bytes.Index(p.data[p.pos:], []byte("\r\n"))
+	if i < 0 {
+		if len(p.data)-p.pos > p.maxSize+1 {
+			return "", PacketTooLargeError
+		}
+		return "", respIncomplete
+	}
+	if i > p.maxSize {
+		return "", PacketTooLargeError
+	}
+	s := string(p.data[p.pos : p.pos+i])
+	p.pos += i + 2
+	return s, nil
+}
+
+//length 读取批量类型或聚合类型的长度，允许-1表示null
+func (p *respParser) length() (int, error) {
+	s, err := p.line()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < -1 {
+		return 0, RespProtocolError
+	}
+	if n > p.maxSize {
+		return 0, PacketTooLargeError
+	}
+	return n, nil
+}
+
+//bulk 读取n个字节及之后的\r\n
+func (p *respParser) bulk(n int) (string, error) {
+	if len(p.data)-p.pos < n+2 {
+		return "", respIncomplete
+	}
+	if p.data[p.pos+n] != '\r' || p.data[p.pos+n+1] != '\n' {
+		return "", RespProtocolError
+	}
+	s := string(p.data[p.pos : p.pos+n])
+	p.pos += n + 2
+	return s, nil
+}
+
+//skipOne 跳过一个元素，是聚合类型时只跳过其长度行，返回其后的元素个数，attr表示是属性
+func (p *respParser) skipOne() (int, bool, error) {
+	if p.pos >= len(p.data) {
+		return 0, false, respIncomplete
+	}
+	t := RespType(p.data[p.pos])
+	p.pos++
+	switch t {
+	case RespSimpleString, RespError, RespInteger, RespNull, RespBoolean, RespDouble, RespBigNumber:
+		_, err := p.line()
+		return 0, false, err
+	case RespBulkString, RespBulkError, RespVerbatimString:
+		n, err := p.length()
+		if err != nil || n < 0 {
+			return 0, false, err
+		}
+		if len(p.data)-p.pos < n+2 {
+			return 0, false, respIncomplete
+		}
+		p.pos += n + 2
+		return 0, false, nil
+	case RespArray, RespSet, RespPush, RespMap, RespAttribute:
+		n, err := p.length()
+		if err != nil || n < 0 {
+			return 0, false, err
+		}
+		if t == RespMap || t == RespAttribute {
+			if n > p.maxSize/2 {
+				return 0, false, PacketTooLargeError
+			}
+			n *= 2
+		}
+		return n, t == RespAttribute, nil
+	}
+	return 0, false, RespProtocolError
+}
+
+//elems 读取n个值
+func (p *respParser) elems(n, depth int) ([]RespValue, error) {
+	size := n
+	if size > respMaxPrealloc {
+		size = respMaxPrealloc
+	}
+	elems := make([]RespValue, 0, size)
+	for i := 0; i < n; i++ {
+		e, err := p.parse(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, e)
+	}
+	return elems, nil
+}
+
+func (p *respParser) parse(depth int) (RespValue, error) {
+	if depth > respMaxDepth {
+		return RespValue{}, RespProtocolError
+	}
+	if p.pos >= len(p.data) {
+		return RespValue{}, respIncomplete
+	}
+	t := RespType(p.data[p.pos])
+	p.pos++
+	v := RespValue{Type: t}
+
+	var err error
+	switch t {
+	case RespSimpleString, RespError:
+		v.Str, err = p.line()
+	case RespInteger:
+		var s string
+		if s, err = p.line(); err == nil {
+			if v.Int, err = strconv.ParseInt(s, 10, 64); err != nil {
+				err = RespProtocolError
+			}
+		}
+	case RespNull:
+		var s string
+		if s, err = p.line(); err == nil && s != "" {
+			err = RespProtocolError
+		}
+		v.Null = true
+	case RespBoolean:
+		var s string
+		if s, err = p.line(); err == nil {
+			switch s {
+			case "t":
+				v.Bool = true
+			case "f":
+			default:
+				err = RespProtocolError
+			}
+		}
+	case RespDouble:
+		var s string
+		if s, err = p.line(); err == nil {
+			if v.Double, err = strconv.ParseFloat(s, 64); err != nil {
+				err = RespProtocolError
+			}
+		}
+	case RespBigNumber:
+		if v.Str, err = p.line(); err == nil && !isRespBigNumber(v.Str) {
+			err = RespProtocolError
+		}
+	case RespBulkString, RespBulkError, RespVerbatimString:
+		var n int
+		if n, err = p.length(); err != nil {
+			break
+		}
+		if n < 0 {
+			if t != RespBulkString {
+				err = RespProtocolError
+			}
+			v.Null = true
+			break
+		}
+		if v.Str, err = p.bulk(n); err == nil && t == RespVerbatimString {
+			if len(v.Str) < 4 || v.Str[3] != ':' {
+				err = RespProtocolError
+				break
+			}
+			v.Format, v.Str = v.Str[:3], v.Str[4:]
+		}
+	case RespArray, RespSet, RespPush, RespMap, RespAttribute:
+		var n int
+		if n, err = p.length(); err != nil {
+			break
+		}
+		if n < 0 {
+			if t != RespArray {
+				err = RespProtocolError
+			}
+			v.Null = true
+			break
+		}
+		if t == RespMap || t == RespAttribute {
+			if n > p.maxSize/2 {
+				err = PacketTooLargeError
+				break
+			}
+			n *= 2
+		}
+		if v.Elems, err = p.elems(n, depth); err != nil || t != RespAttribute {
+			break
+		}
+		//属性附加在其后的值上，连续的属性也计入深度
+		var next RespValue
+		if next, err = p.parse(depth + 1); err == nil {
+			next.Attrs = v.Elems
+			v = next
+		}
+	default:
+		err = RespProtocolError
+	}
+	return v, err
+}
+
+//isRespBigNumber 可带符号的十进制整数
+func isRespBigNumber(s string) bool {
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
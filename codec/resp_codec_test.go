@@ -0,0 +1,333 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/smartystreets/goconvey/convey"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+//respTranscripts 原始协议数据及解析结果，resp3表示raw是按RESP3编码的
+var respTranscripts = []struct {
+	name  string
+	raw   string
+	want  RespValue
+	resp3 bool
+}{
+	{"simple string", "+OK\r\n", NewRespSimpleString("OK"), false},
+	{"error", "-ERR unknown command 'foo'\r\n", NewRespError("ERR unknown command 'foo'"), false},
+	{"integer", ":1000\r\n", NewRespInteger(1000), false},
+	{"negative integer", ":-42\r\n", NewRespInteger(-42), false},
+	{"bulk string", "$5\r\nhello\r\n", NewRespBulk("hello"), false},
+	{"empty bulk string", "$0\r\n\r\n", NewRespBulk(""), false},
+	{"binary bulk string", "$4\r\na\r\nb\r\n", NewRespBulk("a\r\nb"), false},
+	{"null bulk string", "$-1\r\n", RespValue{Type: RespBulkString, Null: true}, false},
+	{"null array", "*-1\r\n", RespValue{Type: RespArray, Null: true}, false},
+	{"empty array", "*0\r\n", NewRespArray([]RespValue{}...), false},
+	{"command", "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n", NewRespCommand("SET", "key", "value"), false},
+	{"nested array", "*2\r\n*2\r\n:1\r\n:2\r\n+three\r\n",
+		NewRespArray(NewRespArray(NewRespInteger(1), NewRespInteger(2)), NewRespSimpleString("three")), false},
+	{"null", "_\r\n", NewRespNull(), true},
+	{"true", "#t\r\n", RespValue{Type: RespBoolean, Bool: true}, true},
+	{"false", "#f\r\n", RespValue{Type: RespBoolean}, true},
+	{"double", ",3.14\r\n", RespValue{Type: RespDouble, Double: 3.14}, true},
+	{"double exponent", ",1e+300\r\n", RespValue{Type: RespDouble, Double: 1e300}, true},
+	{"negative infinity", ",-inf\r\n", RespValue{Type: RespDouble, Double: math.Inf(-1)}, true},
+	{"big number", "(3492890328409238509324850943850943825024385\r\n",
+		RespValue{Type: RespBigNumber, Str: "3492890328409238509324850943850943825024385"}, true},
+	{"bulk error", "!21\r\nSYNTAX invalid syntax\r\n", RespValue{Type: RespBulkError, Str: "SYNTAX invalid syntax"}, true},
+	{"verbatim string", "=15\r\ntxt:Some string\r\n", RespValue{Type: RespVerbatimString, Format: "txt", Str: "Some string"}, true},
+	{"map", "%2\r\n+first\r\n:1\r\n+second\r\n:2\r\n",
+		NewRespMap(NewRespSimpleString("first"), NewRespInteger(1), NewRespSimpleString("second"), NewRespInteger(2)), true},
+	{"set", "~2\r\n+a\r\n+b\r\n", RespValue{Type: RespSet, Elems: []RespValue{NewRespSimpleString("a"), NewRespSimpleString("b")}}, true},
+	{"push", ">3\r\n$7\r\nmessage\r\n$4\r\nchan\r\n$2\r\nhi\r\n",
+		RespValue{Type: RespPush, Elems: []RespValue{NewRespBulk("message"), NewRespBulk("chan"), NewRespBulk("hi")}}, true},
+	{"attribute", "|1\r\n+key-popularity\r\n%2\r\n$1\r\na\r\n,0.1923\r\n$1\r\nb\r\n,0.0012\r\n*2\r\n:2039123\r\n:9543892\r\n",
+		RespValue{
+			Type:  RespArray,
+			Elems: []RespValue{NewRespInteger(2039123), NewRespInteger(9543892)},
+			Attrs: []RespValue{
+				NewRespSimpleString("key-popularity"),
+				NewRespMap(NewRespBulk("a"), RespValue{Type: RespDouble, Double: 0.1923}, NewRespBulk("b"), RespValue{Type: RespDouble, Double: 0.0012}),
+			},
+		}, true},
+}
+
+//readResp 用bufio大小为16的Reader逐个读取，直到出错
+func readResp(rc *RespCodec, r io.Reader) ([]RespValue, error) {
+	bReader := bufio.NewReaderSize(r, 16)
+	var values []RespValue
+	for {
+		p, err := rc.Read(bReader)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, p.(RespValue))
+	}
+}
+
+func Test_RespTranscripts(t *testing.T) {
+	convey.Convey("Each transcript should decode to its value and encode back", t, func() {
+		rc := NewRespCodec(1024)
+		for _, tc := range respTranscripts {
+			values, err := readResp(rc, strings.NewReader(tc.raw))
+			convey.So(err, convey.ShouldEqual, io.EOF)
+			convey.So(values, convey.ShouldResemble, []RespValue{tc.want})
+
+			sd := NewStreamDecoder(rc)
+			var decoded []RespValue
+			for i := 0; i < len(tc.raw); i++ {
+				packets, err := sd.Feed([]byte{tc.raw[i]})
+				convey.So(err, convey.ShouldBeNil)
+				for _, p := range packets {
+					decoded = append(decoded, p.(RespValue))
+				}
+			}
+			convey.So(decoded, convey.ShouldResemble, []RespValue{tc.want})
+
+			raw, err := tc.want.AppendResp(nil, tc.resp3)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(string(raw), convey.ShouldEqual, tc.raw)
+		}
+	})
+
+	convey.Convey("Pipelined values should be read one by one", t, func() {
+		var raw strings.Builder
+		var want []RespValue
+		for _, tc := range respTranscripts {
+			raw.WriteString(tc.raw)
+			want = append(want, tc.want)
+		}
+		rc := NewRespCodec(1024)
+		values, err := readResp(rc, iotest.OneByteReader(strings.NewReader(raw.String())))
+		convey.So(err, convey.ShouldEqual, io.EOF)
+		convey.So(values, convey.ShouldResemble, want)
+
+		packets, err := NewStreamDecoder(rc).Feed([]byte(raw.String()))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(packets), convey.ShouldEqual, len(want))
+	})
+
+	convey.Convey("Elements already received should not be parsed again", t, func() {
+		rc := NewRespCodec(1024)
+		sd := NewStreamDecoder(rc)
+		d := sd.decoder.(*respDecoder)
+		fed := len("*500\r\n")
+		sd.Feed([]byte("*500\r\n"))
+		for i := 0; i < 500; i++ {
+			packets, err := sd.Feed([]byte("$1\r\na\r\n"))
+			convey.So(err, convey.ShouldBeNil)
+			fed += len("$1\r\na\r\n")
+			if i < 499 && (len(packets) != 0 || d.pos != fed) {
+				convey.So(len(packets), convey.ShouldEqual, 0)
+				//下次从已确认的位置继续
+				convey.So(d.pos, convey.ShouldEqual, fed)
+			}
+			if i == 499 {
+				convey.So(len(packets), convey.ShouldEqual, 1)
+				convey.So(len(packets[0].(RespValue).Elems), convey.ShouldEqual, 500)
+			}
+		}
+		convey.So(d.pos, convey.ShouldEqual, 0)
+		convey.So(sd.Buffered(), convey.ShouldEqual, 0)
+	})
+
+	convey.Convey("NaN doubles should decode", t, func() {
+		values, _ := readResp(NewRespCodec(1024), strings.NewReader(",nan\r\n"))
+		convey.So(math.IsNaN(values[0].Double), convey.ShouldBeTrue)
+		raw, _ := values[0].AppendResp(nil, true)
+		convey.So(string(raw), convey.ShouldEqual, ",nan\r\n")
+	})
+}
+
+func Test_RespInline(t *testing.T) {
+	convey.Convey("Inline commands should become bulk string arrays", t, func() {
+		raw := "PING\r\n\r\nSET  k   v\nGET k\r\n*1\r\n$4\r\nQUIT\r\n"
+		want := []RespValue{
+			NewRespCommand("PING"),
+			NewRespCommand("SET", "k", "v"),
+			NewRespCommand("GET", "k"),
+			NewRespCommand("QUIT"),
+		}
+		rc := NewRespCodec(1024)
+		values, err := readResp(rc, strings.NewReader(raw))
+		convey.So(err, convey.ShouldEqual, io.EOF)
+		convey.So(values, convey.ShouldResemble, want)
+		convey.So(values[1].Args(), convey.ShouldResemble, []string{"SET", "k", "v"})
+
+		packets, err := NewStreamDecoder(rc).Feed([]byte(raw))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(packets), convey.ShouldEqual, len(want))
+
+		//只有空行时不产生值
+		values, err = readResp(rc, strings.NewReader("\r\n\n"))
+		convey.So(values, convey.ShouldBeEmpty)
+		convey.So(err, convey.ShouldEqual, io.EOF)
+	})
+}
+
+func Test_RespEncoding(t *testing.T) {
+	convey.Convey("RESP3 values should be downgraded for RESP2 clients", t, func() {
+		cases := []struct {
+			value      RespValue
+			resp2, res string
+		}{
+			{NewRespNull(), "$-1\r\n", "_\r\n"},
+			{RespValue{Type: RespArray, Null: true}, "*-1\r\n", "_\r\n"},
+			{RespValue{Type: RespBoolean, Bool: true}, ":1\r\n", "#t\r\n"},
+			{RespValue{Type: RespDouble, Double: 3.14}, "$4\r\n3.14\r\n", ",3.14\r\n"},
+			{RespValue{Type: RespBigNumber, Str: "-12"}, "$3\r\n-12\r\n", "(-12\r\n"},
+			{RespValue{Type: RespBulkError, Str: "ERR x"}, "-ERR x\r\n", "!5\r\nERR x\r\n"},
+			{RespValue{Type: RespVerbatimString, Format: "mkd", Str: "# hi"}, "$4\r\n# hi\r\n", "=8\r\nmkd:# hi\r\n"},
+			{NewRespMap(NewRespBulk("k"), NewRespInteger(1)), "*2\r\n$1\r\nk\r\n:1\r\n", "%1\r\n$1\r\nk\r\n:1\r\n"},
+			{RespValue{Type: RespSet, Elems: []RespValue{NewRespInteger(1)}}, "*1\r\n:1\r\n", "~1\r\n:1\r\n"},
+			{RespValue{Type: RespInteger, Int: 7, Attrs: []RespValue{NewRespBulk("a"), NewRespBulk("b")}},
+				":7\r\n", "|1\r\n$1\r\na\r\n$1\r\nb\r\n:7\r\n"},
+		}
+		resp2 := NewRespCodec(1024)
+		resp3 := NewRespCodec(1024).EnableResp3()
+		for _, c := range cases {
+			var out2, out3 bytes.Buffer
+			convey.So(resp2.Write(bufio.NewWriter(&out2), c.value), convey.ShouldBeNil)
+			convey.So(resp3.Write(bufio.NewWriter(&out3), c.value), convey.ShouldBeNil)
+			convey.So(out2.String(), convey.ShouldEqual, c.resp2)
+			convey.So(out3.String(), convey.ShouldEqual, c.res)
+		}
+	})
+
+	convey.Convey("Values that cannot be encoded should be rejected", t, func() {
+		rc := NewRespCodec(1024)
+		w := bufio.NewWriter(io.Discard)
+		convey.So(rc.Write(w, NewRespSimpleString("a\r\nb")), convey.ShouldEqual, RespProtocolError)
+		convey.So(rc.Write(w, NewRespArray(NewRespError("x\n"))), convey.ShouldEqual, RespProtocolError)
+		convey.So(rc.Write(w, NewRespMap(NewRespBulk("k"))), convey.ShouldEqual, RespProtocolError)
+		convey.So(rc.Write(w, RespValue{Type: 'x'}), convey.ShouldEqual, RespProtocolError)
+		convey.So(rc.Write(w, newFrameCheckPacket(1, "x")), convey.ShouldEqual, PacketNotRespError)
+
+		p, err := rc.Marshal(StringMsg("hi"))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p, convey.ShouldResemble, NewRespBulk("hi"))
+	})
+}
+
+func Test_RespErrors(t *testing.T) {
+	convey.Convey("Malformed data should be rejected", t, func() {
+		rc := NewRespCodec(1024)
+		for _, raw := range []string{
+			":abc\r\n",
+			"$3\r\nabcd\r\n",
+			"$-2\r\n",
+			"$x\r\n",
+			"#x\r\n",
+			"_x\r\n",
+			",pi\r\n",
+			"(12a\r\n",
+			"=3\r\nabc\r\n",
+			"!-1\r\n",
+			"%-1\r\n",
+			strings.Repeat("*1\r\n", respMaxDepth+2) + ":1\r\n",
+			strings.Repeat("|0\r\n", respMaxDepth+2) + "+OK\r\n",
+		} {
+			_, err := readResp(rc, strings.NewReader(raw))
+			convey.So(err, convey.ShouldEqual, RespProtocolError)
+			_, err = NewStreamDecoder(rc).Feed([]byte(raw))
+			convey.So(err, convey.ShouldEqual, RespProtocolError)
+		}
+	})
+
+	convey.Convey("Oversized values should fail before their data arrives", t, func() {
+		rc := NewRespCodec(1024)
+		for _, raw := range []string{
+			"$1025\r\n",
+			"*1025\r\n",
+			"%513\r\n",
+			"+" + strings.Repeat("a", 1030),
+			strings.Repeat("a", 1030),
+		} {
+			r := bufio.NewReaderSize(io.MultiReader(strings.NewReader(raw), blockingReader{}), 16)
+			_, err := rc.Read(r)
+			convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+		}
+
+		_, err := readResp(rc, strings.NewReader("$5\r\nab"))
+		convey.So(err, convey.ShouldEqual, io.ErrUnexpectedEOF)
+	})
+
+	convey.Convey("An unfinished value should not grow past the frame limit", t, func() {
+		rc := NewRespCodec(16)
+		convey.So(rc.MaxFrameLength(), convey.ShouldEqual, 64)
+		//每个元素都不超过maxSize，整个值超过限制
+		raw := "*16\r\n" + strings.Repeat("$8\r\naaaaaaaa\r\n", 15)
+		r := bufio.NewReaderSize(io.MultiReader(strings.NewReader(raw), blockingReader{}), 16)
+		_, err := rc.Read(r)
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+
+		sd := NewStreamDecoder(rc)
+		_, err = sd.Feed([]byte(raw))
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+
+		values, err := readResp(rc.SetMaxFrameLength(0), strings.NewReader(raw+"$8\r\naaaaaaaa\r\n"))
+		convey.So(err, convey.ShouldEqual, io.EOF)
+		convey.So(len(values[0].Elems), convey.ShouldEqual, 16)
+	})
+}
+
+//respServe 极简的Redis服务，处理PING、SET、GET和ECHO
+func respServe(store map[string]string, cmd RespValue) RespValue {
+	args := cmd.Args()
+	if len(args) == 0 {
+		return NewRespError("ERR protocol error")
+	}
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return NewRespSimpleString("PONG")
+	case "SET":
+		store[args[1]] = args[2]
+		return NewRespSimpleString("OK")
+	case "GET":
+		if v, ok := store[args[1]]; ok {
+			return NewRespBulk(v)
+		}
+		return NewRespNull()
+	case "ECHO":
+		return NewRespBulk(args[1])
+	}
+	return NewRespError("ERR unknown command '" + args[0] + "'")
+}
+
+func Test_RespSession(t *testing.T) {
+	convey.Convey("A pipelined client session should get replies in order", t, func() {
+		request := "*1\r\n$4\r\nPING\r\n" +
+			"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nhello\r\n" +
+			"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n" +
+			"GET missing\r\n" +
+			"*2\r\n$4\r\nECHO\r\n$4\r\na\r\nb\r\n" +
+			"FLUSHALL\r\n"
+		want := "+PONG\r\n" +
+			"+OK\r\n" +
+			"$5\r\nhello\r\n" +
+			"$-1\r\n" +
+			"$4\r\na\r\nb\r\n" +
+			"-ERR unknown command 'FLUSHALL'\r\n"
+
+		rc := NewRespCodec(1024)
+		store := make(map[string]string)
+		r := bufio.NewReaderSize(iotest.HalfReader(strings.NewReader(request)), 16)
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		for {
+			p, err := rc.Read(r)
+			if err == io.EOF {
+				break
+			}
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(rc.WriteBuffered(w, respServe(store, p.(RespValue))), convey.ShouldBeNil)
+		}
+		w.Flush()
+		convey.So(out.String(), convey.ShouldEqual, want)
+	})
+}
@@ -0,0 +1,257 @@
+package codec
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//RespType RESP值的类型，即协议中的首字节
+type RespType byte
+
+//RESP2的类型
+const (
+	RespSimpleString RespType = '+'
+	RespError        RespType = '-'
+	RespInteger      RespType = ':'
+	RespBulkString   RespType = '$'
+	RespArray        RespType = '*'
+)
+
+//RESP3新增的类型
+const (
+	RespNull           RespType = '_'
+	RespBoolean        RespType = '#'
+	RespDouble         RespType = ','
+	RespBigNumber      RespType = '('
+	RespBulkError      RespType = '!'
+	RespVerbatimString RespType = '='
+	RespMap            RespType = '%'
+	RespSet            RespType = '~'
+	RespAttribute      RespType = '|'
+	RespPush           RespType = '>'
+)
+
+//isRespType 是否为RESP的类型字节，不是时按内联命令解析
+func isRespType(c byte) bool {
+	switch RespType(c) {
+	case RespSimpleString, RespError, RespInteger, RespBulkString, RespArray,
+		RespNull, RespBoolean, RespDouble, RespBigNumber, RespBulkError,
+		RespVerbatimString, RespMap, RespSet, RespAttribute, RespPush:
+		return true
+	}
+	return false
+}
+
+//RespValue RESP协议的值，同时是RespCodec的Packet
+type RespValue struct {
+	Type   RespType
+	Str    string      //简单字符串、错误、批量字符串、批量错误、大数及verbatim字符串的内容
+	Format string      //verbatim字符串的格式，如txt、mkd
+	Int    int64       //整数
+	Double float64     //浮点数
+	Bool   bool        //布尔值
+	Null   bool        //RESP2的$-1、*-1，或RESP3的null
+	Elems  []RespValue //数组、集合和推送的元素，映射和属性按键、值交替存放
+	Attrs  []RespValue //RESP3属性，按键、值交替存放，编码时写在值之前
+}
+
+//NewRespSimpleString 新建简单字符串，如OK
+func NewRespSimpleString(s string) RespValue {
+	return RespValue{Type: RespSimpleString, Str: s}
+}
+
+//NewRespError 新建错误，如ERR unknown command
+func NewRespError(msg string) RespValue {
+	return RespValue{Type: RespError, Str: msg}
+}
+
+//NewRespInteger 新建整数
+func NewRespInteger(n int64) RespValue {
+	return RespValue{Type: RespInteger, Int: n}
+}
+
+//NewRespBulk 新建批量字符串
+func NewRespBulk(s string) RespValue {
+	return RespValue{Type: RespBulkString, Str: s}
+}
+
+//NewRespNull 新建null，RESP2下编码为$-1
+func NewRespNull() RespValue {
+	return RespValue{Type: RespNull, Null: true}
+}
+
+//NewRespArray 新建数组
+func NewRespArray(elems ...RespValue) RespValue {
+	return RespValue{Type: RespArray, Elems: elems}
+}
+
+//NewRespMap 新建映射，kvs按键、值交替传入
+func NewRespMap(kvs ...RespValue) RespValue {
+	return RespValue{Type: RespMap, Elems: kvs}
+}
+
+//NewRespCommand 新建由批量字符串组成的命令，如NewRespCommand("SET", "k", "v")
+func NewRespCommand(args ...string) RespValue {
+	elems := make([]RespValue, len(args))
+	for i, arg := range args {
+		elems[i] = NewRespBulk(arg)
+	}
+	return RespValue{Type: RespArray, Elems: elems}
+}
+
+//Args 将命令数组的元素转为字符串，不是数组时返回nil
+func (v RespValue) Args() []string {
+	if v.Type != RespArray || v.Null {
+		return nil
+	}
+	args := make([]string, len(v.Elems))
+	for i, e := range v.Elems {
+		args[i] = e.Str
+	}
+	return args
+}
+
+//Encode 按RESP3编码
+func (v RespValue) Encode(bo binary.ByteOrder) ([]byte, error) {
+	return v.AppendResp(nil, true)
+}
+
+//Transform 转为业务实体
+func (v RespValue) Transform(m Message) error {
+	return m.FromPacket(v)
+}
+
+//AppendResp 将值编码后追加到b，resp3为false时RESP3的类型转为RESP2中相近的类型
+func (v RespValue) AppendResp(b []byte, resp3 bool) ([]byte, error) {
+	var err error
+	if resp3 && len(v.Attrs) > 0 {
+		if b, err = appendRespAggregate(b, RespAttribute, v.Attrs, resp3); err != nil {
+			return b, err
+		}
+	}
+
+	t := v.Type
+	if v.Null || t == RespNull {
+		switch {
+		case resp3:
+			return append(b, "_\r\n"...), nil
+		case t == RespArray || t == RespSet || t == RespMap || t == RespPush:
+			return append(b, "*-1\r\n"...), nil
+		default:
+			return append(b, "$-1\r\n"...), nil
+		}
+	}
+
+	switch t {
+	case RespSimpleString, RespError:
+		return appendRespLine(b, t, v.Str)
+	case RespInteger:
+		return appendRespInt(b, RespInteger, v.Int), nil
+	case RespBulkString:
+		return appendRespBulk(b, RespBulkString, v.Str), nil
+	case RespArray:
+		return appendRespAggregate(b, RespArray, v.Elems, resp3)
+	case RespBoolean:
+		if !resp3 {
+			n := int64(0)
+			if v.Bool {
+				n = 1
+			}
+			return appendRespInt(b, RespInteger, n), nil
+		}
+		if v.Bool {
+			return append(b, "#t\r\n"...), nil
+		}
+		return append(b, "#f\r\n"...), nil
+	case RespDouble:
+		s := formatRespDouble(v.Double)
+		if !resp3 {
+			return appendRespBulk(b, RespBulkString, s), nil
+		}
+		return appendRespLine(b, RespDouble, s)
+	case RespBigNumber:
+		if !resp3 {
+			return appendRespBulk(b, RespBulkString, v.Str), nil
+		}
+		return appendRespLine(b, RespBigNumber, v.Str)
+	case RespBulkError:
+		if !resp3 {
+			return appendRespLine(b, RespError, v.Str)
+		}
+		return appendRespBulk(b, RespBulkError, v.Str), nil
+	case RespVerbatimString:
+		if !resp3 {
+			return appendRespBulk(b, RespBulkString, v.Str), nil
+		}
+		if len(v.Format) != 3 {
+			return b, RespProtocolError
+		}
+		return appendRespBulk(b, RespVerbatimString, v.Format+":"+v.Str), nil
+	case RespMap, RespAttribute:
+		if len(v.Elems)%2 != 0 {
+			return b, RespProtocolError
+		}
+		if !resp3 {
+			return appendRespAggregate(b, RespArray, v.Elems, resp3)
+		}
+		return appendRespAggregate(b, t, v.Elems, resp3)
+	case RespSet, RespPush:
+		if !resp3 {
+			return appendRespAggregate(b, RespArray, v.Elems, resp3)
+		}
+		return appendRespAggregate(b, t, v.Elems, resp3)
+	}
+	return b, RespProtocolError
+}
+
+//appendRespLine 简单类型的内容不能包含换行
+func appendRespLine(b []byte, t RespType, s string) ([]byte, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return b, RespProtocolError
+	}
+	b = append(b, byte(t))
+	b = append(b, s...)
+	return append(b, '\r', '\n'), nil
+}
+
+func appendRespInt(b []byte, t RespType, n int64) []byte {
+	b = append(b, byte(t))
+	b = strconv.AppendInt(b, n, 10)
+	return append(b, '\r', '\n')
+}
+
+func appendRespBulk(b []byte, t RespType, s string) []byte {
+	b = appendRespInt(b, t, int64(len(s)))
+	b = append(b, s...)
+	return append(b, '\r', '\n')
+}
+
+//appendRespAggregate 映射和属性的长度为键值对数
+func appendRespAggregate(b []byte, t RespType, elems []RespValue, resp3 bool) ([]byte, error) {
+	n := len(elems)
+	if t == RespMap || t == RespAttribute {
+		n /= 2
+	}
+	b = appendRespInt(b, t, int64(n))
+	var err error
+	for _, e := range elems {
+		if b, err = e.AppendResp(b, resp3); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+func formatRespDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,109 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/sumory/gotty/buffer"
+)
+
+//SniffCodec 按每帧的第一个字节在FixedLengthCodec和LengthBasedCodec之间选择，使发送定长记录的旧终端和长度前缀的客户端共用一个服务
+//第一个字节是fc按类型设置了长度的类型字节时按定长记录解析，产生RawPacket，否则交给lbc解析，产生LengthBasedPacket
+//写出时RawPacket按定长记录写出，其他包交给lbc；codec不保存连接的状态，同一连接上两种帧也可以混用
+type SniffCodec struct {
+	name string
+	lbc  *LengthBasedCodec
+	fc   *FixedLengthCodec
+	lead byte //lbc的帧的第一个字节
+}
+
+//NewSniffCodec 新建按第一个字节选择的编解码器，只有通过SetRecordSize设置过的类型按定长记录解析
+//lbc的帧必须以固定的字节开头：启用帧校验时为同步标记的第一个字节，否则须为大端且maxSize小于16MB，总长度的第一个字节为0
+//该字节不能同时是fc的类型字节
+func NewSniffCodec(lbc *LengthBasedCodec, fc *FixedLengthCodec) *SniffCodec {
+	sc := &SniffCodec{
+		name: "sniff codec",
+		lbc:  lbc,
+		fc:   fc,
+	}
+	if lbc.frameCheck {
+		marker := make([]byte, frameMarkerLen)
+		lbc.byteOrder.PutUint32(marker, lbc.magic)
+		sc.lead = marker[0]
+	} else if lbc.byteOrder != binary.BigEndian || lbc.maxSize <= 0 || lbc.maxSize >= 1<<24 {
+		panic("codec: length based frames must start with a fixed byte to be sniffed")
+	}
+	if !fc.typed {
+		panic("codec: fixed length records must be typed to be sniffed")
+	}
+	if fc.sizes[sc.lead] > 0 {
+		panic("codec: record type conflicts with the leading byte of length based frames")
+	}
+	return sc
+}
+
+func (sc *SniffCodec) Name() string {
+	return sc.name
+}
+
+//isRecord 第一个字节为typ的帧是否为定长记录
+func (sc *SniffCodec) isRecord(typ byte) bool {
+	return sc.fc.sizes[typ] > 0
+}
+
+//Read 读取一个定长记录或LengthBasedPacket，lbc重新同步时多读出的数据会被丢弃，session使用ReadWithState
+func (sc *SniffCodec) Read(bReader *bufio.Reader) (Packet, error) {
+	return sc.ReadWithState(bReader, nil)
+}
+
+//ReadWithState 实现StatefulReader，state中保存的数据在bReader的数据之前，有保存的数据时从中判断帧的类型
+//lbc启用帧校验时，损坏的帧之后到下一个同步标记之间的定长记录会随重新同步一起跳过
+func (sc *SniffCodec) ReadWithState(bReader *bufio.Reader, state *ReadState) (Packet, error) {
+	if state.Buffered() > 0 {
+		return ReadFrameWithState(bReader, sc, state)
+	}
+	head, err := bReader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if sc.isRecord(head[0]) {
+		return sc.fc.Read(bReader)
+	}
+	return sc.lbc.ReadWithState(bReader, state)
+}
+
+//DecodeFrame 实现FrameDecoder
+func (sc *SniffCodec) DecodeFrame(in *buffer.ByteBuf) (Packet, error) {
+	if !in.IsReadable(1) {
+		return nil, nil
+	}
+	if sc.isRecord(in.PeekUint8()) {
+		return sc.fc.DecodeFrame(in)
+	}
+	return sc.lbc.DecodeFrame(in)
+}
+
+//Write 将包写出
+func (sc *SniffCodec) Write(bWriter *bufio.Writer, p Packet) error {
+	if err := sc.WriteBuffered(bWriter, p); err != nil {
+		return err
+	}
+	return bWriter.Flush()
+}
+
+//WriteBuffered RawPacket按定长记录写入，其他包交给lbc，不flush
+func (sc *SniffCodec) WriteBuffered(bWriter *bufio.Writer, p Packet) error {
+	if _, ok := p.(RawPacket); ok {
+		return sc.fc.WriteBuffered(bWriter, p)
+	}
+	return sc.lbc.WriteBuffered(bWriter, p)
+}
+
+//Marshal 将业务实体转为packet，转为RawPacket的消息按定长记录写出
+func (sc *SniffCodec) Marshal(m Message) (Packet, error) {
+	return m.ToPacket()
+}
+
+//Unmarshal 将包转为业务实体
+func (sc *SniffCodec) Unmarshal(p Packet, m Message) error {
+	return p.Transform(m)
+}
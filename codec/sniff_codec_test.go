@@ -0,0 +1,112 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/smartystreets/goconvey/convey"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func Test_SniffCodec(t *testing.T) {
+	newFixed := func() *FixedLengthCodec {
+		return NewFixedLengthCodec(0).SetRecordSize(0x01, 64).SetRecordSize(0x02, 128)
+	}
+	record64 := "\x01" + strings.Repeat("a", 63)
+	record128 := "\x02" + strings.Repeat("b", 127)
+
+	convey.Convey("Records and length based packets should share one stream", t, func() {
+		for _, lbc := range []*LengthBasedCodec{
+			NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil),
+			NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0xCAFEBABE),
+		} {
+			sc := NewSniffCodec(lbc, newFixed())
+			var out bytes.Buffer
+			w := bufio.NewWriter(&out)
+			convey.So(sc.WriteBuffered(w, NewRawPacket([]byte(record64))), convey.ShouldBeNil)
+			convey.So(sc.WriteBuffered(w, newFrameCheckPacket(1, "hello")), convey.ShouldBeNil)
+			p, _ := sc.Marshal(NewTextMessage(record128))
+			convey.So(sc.WriteBuffered(w, p), convey.ShouldBeNil)
+			convey.So(sc.Write(w, newFrameCheckPacket(2, "world")), convey.ShouldBeNil)
+
+			check := func(packets []Packet) {
+				convey.So(len(packets), convey.ShouldEqual, 4)
+				convey.So(packets[0].(RawPacket).String(), convey.ShouldEqual, record64)
+				convey.So(string(packets[1].(LengthBasedPacket).Body.Data), convey.ShouldEqual, "hello")
+				convey.So(packets[2].(RawPacket).String(), convey.ShouldEqual, record128)
+				convey.So(packets[3].(LengthBasedPacket).Header.Sequence, convey.ShouldEqual, 2)
+			}
+
+			r := bufio.NewReaderSize(iotest.HalfReader(bytes.NewReader(out.Bytes())), 16)
+			var packets []Packet
+			for {
+				p, err := sc.Read(r)
+				if err != nil {
+					convey.So(err, convey.ShouldEqual, io.EOF)
+					break
+				}
+				packets = append(packets, p)
+			}
+			check(packets)
+
+			sd := NewStreamDecoder(sc)
+			packets = nil
+			for _, c := range out.Bytes() {
+				decoded, err := sd.Feed([]byte{c})
+				convey.So(err, convey.ShouldBeNil)
+				packets = append(packets, decoded...)
+			}
+			check(packets)
+		}
+	})
+
+	convey.Convey("Bytes read ahead while resyncing should be sniffed before the reader", t, func() {
+		lbc := NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0xCAFEBABE)
+		sc := NewSniffCodec(lbc, newFixed())
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		sc.WriteBuffered(w, newFrameCheckPacket(1, "hello"))
+		sc.WriteBuffered(w, newFrameCheckPacket(2, "world"))
+		sc.WriteBuffered(w, NewRawPacket([]byte(record64)))
+		sc.Write(w, newFrameCheckPacket(3, strings.Repeat("y", 8000)))
+		data := out.Bytes()
+		binary.BigEndian.PutUint32(data[frameMarkerLen:], 6000) //首帧长度被篡改，超过读缓冲
+
+		r := bufio.NewReaderSize(bytes.NewReader(data), 4096)
+		state := &ReadState{}
+		p, err := sc.ReadWithState(r, state)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.(LengthBasedPacket).Header.Sequence, convey.ShouldEqual, 2)
+		convey.So(state.Buffered(), convey.ShouldBeGreaterThan, 0)
+		p, err = sc.ReadWithState(r, state)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.(RawPacket).String(), convey.ShouldEqual, record64)
+		p, err = sc.ReadWithState(r, state)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.(LengthBasedPacket).Header.Sequence, convey.ShouldEqual, 3)
+		_, err = sc.ReadWithState(r, state)
+		convey.So(err, convey.ShouldEqual, io.EOF)
+	})
+
+	convey.Convey("Ambiguous configurations should be rejected", t, func() {
+		convey.So(func() {
+			NewSniffCodec(NewLengthBasedCodec(binary.LittleEndian, 64*1024, nil, nil), newFixed())
+		}, convey.ShouldPanic)
+		convey.So(func() {
+			NewSniffCodec(NewLengthBasedCodec(binary.BigEndian, 0, nil, nil), newFixed())
+		}, convey.ShouldPanic)
+		convey.So(func() {
+			NewSniffCodec(NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil), NewFixedLengthCodec(64))
+		}, convey.ShouldPanic)
+		convey.So(func() {
+			NewSniffCodec(NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil), newFixed().SetRecordSize(0x00, 8))
+		}, convey.ShouldPanic)
+		convey.So(func() {
+			lbc := NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableFrameCheck(0x01020304)
+			NewSniffCodec(lbc, newFixed())
+		}, convey.ShouldPanic)
+	})
+}
@@ -0,0 +1,149 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/sumory/gotty/buffer"
+	"github.com/sumory/gotty/utils"
+	"io"
+	"net"
+)
+
+//VarintCodec 以protobuf风格的无符号varint作为长度前缀的编解码器，产生RawPacket
+//帧格式: uvarint(len) + data，长度前缀最多10个字节，超过或溢出64位时返回MalformedVarintError
+type VarintCodec struct {
+	name    string
+	maxSize int //data的最大长度，在分配内存前校验，小于等于0表示不限制
+}
+
+//NewVarintCodec 新建varint长度前缀编解码器，maxSize与LengthBasedCodec相同，小于等于0时不限制长度，只要求能用int表示
+func NewVarintCodec(maxSize int) *VarintCodec {
+	return &VarintCodec{
+		name:    "varint codec",
+		maxSize: maxSize,
+	}
+}
+
+func (vc *VarintCodec) Name() string {
+	return vc.name
+}
+
+//parseLength 解析长度前缀，数据不足时返回的n为0
+func (vc *VarintCodec) parseLength(head []byte) (int, int, error) {
+	v, n := binary.Uvarint(head)
+	if n < 0 {
+		return 0, 0, MalformedVarintError
+	}
+	if n == 0 {
+		//10个字节都带有后续标记
+		if len(head) >= binary.MaxVarintLen64 {
+			return 0, 0, MalformedVarintError
+		}
+		return 0, 0, nil
+	}
+	if v > uint64(^uint(0)>>1) || vc.maxSize > 0 && v > uint64(vc.maxSize) {
+		return 0, 0, PacketTooLargeError
+	}
+	return int(v), n, nil
+}
+
+//Read 读取长度前缀，校验最大长度后再分配内存读取数据
+func (vc *VarintCodec) Read(bReader *bufio.Reader) (Packet, error) {
+	var size, n int
+	for want := 1; n == 0; want++ {
+		head, err := bReader.Peek(want)
+		if err != nil {
+			if err == io.EOF && want > 1 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if size, n, err = vc.parseLength(head); err != nil {
+			return nil, err
+		}
+	}
+	bReader.Discard(n)
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(bReader, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return RawPacket{Data: data}, nil
+}
+
+//DecodeFrame 实现FrameDecoder
+func (vc *VarintCodec) DecodeFrame(in *buffer.ByteBuf) (Packet, error) {
+	head := in.Bytes()
+	if len(head) > binary.MaxVarintLen64 {
+		head = head[:binary.MaxVarintLen64]
+	}
+	size, n, err := vc.parseLength(head)
+	if err != nil || n == 0 || !in.IsReadable(n+size) {
+		return nil, err
+	}
+	in.Skip(n)
+	//in的数据之后会被覆盖，需复制
+	return RawPacket{Data: append([]byte(nil), in.ReadBytes(size)...)}, nil
+}
+
+//Write 将包写出
+func (vc *VarintCodec) Write(bWriter *bufio.Writer, p Packet) error {
+	if err := vc.WriteBuffered(bWriter, p); err != nil {
+		return err
+	}
+	return bWriter.Flush()
+}
+
+//WriteBuffered 写入长度前缀和数据，不flush
+func (vc *VarintCodec) WriteBuffered(bWriter *bufio.Writer, p Packet) error {
+	rp, err := vc.check(p)
+	if err != nil {
+		return err
+	}
+	var prefix [binary.MaxVarintLen64]byte
+	bWriter.Write(prefix[:binary.PutUvarint(prefix[:], uint64(len(rp.Data)))])
+	_, err = bWriter.Write(rp.Data)
+	return err
+}
+
+//AppendBuffers 实现VectorWriter，较大的数据直接引用而不复制
+func (vc *VarintCodec) AppendBuffers(bufs net.Buffers, p Packet) (net.Buffers, error) {
+	rp, err := vc.check(p)
+	if err != nil {
+		return bufs, err
+	}
+	size := uint64(len(rp.Data))
+	prefixLen := utils.UvarintSize(size)
+	if len(rp.Data) >= vectorBodyThreshold {
+		prefix := make([]byte, prefixLen)
+		binary.PutUvarint(prefix, size)
+		return append(bufs, prefix, rp.Data), nil
+	}
+	frame := make([]byte, prefixLen, prefixLen+len(rp.Data))
+	binary.PutUvarint(frame, size)
+	return append(bufs, append(frame, rp.Data...)), nil
+}
+
+func (vc *VarintCodec) check(p Packet) (RawPacket, error) {
+	rp, ok := p.(RawPacket)
+	if !ok {
+		return rp, PacketNotRawError
+	}
+	if vc.maxSize > 0 && len(rp.Data) > vc.maxSize {
+		return rp, PacketTooLargeError
+	}
+	return rp, nil
+}
+
+//Marshal 将消息的字节转为RawPacket
+func (vc *VarintCodec) Marshal(m Message) (Packet, error) {
+	return rawMarshal(m)
+}
+
+//Unmarshal 将包转为业务实体
+func (vc *VarintCodec) Unmarshal(p Packet, m Message) error {
+	return p.Transform(m)
+}
@@ -0,0 +1,113 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/smartystreets/goconvey/convey"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func Test_VarintCodec(t *testing.T) {
+	sizes := []int{0, 1, 127, 128, 300, 16383, 16384, 100000}
+
+	convey.Convey("Frames should round trip through Write, AppendBuffers, Read and DecodeFrame", t, func() {
+		vc := NewVarintCodec(1024 * 1024)
+		var written, vectored bytes.Buffer
+		w := bufio.NewWriter(&written)
+		for _, size := range sizes {
+			data := []byte(strings.Repeat("v", size))
+			convey.So(vc.WriteBuffered(w, NewRawPacket(data)), convey.ShouldBeNil)
+			bufs, err := vc.AppendBuffers(nil, NewRawPacket(data))
+			convey.So(err, convey.ShouldBeNil)
+			bufs.WriteTo(&vectored)
+		}
+		w.Flush()
+		convey.So(vectored.Bytes(), convey.ShouldResemble, written.Bytes())
+		convey.So(written.Bytes()[:3], convey.ShouldResemble, []byte{0x00, 0x01, 'v'})
+
+		r := bufio.NewReaderSize(iotest.HalfReader(bytes.NewReader(written.Bytes())), 16)
+		for _, size := range sizes {
+			p, err := vc.Read(r)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(p.(RawPacket).Data), convey.ShouldEqual, size)
+		}
+		_, err := vc.Read(r)
+		convey.So(err, convey.ShouldEqual, io.EOF)
+
+		sd := NewStreamDecoder(vc)
+		var decoded []int
+		for _, c := range written.Bytes() {
+			packets, err := sd.Feed([]byte{c})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range packets {
+				decoded = append(decoded, len(p.(RawPacket).Data))
+			}
+		}
+		convey.So(decoded, convey.ShouldResemble, sizes)
+	})
+
+	convey.Convey("Decoded frames should not alias the fed data", t, func() {
+		vc := NewVarintCodec(1024)
+		packets, err := feedAndOverwrite(vc, []byte("\x05hello\x02hi"))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(packets), convey.ShouldEqual, 2)
+		convey.So(string(packets[0].(RawPacket).Data), convey.ShouldEqual, "hello")
+		convey.So(string(packets[1].(RawPacket).Data), convey.ShouldEqual, "hi")
+	})
+
+	convey.Convey("Malformed prefixes should be rejected", t, func() {
+		vc := NewVarintCodec(1024)
+		for _, prefix := range []string{
+			strings.Repeat("\xff", 10) + "\x01", //11个字节
+			strings.Repeat("\x80", 12),
+			strings.Repeat("\xff", 9) + "\x02", //溢出64位
+		} {
+			_, err := vc.Read(bufio.NewReader(strings.NewReader(prefix)))
+			convey.So(err, convey.ShouldEqual, MalformedVarintError)
+			_, err = NewStreamDecoder(vc).Feed([]byte(prefix))
+			convey.So(err, convey.ShouldEqual, MalformedVarintError)
+		}
+	})
+
+	convey.Convey("Lengths over the max size should fail before the body is read", t, func() {
+		vc := NewVarintCodec(128)
+		r := bufio.NewReader(io.MultiReader(strings.NewReader("\x81\x01"), blockingReader{}))
+		_, err := vc.Read(r)
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+		_, err = vc.Read(bufio.NewReader(strings.NewReader(strings.Repeat("\xff", 9) + "\x01")))
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+		_, err = NewStreamDecoder(vc).Feed([]byte("\x81\x01"))
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+
+		convey.So(vc.Write(bufio.NewWriter(io.Discard), NewRawPacket(make([]byte, 129))), convey.ShouldEqual, PacketTooLargeError)
+		_, err = vc.AppendBuffers(nil, NewRawPacket(make([]byte, 129)))
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+		convey.So(vc.Write(bufio.NewWriter(io.Discard), newFrameCheckPacket(1, "x")), convey.ShouldEqual, PacketNotRawError)
+	})
+
+	convey.Convey("A max size of zero should not limit the length", t, func() {
+		vc := NewVarintCodec(0)
+		data := make([]byte, 4096)
+		var out bytes.Buffer
+		convey.So(vc.Write(bufio.NewWriter(&out), NewRawPacket(data)), convey.ShouldBeNil)
+		p, err := vc.Read(bufio.NewReader(&out))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(p.(RawPacket).Data), convey.ShouldEqual, len(data))
+		//超出int的长度仍然拒绝
+		_, err = vc.Read(bufio.NewReader(strings.NewReader(strings.Repeat("\xff", 9) + "\x01")))
+		convey.So(err, convey.ShouldEqual, PacketTooLargeError)
+	})
+
+	convey.Convey("Truncated frames should be unexpected EOFs", t, func() {
+		vc := NewVarintCodec(128)
+		_, err := vc.Read(bufio.NewReader(strings.NewReader("\x80")))
+		convey.So(err, convey.ShouldEqual, io.ErrUnexpectedEOF)
+		_, err = vc.Read(bufio.NewReader(strings.NewReader("\x05abc")))
+		convey.So(err, convey.ShouldEqual, io.ErrUnexpectedEOF)
+	})
+}
@@ -10,6 +10,16 @@ type GottyConfig struct {
 	WriteChanSize       int
 	IdleTime            time.Duration
 	DispatcherQueueSize chan int //缓冲
+
+	//读水位：已读入未处理完的包数或字节数达到高水位时暂停读取，降到低水位以下恢复，0表示不限制
+	ReadHighWatermark      int
+	ReadLowWatermark       int
+	ReadHighWatermarkBytes int
+	ReadLowWatermarkBytes  int
+
+	//写水位：写队列中待写出的字节数达到高水位时session变为不可写，降到低水位以下恢复，0表示不限制
+	WriteHighWatermarkBytes int
+	WriteLowWatermarkBytes  int
 }
 
 func NewGottyConfig(name string, //
@@ -0,0 +1,88 @@
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/sumory/gotty/codec"
+)
+
+//流控制包的Header.Extra: streamId(4) + flag(1) [+ 窗口增量(4)]
+//flag为1表示该包的发送方是流的发起方，双方各自分配id互不冲突
+const (
+	frameExtraLen       = 4 + 1
+	frameWindowExtraLen = frameExtraLen + 4
+
+	flagInitiator byte = 1
+)
+
+// Errors
+var (
+	InvalidFrameError      = errors.New("Invalid stream frame")
+	StreamResetError       = errors.New("Stream reset by peer")
+	StreamClosedError      = errors.New("Stream closed")
+	MuxClosedError         = errors.New("Mux closed")
+	AcceptBacklogError     = errors.New("Accept backlog is full")
+	WindowExceededError    = errors.New("Peer exceeded receive window")
+	SessionClosedError     = errors.New("Session closed")
+	TooManyStreamsError    = errors.New("Too many streams")
+	StreamIdExhaustedError = errors.New("Stream id exhausted")
+)
+
+//frame 解析后的流控制包
+type frame struct {
+	op        uint16
+	id        uint32
+	initiator bool //发送方是否为流的发起方
+	increment uint32
+	data      []byte
+}
+
+func newFrame(op uint16, id uint32, initiator bool, increment uint32, data []byte) codec.LengthBasedPacket {
+	extraLen := frameExtraLen
+	if op == codec.OperationStreamWindow {
+		extraLen = frameWindowExtraLen
+	}
+	extra := make([]byte, extraLen)
+	binary.BigEndian.PutUint32(extra[0:4], id)
+	if initiator {
+		extra[4] = flagInitiator
+	}
+	if op == codec.OperationStreamWindow {
+		binary.BigEndian.PutUint32(extra[5:9], increment)
+	}
+
+	header := &codec.LengthBasedPacketHeader{
+		Operation: op,
+		Extra:     extra,
+	}
+	body := &codec.LengthBasedPacketBody{
+		Data: data,
+	}
+	meta := &codec.LengthBasedPacketMeta{
+		TotalLen:  uint32(8 + header.Len() + body.Len()),
+		HeaderLen: uint32(header.Len()),
+	}
+	return codec.LengthBasedPacket{Meta: meta, Header: header, Body: body}
+}
+
+func isStreamOperation(op uint16) bool {
+	return op >= codec.OperationStreamOpen && op <= codec.OperationStreamReset
+}
+
+func parseFrame(p codec.LengthBasedPacket) (frame, error) {
+	f := frame{op: p.Header.Operation}
+	extra := p.Header.Extra
+	if len(extra) < frameExtraLen {
+		return f, InvalidFrameError
+	}
+	f.id = binary.BigEndian.Uint32(extra[0:4])
+	f.initiator = extra[4] == flagInitiator
+	if f.op == codec.OperationStreamWindow {
+		if len(extra) < frameWindowExtraLen {
+			return f, InvalidFrameError
+		}
+		f.increment = binary.BigEndian.Uint32(extra[5:9])
+	}
+	f.data = p.Body.Data
+	return f, nil
+}
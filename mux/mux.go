@@ -0,0 +1,301 @@
+package mux
+
+import (
+	"context"
+	"fmt"
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/session"
+	log "github.com/sumory/log4go"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Config 多路复用配置
+type Config struct {
+	MaxFrameSize  int    //单个数据包携带的最大数据长度，应小于codec的maxSize
+	WindowSize    uint32 //每个流的接收窗口
+	AcceptBacklog int    //等待Accept的流个数上限
+	MaxStreams    int    //同时存在的流个数上限
+}
+
+//DefaultConfig 默认配置
+func DefaultConfig() *Config {
+	return &Config{
+		MaxFrameSize:  32 * 1024,
+		WindowSize:    256 * 1024,
+		AcceptBacklog: 256,
+		MaxStreams:    1024,
+	}
+}
+
+type streamKey struct {
+	id    uint32
+	local bool //是否由本端发起
+}
+
+//Mux 在一个session上复用多条双向流，每条流实现net.Conn，Mux本身实现net.Listener
+//流共享session的认证、心跳和过滤器，各自拥有独立的流控窗口
+//Mux同时是session的过滤器，需在session Start之前通过AddFilter挂上:
+//  server.AddFilter(func(s *session.Session) session.Filter {
+//      m := mux.NewMux(s, mux.DefaultConfig())
+//      go http.Serve(m, handler)
+//      return m
+//  })
+type Mux struct {
+	session *session.Session
+	config  *Config
+
+	lock     sync.Mutex
+	streams  map[streamKey]*Stream
+	nextId   uint32
+	acceptCh chan *Stream
+	closeCh  chan struct{}
+	closed   int32
+	ctx      context.Context //关闭时取消，结束等待写队列的写入
+	cancel   context.CancelFunc
+}
+
+//NewMux 新建多路复用器
+func NewMux(s *session.Session, config *Config) *Mux {
+	m := &Mux{
+		session:  s,
+		config:   config,
+		streams:  make(map[streamKey]*Stream),
+		acceptCh: make(chan *Stream, config.AcceptBacklog),
+		closeCh:  make(chan struct{}),
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	go m.watch()
+	return m
+}
+
+//OpenStream 打开一条新的流
+func (m *Mux) OpenStream() (*Stream, error) {
+	if m.Closed() {
+		return nil, MuxClosedError
+	}
+
+	m.lock.Lock()
+	if len(m.streams) >= m.config.MaxStreams {
+		m.lock.Unlock()
+		return nil, TooManyStreamsError
+	}
+	m.nextId++
+	if m.nextId == 0 {
+		m.lock.Unlock()
+		return nil, StreamIdExhaustedError
+	}
+	st := newStream(m, m.nextId, true)
+	m.streams[st.key()] = st
+	m.lock.Unlock()
+
+	if err := m.write(newFrame(codec.OperationStreamOpen, st.id, true, 0, nil), time.Time{}); err != nil {
+		m.remove(st)
+		return nil, err
+	}
+	return st, nil
+}
+
+//Dial 打开一条新的流，参数仅为兼容net.Dial的签名
+func (m *Mux) Dial(network, address string) (net.Conn, error) {
+	return m.OpenStream()
+}
+
+//AcceptStream 等待对方打开的流
+func (m *Mux) AcceptStream() (*Stream, error) {
+	select {
+	case st := <-m.acceptCh:
+		return st, nil
+	case <-m.closeCh:
+		return nil, MuxClosedError
+	}
+}
+
+//Accept 实现net.Listener
+func (m *Mux) Accept() (net.Conn, error) {
+	return m.AcceptStream()
+}
+
+//Addr 实现net.Listener
+func (m *Mux) Addr() net.Addr {
+	return Addr{addr: m.session.LocalAddr()}
+}
+
+//Close 关闭所有流，不关闭底层session
+func (m *Mux) Close() error {
+	m.shutdown(MuxClosedError, true)
+	return nil
+}
+
+//Closed 是否已关闭
+func (m *Mux) Closed() bool {
+	return atomic.LoadInt32(&m.closed) == 1
+}
+
+//NumStreams 当前流的个数
+func (m *Mux) NumStreams() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.streams)
+}
+
+//watch session关闭时关闭所有流
+func (m *Mux) watch() {
+	select {
+	case <-m.session.Done():
+		m.shutdown(SessionClosedError, false)
+	case <-m.closeCh:
+	}
+}
+
+func (m *Mux) shutdown(err error, notifyPeer bool) {
+	if !atomic.CompareAndSwapInt32(&m.closed, 0, 1) {
+		return
+	}
+	close(m.closeCh)
+	m.cancel()
+
+	m.lock.Lock()
+	streams := make([]*Stream, 0, len(m.streams))
+	for _, st := range m.streams {
+		streams = append(streams, st)
+	}
+	m.streams = make(map[streamKey]*Stream)
+	m.lock.Unlock()
+
+	for _, st := range streams {
+		st.reset(err)
+		if notifyPeer {
+			m.session.Write(newFrame(codec.OperationStreamReset, st.id, st.local, 0, nil))
+		}
+	}
+}
+
+func (m *Mux) remove(st *Stream) {
+	m.lock.Lock()
+	if m.streams[st.key()] == st {
+		delete(m.streams, st.key())
+	}
+	m.lock.Unlock()
+}
+
+//write 写出流控制包，写队列满时等待到deadline
+func (m *Mux) write(p codec.Packet, deadline time.Time) error {
+	ctx := m.ctx
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	err := m.session.WriteContext(ctx, p)
+	switch {
+	case err == nil:
+		return nil
+	case err == session.SessionClosedError:
+		return SessionClosedError
+	case m.Closed():
+		return MuxClosedError
+	case err == context.DeadlineExceeded:
+		return os.ErrDeadlineExceeded
+	}
+	return err
+}
+
+//OnRead 处理流控制包，其他包原样交给后续处理
+func (m *Mux) OnRead(s *session.Session, p codec.Packet) (codec.Packet, error) {
+	lbp, ok := p.(codec.LengthBasedPacket)
+	if !ok || !isStreamOperation(lbp.Header.Operation) {
+		return p, nil
+	}
+	f, err := parseFrame(lbp)
+	if err != nil {
+		return nil, err
+	}
+	return nil, m.handle(f)
+}
+
+//OnWrite 实现session.Filter
+func (m *Mux) OnWrite(s *session.Session, p codec.Packet) ([]codec.Packet, error) {
+	return []codec.Packet{p}, nil
+}
+
+//handle 在session的读协程中调用，不能阻塞
+func (m *Mux) handle(f frame) error {
+	key := streamKey{id: f.id, local: !f.initiator}
+	m.lock.Lock()
+	st := m.streams[key]
+	m.lock.Unlock()
+
+	switch f.op {
+	case codec.OperationStreamOpen:
+		if !f.initiator || st != nil {
+			return InvalidFrameError
+		}
+		return m.accept(f.id)
+	case codec.OperationStreamData:
+		if st == nil {
+			//流已在本端关闭，丢弃迟到的数据
+			return nil
+		}
+		if err := st.receive(f.data); err != nil {
+			log.Warn("stream %d receive failed: %s", f.id, err)
+			st.reset(err)
+			m.session.Write(newFrame(codec.OperationStreamReset, st.id, st.local, 0, nil))
+		}
+	case codec.OperationStreamWindow:
+		if st != nil {
+			st.addWindow(f.increment)
+		}
+	case codec.OperationStreamClose:
+		if st != nil {
+			st.remoteClose()
+		}
+	case codec.OperationStreamReset:
+		if st != nil {
+			st.reset(StreamResetError)
+		}
+	}
+	return nil
+}
+
+func (m *Mux) accept(id uint32) error {
+	if m.Closed() {
+		return MuxClosedError
+	}
+	st := newStream(m, id, false)
+
+	m.lock.Lock()
+	if len(m.streams) >= m.config.MaxStreams {
+		m.lock.Unlock()
+		m.session.Write(newFrame(codec.OperationStreamReset, id, false, 0, nil))
+		return TooManyStreamsError
+	}
+	m.streams[st.key()] = st
+	m.lock.Unlock()
+
+	select {
+	case m.acceptCh <- st:
+		return nil
+	default:
+		m.remove(st)
+		m.session.Write(newFrame(codec.OperationStreamReset, id, false, 0, nil))
+		return AcceptBacklogError
+	}
+}
+
+//Addr 流的地址，由session地址和流id组成
+type Addr struct {
+	addr string
+	id   uint32
+}
+
+func (a Addr) Network() string {
+	return "gotty-mux"
+}
+
+func (a Addr) String() string {
+	return fmt.Sprintf("%s/%d", a.addr, a.id)
+}
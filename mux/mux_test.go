@@ -0,0 +1,174 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/config"
+	"github.com/sumory/gotty/session"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+//newMuxPair 建立一条本地连接，返回两端session上的Mux
+func newMuxPair(cfg *Config) (*Mux, *Mux) {
+	listener, _ := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	defer listener.Close()
+
+	accepted := make(chan *net.TCPConn, 1)
+	go func() {
+		conn, _ := listener.AcceptTCP()
+		accepted <- conn
+	}()
+	conn, _ := net.DialTCP("tcp4", nil, listener.Addr().(*net.TCPAddr))
+
+	handler := func(s *session.Session, p codec.Packet) {}
+	newMux := func(conn *net.TCPConn) *Mux {
+		lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		s := session.NewSession(conn, lbc, config.NewDefaultGottyConfig(), handler)
+		m := NewMux(s, cfg)
+		s.AddFilter(m)
+		s.Start()
+		return m
+	}
+	return newMux(conn), newMux(<-accepted)
+}
+
+func Test_Mux(t *testing.T) {
+	convey.Convey("Streams should echo data under flow control", t, func() {
+		cfg := DefaultConfig()
+		cfg.MaxFrameSize = 4 * 1024
+		cfg.WindowSize = 16 * 1024
+		client, server := newMuxPair(cfg)
+		defer client.session.Close()
+
+		go func() {
+			for {
+				conn, err := server.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(conn, conn)
+					conn.Close()
+				}()
+			}
+		}()
+
+		data := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+		results := make(chan bool, 4)
+		for i := 0; i < 4; i++ {
+			go func() {
+				st, err := client.OpenStream()
+				if err != nil {
+					results <- false
+					return
+				}
+				go func() {
+					st.Write(data)
+					st.CloseWrite()
+				}()
+				got, err := ioutil.ReadAll(st)
+				st.Close()
+				results <- err == nil && bytes.Equal(got, data)
+			}()
+		}
+		for i := 0; i < 4; i++ {
+			convey.So(<-results, convey.ShouldBeTrue)
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for (client.NumStreams() > 0 || server.NumStreams() > 0) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		convey.So(client.NumStreams(), convey.ShouldEqual, 0)
+		convey.So(server.NumStreams(), convey.ShouldEqual, 0)
+	})
+
+	convey.Convey("Read should honor the deadline", t, func() {
+		client, server := newMuxPair(DefaultConfig())
+		defer client.session.Close()
+
+		st, _ := client.OpenStream()
+		_, err := server.AcceptStream()
+		convey.So(err, convey.ShouldBeNil)
+
+		st.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, err = st.Read(make([]byte, 16))
+		convey.So(err, convey.ShouldEqual, os.ErrDeadlineExceeded)
+		convey.So(err.(net.Error).Timeout(), convey.ShouldBeTrue)
+	})
+
+	convey.Convey("Streams should fail when the session closes", t, func() {
+		client, server := newMuxPair(DefaultConfig())
+
+		st, _ := client.OpenStream()
+		peer, _ := server.AcceptStream()
+		client.session.Close()
+
+		_, err := peer.Read(make([]byte, 16))
+		convey.So(err, convey.ShouldEqual, SessionClosedError)
+		_, err = st.Write([]byte("x"))
+		convey.So(err, convey.ShouldEqual, SessionClosedError)
+		_, err = client.OpenStream()
+		convey.So(err, convey.ShouldEqual, MuxClosedError)
+	})
+
+	convey.Convey("A window update that cannot be sent should be retried", t, func() {
+		cfg := DefaultConfig()
+		cfg.WindowSize = 16 * 1024
+		client, server := newMuxPair(cfg)
+		defer server.session.Close()
+
+		st, _ := client.OpenStream()
+		peer, _ := server.AcceptStream()
+		peer.Write(make([]byte, cfg.WindowSize/2))
+		buffered := func() int {
+			st.lock.Lock()
+			defer st.lock.Unlock()
+			return st.readBuf.Len()
+		}
+		for buffered() < int(cfg.WindowSize/2) {
+			time.Sleep(time.Millisecond)
+		}
+
+		client.session.Close()
+		n, err := st.Read(make([]byte, cfg.WindowSize))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(n, convey.ShouldEqual, cfg.WindowSize/2)
+		st.lock.Lock()
+		convey.So(st.consumed, convey.ShouldEqual, cfg.WindowSize/2)
+		convey.So(st.recvWindow, convey.ShouldEqual, cfg.WindowSize/2)
+		st.lock.Unlock()
+	})
+
+	convey.Convey("HTTP should work over the mux", t, func() {
+		client, server := newMuxPair(DefaultConfig())
+		defer client.session.Close()
+
+		go http.Serve(server, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "hello %s", r.URL.Path[1:])
+		}))
+
+		httpClient := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return client.Dial(network, addr)
+			},
+		}}
+		for _, name := range []string{"a", "b", "c"} {
+			resp, err := httpClient.Get("http://gotty/" + name)
+			convey.So(err, convey.ShouldBeNil)
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			convey.So(string(body), convey.ShouldEqual, "hello "+name)
+		}
+	})
+}
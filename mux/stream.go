@@ -0,0 +1,304 @@
+package mux
+
+import (
+	"bytes"
+	"github.com/sumory/gotty/codec"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+//Stream 多路复用的逻辑流，实现net.Conn
+type Stream struct {
+	id    uint32
+	local bool //是否由本端发起
+	mux   *Mux
+
+	lock          sync.Mutex
+	readBuf       bytes.Buffer
+	recvWindow    uint32 //剩余接收窗口
+	consumed      uint32 //已读取但未通告对方的字节数
+	sendWindow    uint32 //剩余发送窗口
+	remoteClosed  bool   //已收到对方的CLOSE
+	writeClosed   bool   //本端已发送CLOSE
+	localClosed   bool   //本端已Close，不再读取
+	err           error  //被reset或session关闭后的错误
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readNotify  chan struct{}
+	writeNotify chan struct{}
+	writeLock   sync.Mutex //串行化Write，保证数据顺序
+}
+
+func newStream(m *Mux, id uint32, local bool) *Stream {
+	return &Stream{
+		id:          id,
+		local:       local,
+		mux:         m,
+		recvWindow:  m.config.WindowSize,
+		sendWindow:  m.config.WindowSize,
+		readNotify:  make(chan struct{}, 1),
+		writeNotify: make(chan struct{}, 1),
+	}
+}
+
+func (st *Stream) key() streamKey {
+	return streamKey{id: st.id, local: st.local}
+}
+
+//ID 流标识，双方发起的流id可能相同
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+//Read 实现net.Conn，对方关闭后读完缓冲数据返回io.EOF
+func (st *Stream) Read(b []byte) (int, error) {
+	for {
+		st.lock.Lock()
+		if st.readBuf.Len() > 0 {
+			n, _ := st.readBuf.Read(b)
+			st.consumed += uint32(n)
+			st.lock.Unlock()
+			//通告失败时数据已经读出，留待下次Read重试
+			st.updateWindow()
+			return n, nil
+		}
+		if st.err != nil {
+			err := st.err
+			st.lock.Unlock()
+			return 0, err
+		}
+		if st.localClosed {
+			st.lock.Unlock()
+			return 0, StreamClosedError
+		}
+		if st.remoteClosed {
+			st.lock.Unlock()
+			return 0, io.EOF
+		}
+		deadline := st.readDeadline
+		st.lock.Unlock()
+
+		//之前没能通告的窗口需先补上，否则对方可能一直等待窗口而不再发来数据
+		if err := st.updateWindow(); err != nil {
+			return 0, err
+		}
+		if err := wait(st.readNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+//updateWindow 已读取的字节达到窗口一半时通告对方，写出失败时撤回，下次Read时重试
+func (st *Stream) updateWindow() error {
+	st.lock.Lock()
+	if st.consumed < st.mux.config.WindowSize/2 {
+		st.lock.Unlock()
+		return nil
+	}
+	increment := st.consumed
+	st.consumed = 0
+	//先增加接收窗口，对方收到通告后立即发来的数据不会超出窗口
+	st.recvWindow += increment
+	deadline := st.readDeadline
+	st.lock.Unlock()
+
+	err := st.mux.write(newFrame(codec.OperationStreamWindow, st.id, st.local, increment, nil), deadline)
+	if err != nil {
+		//通告没有写出，对方不会按新窗口发送数据
+		st.lock.Lock()
+		st.recvWindow -= increment
+		st.consumed += increment
+		st.lock.Unlock()
+	}
+	return err
+}
+
+//Write 实现net.Conn，按发送窗口和MaxFrameSize切分成多个数据包
+func (st *Stream) Write(b []byte) (int, error) {
+	st.writeLock.Lock()
+	defer st.writeLock.Unlock()
+
+	total := 0
+	for len(b) > 0 {
+		st.lock.Lock()
+		if st.err != nil {
+			err := st.err
+			st.lock.Unlock()
+			return total, err
+		}
+		if st.writeClosed {
+			st.lock.Unlock()
+			return total, StreamClosedError
+		}
+		deadline := st.writeDeadline
+		if st.sendWindow == 0 {
+			st.lock.Unlock()
+			if err := wait(st.writeNotify, deadline); err != nil {
+				return total, err
+			}
+			continue
+		}
+		n := len(b)
+		if n > st.mux.config.MaxFrameSize {
+			n = st.mux.config.MaxFrameSize
+		}
+		if uint32(n) > st.sendWindow {
+			n = int(st.sendWindow)
+		}
+		st.sendWindow -= uint32(n)
+		st.lock.Unlock()
+
+		//数据在写队列中排队，需复制一份
+		data := append([]byte(nil), b[:n]...)
+		if err := st.mux.write(newFrame(codec.OperationStreamData, st.id, st.local, 0, data), deadline); err != nil {
+			return total, err
+		}
+		total += n
+		b = b[n:]
+	}
+	return total, nil
+}
+
+//CloseWrite 关闭写方向，对方读完数据后得到io.EOF，本端仍可继续读取
+func (st *Stream) CloseWrite() error {
+	st.lock.Lock()
+	if st.writeClosed || st.err != nil {
+		st.lock.Unlock()
+		return nil
+	}
+	st.writeClosed = true
+	remoteClosed := st.remoteClosed
+	st.lock.Unlock()
+	notify(st.writeNotify)
+
+	err := st.mux.write(newFrame(codec.OperationStreamClose, st.id, st.local, 0, nil), time.Time{})
+	if remoteClosed || err != nil {
+		st.mux.remove(st)
+	}
+	return err
+}
+
+//Close 实现net.Conn，关闭读写两个方向，之后收到的数据会使流被重置
+func (st *Stream) Close() error {
+	st.lock.Lock()
+	st.localClosed = true
+	st.lock.Unlock()
+	notify(st.readNotify)
+	return st.CloseWrite()
+}
+
+//LocalAddr 实现net.Conn
+func (st *Stream) LocalAddr() net.Addr {
+	return Addr{addr: st.mux.session.LocalAddr(), id: st.id}
+}
+
+//RemoteAddr 实现net.Conn
+func (st *Stream) RemoteAddr() net.Addr {
+	return Addr{addr: st.mux.session.RemoteAddr(), id: st.id}
+}
+
+//SetDeadline 实现net.Conn
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+//SetReadDeadline 实现net.Conn
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.lock.Lock()
+	st.readDeadline = t
+	st.lock.Unlock()
+	notify(st.readNotify)
+	return nil
+}
+
+//SetWriteDeadline 实现net.Conn
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.lock.Lock()
+	st.writeDeadline = t
+	st.lock.Unlock()
+	notify(st.writeNotify)
+	return nil
+}
+
+//receive 收到对方数据，超出接收窗口视为协议错误
+func (st *Stream) receive(data []byte) error {
+	st.lock.Lock()
+	if st.localClosed {
+		st.lock.Unlock()
+		return StreamClosedError
+	}
+	if uint32(len(data)) > st.recvWindow {
+		st.lock.Unlock()
+		return WindowExceededError
+	}
+	st.recvWindow -= uint32(len(data))
+	st.readBuf.Write(data)
+	st.lock.Unlock()
+	notify(st.readNotify)
+	return nil
+}
+
+//addWindow 对方通告接收窗口增量
+func (st *Stream) addWindow(increment uint32) {
+	st.lock.Lock()
+	st.sendWindow += increment
+	st.lock.Unlock()
+	notify(st.writeNotify)
+}
+
+//remoteClose 收到对方的CLOSE
+func (st *Stream) remoteClose() {
+	st.lock.Lock()
+	st.remoteClosed = true
+	writeClosed := st.writeClosed
+	st.lock.Unlock()
+	notify(st.readNotify)
+	if writeClosed {
+		st.mux.remove(st)
+	}
+}
+
+//reset 流被重置或mux关闭，之后的读写均返回err
+func (st *Stream) reset(err error) {
+	st.lock.Lock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.lock.Unlock()
+	notify(st.readNotify)
+	notify(st.writeNotify)
+	st.mux.remove(st)
+}
+
+//notify 非阻塞地唤醒等待者
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+//wait 等待唤醒或超时
+func wait(ch chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
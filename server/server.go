@@ -18,6 +18,11 @@ type GottyServer struct {
 	handler    func(session *session.Session, p codec.Packet) //包处理函数
 	//编解码
 	codec codec.Codec
+
+	filters      []session.FilterFactory //每个session的过滤器
+	eventHandler session.EventHandler    //session事件处理函数
+	executor     session.Executor        //所有session共享的包处理执行器
+	eventLoops   *session.EventLoopGroup //不为nil时由事件循环驱动session
 }
 
 func NewGottyServer( //
@@ -39,6 +44,31 @@ func NewGottyServer( //
 	return server
 }
 
+//AddFilter 添加过滤器，对之后接入的session生效
+func (self *GottyServer) AddFilter(factory session.FilterFactory) {
+	self.filters = append(self.filters, factory)
+}
+
+//SetEventHandler 设置session事件处理函数
+func (self *GottyServer) SetEventHandler(h session.EventHandler) {
+	self.eventHandler = h
+}
+
+//SetExecutor 设置所有session共享的包处理执行器，对之后接入的session生效
+func (self *GottyServer) SetExecutor(e session.Executor) {
+	self.executor = e
+}
+
+//SetEventLoops 使用n个epoll事件循环驱动所有session，适合大量空闲连接，codec需实现codec.FrameDecoder，仅支持linux
+func (self *GottyServer) SetEventLoops(n int) error {
+	group, err := session.NewEventLoopGroup(n, self.config.ReadBufSize)
+	if err != nil {
+		return err
+	}
+	self.eventLoops = group
+	return nil
+}
+
 func (self *GottyServer) ListenAndServe() error {
 	tcpAddr, err := net.ResolveTCPAddr("tcp4", self.addr)
 	if nil != err {
@@ -69,8 +99,23 @@ func (self *GottyServer) serve(listener *StoppedListener) error {
 			// gottyClient := client.NewGottyClient(conn, self.codec, self.config, self.handler)
 			// gottyClient.Start()
 
-			s := session.NewSession(conn, self.codec, self.config, self.handler)
-			s.Start()
+			var s *session.Session
+			if self.eventLoops != nil {
+				s = session.NewPollSession(conn, self.codec, self.config, self.handler)
+			} else {
+				s = session.NewSession(conn, self.codec, self.config, self.handler)
+			}
+			for _, factory := range self.filters {
+				s.AddFilter(factory(s))
+			}
+			s.SetEventHandler(self.eventHandler)
+			s.SetExecutor(self.executor)
+			if self.eventLoops == nil {
+				s.Start()
+			} else if err := self.eventLoops.Register(s); err != nil {
+				log.Error("register session to event loop failed: %s", err)
+				s.Close()
+			}
 		}
 	}
 	return nil
@@ -79,5 +124,8 @@ func (self *GottyServer) serve(listener *StoppedListener) error {
 func (self *GottyServer) Shutdown() {
 	self.isShutdown = true
 	close(self.stopChan)
+	if self.eventLoops != nil {
+		self.eventLoops.Close()
+	}
 	log.Info("server shutdown")
 }
@@ -0,0 +1,79 @@
+package session
+
+import (
+	"github.com/sumory/gotty/codec"
+	log "github.com/sumory/log4go"
+	"net"
+)
+
+//maxWriteBatch 一次合并写出的最大包数
+const maxWriteBatch = 128
+
+//pendingWrite 一批中已编码、等待flush的包
+type pendingWrite struct {
+	packet codec.Packet
+	future *WriteFuture
+	err    error
+}
+
+//writeBatch 编码一批包并一次写出：codec支持VectorWriter时用writev直接写socket，
+//支持BufferedWriter时写入bufio后flush一次，否则退回每个包各自写出
+func (session *Session) writeBatch(batch []codec.Packet) {
+	vw, vectored := session.codec.(codec.VectorWriter)
+	bw, buffered := session.codec.(codec.BufferedWriter)
+
+	var bufs net.Buffers
+	writes := make([]pendingWrite, 0, len(batch))
+	for _, p := range batch {
+		if nil == p {
+			log.Warn("the packet from WriteChannel is nil")
+			continue
+		}
+		p, future := unwrapWrite(p)
+		w := pendingWrite{packet: p, future: future}
+
+		packets, err := session.filterWrite(p)
+		if err != nil {
+			log.Error("filter packet error", err)
+			w.err = err
+		}
+		for _, fp := range packets {
+			switch {
+			case vectored:
+				bufs, err = vw.AppendBuffers(bufs, fp)
+			case buffered:
+				err = bw.WriteBuffered(session.bWriter, fp)
+			default:
+				err = session.codec.Write(session.bWriter, fp)
+			}
+			if err != nil {
+				log.Error("写出包错误", err)
+				if w.err == nil {
+					w.err = err
+				}
+			}
+		}
+		writes = append(writes, w)
+	}
+
+	var flushErr error
+	switch {
+	case vectored:
+		if len(bufs) > 0 {
+			_, flushErr = bufs.WriteTo(session.conn)
+		}
+	case buffered:
+		flushErr = session.bWriter.Flush()
+	}
+	if flushErr != nil {
+		log.Error("flush error", flushErr)
+	}
+
+	for _, w := range writes {
+		if w.err == nil {
+			w.err = flushErr
+		}
+		session.writeDone(w.packet)
+		w.future.complete(w.err)
+	}
+}
@@ -0,0 +1,88 @@
+package session
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/config"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+//plainCodec 只暴露Codec接口，用于对比每个包各自flush的写出方式
+type plainCodec struct {
+	codec.Codec
+}
+
+func Test_BatchWriter(t *testing.T) {
+	convey.Convey("Batched packets should arrive intact and in order", t, func() {
+		for _, c := range []codec.Codec{
+			codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil),
+			plainCodec{codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)},
+		} {
+			s, peer := newStartedSession(config.NewDefaultGottyConfig(), c, func(s *Session, p codec.Packet) {}, nil)
+
+			var last *WriteFuture
+			for i := 0; i < 200; i++ {
+				p := newTestPacket(nil, []byte(strings.Repeat("d", i*10)))
+				p.Header.Sequence = uint32(i)
+				s.WriteContext(context.Background(), p)
+				if i == 199 {
+					last = s.WriteAsync(newTestPacket(nil, nil))
+				}
+			}
+			convey.So(last.Wait(), convey.ShouldBeNil)
+
+			lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+			r := bufio.NewReader(peer)
+			for i := 0; i < 200; i++ {
+				p, err := lbc.Read(r)
+				convey.So(err, convey.ShouldBeNil)
+				lbp := p.(codec.LengthBasedPacket)
+				if lbp.Header.Sequence != uint32(i) || len(lbp.Body.Data) != i*10 {
+					convey.So(lbp.Header.Sequence, convey.ShouldEqual, i)
+					convey.So(len(lbp.Body.Data), convey.ShouldEqual, i*10)
+				}
+			}
+			s.Close()
+			peer.Close()
+		}
+	})
+}
+
+//benchmarkSessionWrite 通过本地连接写出b.N个包，对端丢弃读到的数据
+func benchmarkSessionWrite(b *testing.B, c codec.Codec, size int) {
+	s, peer := newStartedSession(config.NewDefaultGottyConfig(), c, func(s *Session, p codec.Packet) {}, nil)
+	defer s.Close()
+	defer peer.Close()
+	go io.Copy(ioutil.Discard, peer)
+
+	p := newTestPacket(nil, []byte(strings.Repeat("x", size)))
+	b.SetBytes(int64(p.Len()))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.WriteContext(context.Background(), p)
+	}
+	s.WriteAsync(p).Wait()
+}
+
+func BenchmarkSessionWrite_PerPacketFlush_64B(b *testing.B) {
+	benchmarkSessionWrite(b, plainCodec{codec.NewLengthBasedCodec(binary.BigEndian, 1024*1024, nil, nil)}, 64)
+}
+
+func BenchmarkSessionWrite_Vectored_64B(b *testing.B) {
+	benchmarkSessionWrite(b, codec.NewLengthBasedCodec(binary.BigEndian, 1024*1024, nil, nil), 64)
+}
+
+func BenchmarkSessionWrite_PerPacketFlush_16K(b *testing.B) {
+	benchmarkSessionWrite(b, plainCodec{codec.NewLengthBasedCodec(binary.BigEndian, 1024*1024, nil, nil)}, 16*1024)
+}
+
+func BenchmarkSessionWrite_Vectored_16K(b *testing.B) {
+	benchmarkSessionWrite(b, codec.NewLengthBasedCodec(binary.BigEndian, 1024*1024, nil, nil), 16*1024)
+}
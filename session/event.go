@@ -0,0 +1,42 @@
+package session
+
+import (
+	"github.com/sumory/gotty/codec"
+)
+
+//EventType session事件类型
+type EventType int
+
+const (
+	EventPacketRejected     EventType = iota + 1 //读入的包被过滤器拒绝
+	EventPacketDropped                           //执行器繁忙，包被丢弃
+	EventReadPaused                              //待处理的包超过读高水位，暂停读取
+	EventReadResumed                             //待处理的包降到读低水位，恢复读取
+	EventWritabilityChanged                      //写队列越过写水位，可通过IsWritable获取当前状态
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPacketRejected:
+		return "PacketRejected"
+	case EventPacketDropped:
+		return "PacketDropped"
+	case EventReadPaused:
+		return "ReadPaused"
+	case EventReadResumed:
+		return "ReadResumed"
+	case EventWritabilityChanged:
+		return "WritabilityChanged"
+	}
+	return "Unknown"
+}
+
+//Event session上发生的事件
+type Event struct {
+	Type   EventType
+	Packet codec.Packet //相关的包，可能为nil
+	Err    error        //事件原因
+}
+
+//EventHandler 事件处理函数
+type EventHandler func(session *Session, e Event)
@@ -0,0 +1,12 @@
+package session
+
+import (
+	"errors"
+)
+
+// Errors
+var (
+	EventLoopClosedError      = errors.New("Event loop group closed")
+	EventLoopUnsupportedError = errors.New("Event loop mode is only supported on linux")
+	NotPollableError          = errors.New("Session codec does not implement FrameDecoder")
+)
@@ -0,0 +1,602 @@
+//go:build linux
+
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/config"
+	log "github.com/sumory/log4go"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	epollRead  = syscall.EPOLLIN | syscall.EPOLLRDHUP
+	epollWrite = syscall.EPOLLOUT
+
+	maxIovec = 1024 //一次writev的最大段数
+)
+
+//EventLoopGroup 基于epoll的事件循环组，少量循环协程负责大量连接的读写
+//与每个session三个协程加两个bufio缓冲相比，空闲连接几乎不占内存：
+//读缓冲由每个循环共享，只有收到不完整的包时才为连接分配累积缓冲，写出的数据在socket不可写时才排队
+//包的解码使用codec.FrameDecoder，处理函数、过滤器、执行器和事件与普通session相同
+type EventLoopGroup struct {
+	loops  []*eventLoop
+	next   uint32
+	closed int32
+}
+
+//eventLoop 一个epoll实例及其循环协程
+type eventLoop struct {
+	epfd   int
+	wakeR  int //关闭时写入wakeW唤醒epoll_wait
+	wakeW  int
+	lock   sync.RWMutex
+	conns  map[int]*Session
+	buf    []byte //读缓冲，第一次有数据到达时分配，由本循环的所有连接共享
+	bufLen int
+	done   chan struct{}
+}
+
+//NewEventLoopGroup 新建n个事件循环，readBufSize为每个循环共享的读缓冲大小
+func NewEventLoopGroup(n, readBufSize int) (*EventLoopGroup, error) {
+	g := &EventLoopGroup{}
+	for i := 0; i < n; i++ {
+		l, err := newEventLoop(readBufSize)
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		g.loops = append(g.loops, l)
+		go l.run()
+	}
+	return g, nil
+}
+
+func newEventLoop(readBufSize int) (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	var wake [2]int
+	if err = syscall.Pipe2(wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(wake[0])}
+	if err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, wake[0], &ev); err != nil {
+		syscall.Close(epfd)
+		syscall.Close(wake[0])
+		syscall.Close(wake[1])
+		return nil, err
+	}
+	return &eventLoop{
+		epfd:   epfd,
+		wakeR:  wake[0],
+		wakeW:  wake[1],
+		conns:  make(map[int]*Session),
+		bufLen: readBufSize,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+//NewPollSession 创建由事件循环驱动的session，不分配bufio和读写channel，设置好过滤器等之后调用EventLoopGroup.Register
+//codec需实现codec.FrameDecoder
+func NewPollSession(conn *net.TCPConn, sessionCodec codec.Codec, config *config.GottyConfig, handler handlerFunc) *Session {
+	conn.SetKeepAlive(true)
+	conn.SetKeepAlivePeriod(config.IdleTime * 2)
+	conn.SetNoDelay(true)
+
+	return &Session{
+		id:         atomic.AddUint64(&GlobalSessionID, 1),
+		conn:       conn,
+		remoteAddr: conn.RemoteAddr().String(),
+		localAddr:  conn.LocalAddr().String(),
+
+		closeChan: make(chan struct{}),
+		attrs:     make(map[string]interface{}),
+		config:    config,
+		lastTime:  time.Now(),
+
+		codec:   sessionCodec,
+		handler: handler,
+		poll:    &pollConn{fd: -1},
+	}
+}
+
+//Register 将session轮流加入各事件循环，开始收发包
+func (g *EventLoopGroup) Register(session *Session) error {
+	if atomic.LoadInt32(&g.closed) == 1 {
+		return EventLoopClosedError
+	}
+	fd, err := connFd(session.conn)
+	if err != nil {
+		return err
+	}
+	decoder, ok := session.codec.(codec.FrameDecoder)
+	if !ok || session.poll == nil {
+		return NotPollableError
+	}
+
+	l := g.loops[atomic.AddUint32(&g.next, 1)%uint32(len(g.loops))]
+	pc := session.poll
+	pc.fd = fd
+	pc.loop = l
+	pc.decoder = codec.NewStreamDecoder(decoder)
+	pc.events = epollRead
+
+	l.lock.Lock()
+	l.conns[fd] = session
+	l.lock.Unlock()
+	ev := syscall.EpollEvent{Events: pc.events, Fd: int32(fd)}
+	if err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		l.lock.Lock()
+		delete(l.conns, fd)
+		l.lock.Unlock()
+		return err
+	}
+	log.Info("session registered to event loop: %s <-> %s", session.localAddr, session.remoteAddr)
+	return nil
+}
+
+//NumConns 各事件循环负责的连接总数
+func (g *EventLoopGroup) NumConns() int {
+	n := 0
+	for _, l := range g.loops {
+		l.lock.RLock()
+		n += len(l.conns)
+		l.lock.RUnlock()
+	}
+	return n
+}
+
+//Close 停止所有事件循环并关闭其中的session
+func (g *EventLoopGroup) Close() {
+	if !atomic.CompareAndSwapInt32(&g.closed, 0, 1) {
+		return
+	}
+	for _, l := range g.loops {
+		syscall.Write(l.wakeW, []byte{0})
+		<-l.done
+	}
+}
+
+//connFd 取得连接的文件描述符，连接仍由net.TCPConn持有，事件循环直接对fd做非阻塞读写
+func connFd(conn *net.TCPConn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	if err := raw.Control(func(s uintptr) { fd = int(s) }); err != nil {
+		return -1, err
+	}
+	return fd, nil
+}
+
+func (l *eventLoop) run() {
+	defer close(l.done)
+	events := make([]syscall.EpollEvent, 256)
+	for {
+		n, err := syscall.EpollWait(l.epfd, events, -1)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			log.Error("epoll wait error: %s", err)
+			break
+		}
+		stop := false
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == l.wakeR {
+				stop = true
+				continue
+			}
+			l.lock.RLock()
+			session := l.conns[fd]
+			l.lock.RUnlock()
+			if session != nil {
+				l.handle(session, events[i].Events)
+			}
+		}
+		if stop {
+			break
+		}
+	}
+	l.shutdown()
+}
+
+//shutdown 关闭本循环的所有session和epoll实例
+func (l *eventLoop) shutdown() {
+	l.lock.RLock()
+	sessions := make([]*Session, 0, len(l.conns))
+	for _, s := range l.conns {
+		sessions = append(sessions, s)
+	}
+	l.lock.RUnlock()
+	for _, s := range sessions {
+		s.Close()
+	}
+	syscall.Close(l.epfd)
+	syscall.Close(l.wakeR)
+	syscall.Close(l.wakeW)
+}
+
+func (l *eventLoop) handle(session *Session, events uint32) {
+	if events&(syscall.EPOLLIN|syscall.EPOLLRDHUP) != 0 {
+		if !l.read(session) {
+			session.Close()
+			return
+		}
+	}
+	if events&epollWrite != 0 {
+		if err := session.poll.flush(session); err != nil {
+			log.Warn("event loop write failed, remoteAddr: %s, err: %s", session.remoteAddr, err)
+			session.Close()
+			return
+		}
+	}
+	if events&(syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+		session.Close()
+	}
+}
+
+//read 读取一次并分发其中完整的包，连接关闭或出错时返回false
+func (l *eventLoop) read(session *Session) bool {
+	defer func() {
+		if err := recover(); nil != err {
+			log.Warn("session read packet failed, localAddr: %s, remoteAddr: %s, err: %s",
+				session.localAddr, session.remoteAddr, err)
+			session.Close()
+		}
+	}()
+
+	if l.buf == nil {
+		l.buf = make([]byte, l.bufLen)
+	}
+	pc := session.poll
+	n, err := pc.read(l.buf)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return true
+	}
+	if err != nil || n == 0 {
+		return false
+	}
+	session.lastTime = time.Now()
+
+	packets, err := pc.decoder.Feed(l.buf[:n])
+	for _, packet := range packets {
+		p, ferr := session.filterRead(packet)
+		if ferr != nil {
+			log.Warn("packet rejected, remoteAddr: %s, err: %s", session.remoteAddr, ferr)
+			session.fireEvent(Event{Type: EventPacketRejected, Packet: packet, Err: ferr})
+			continue
+		}
+		if p == nil {
+			continue
+		}
+		session.readHold(p)
+		session.Dispatch(p)
+	}
+	if err != nil {
+		log.Error("read packet error, %s", err)
+		return false
+	}
+
+	//超过读水位时停止监听可读，恢复后重新监听
+	session.readLock.Lock()
+	paused, resume := session.readPaused, session.readResume
+	session.readLock.Unlock()
+	if paused {
+		pc.pauseRead(session, resume)
+	}
+	return true
+}
+
+//pollConn 事件循环模式下session的连接状态
+type pollConn struct {
+	fd      int
+	loop    *eventLoop
+	decoder *codec.StreamDecoder
+
+	lock    sync.Mutex
+	events  uint32      //当前监听的事件
+	out     net.Buffers //待写出的数据
+	pending []pollWrite //待写出的包，按写入顺序
+	queued  uint64      //累计进入out的字节数
+	written uint64      //累计写出的字节数
+	closed  bool
+}
+
+//pollNotify 持有pc.lock时产生的通知，解锁后再触发
+//事件处理函数和等待future的协程可能立即回写，在锁内触发会死锁
+type pollNotify struct {
+	changes int //可写状态改变的次数
+	futures []*WriteFuture
+	errs    []error
+}
+
+//complete 记录future的结果，future为nil时忽略
+func (n *pollNotify) complete(future *WriteFuture, err error) {
+	if future != nil {
+		n.futures = append(n.futures, future)
+		n.errs = append(n.errs, err)
+	}
+}
+
+//writable 记录可写状态是否改变
+func (n *pollNotify) writable(changed bool) {
+	if changed {
+		n.changes++
+	}
+}
+
+//fire 触发记录的事件并完成future，调用时不能持有pc.lock
+func (n *pollNotify) fire(session *Session) {
+	for i := 0; i < n.changes; i++ {
+		session.fireEvent(Event{Type: EventWritabilityChanged})
+	}
+	for i, future := range n.futures {
+		future.complete(n.errs[i])
+	}
+}
+
+//pollWrite 写出到end字节时完成的包
+type pollWrite struct {
+	packet codec.Packet
+	future *WriteFuture
+	end    uint64
+}
+
+//encoderPool 不支持VectorWriter的codec编码时使用的缓冲
+var encoderPool = sync.Pool{
+	New: func() interface{} {
+		e := &pollEncoder{}
+		e.w = bufio.NewWriterSize(&e.buf, 4096)
+		return e
+	},
+}
+
+type pollEncoder struct {
+	buf bytes.Buffer
+	w   *bufio.Writer
+}
+
+//write 编码后直接尝试写出，socket不可写时排队并监听可写事件，socket出错时关闭session
+func (pc *pollConn) write(session *Session, p codec.Packet, future *WriteFuture) error {
+	pc.lock.Lock()
+	if pc.closed || pc.loop == nil {
+		pc.lock.Unlock()
+		future.complete(SessionClosedError)
+		return SessionClosedError
+	}
+
+	packets, err := session.filterWrite(p)
+	if err != nil {
+		pc.lock.Unlock()
+		future.complete(err)
+		return err
+	}
+	var notify pollNotify
+	notify.writable(session.holdWrite(p))
+	for _, fp := range packets {
+		if err = pc.encode(session, fp); err != nil {
+			break
+		}
+	}
+	w := pollWrite{packet: p, future: future, end: pc.queued}
+	if err != nil {
+		//已编码的部分仍会写出，该包的结果为编码错误
+		w.future = nil
+		notify.complete(future, err)
+	}
+	pc.pending = append(pc.pending, w)
+
+	var flushErr error
+	if pc.events&epollWrite == 0 {
+		flushErr = pc.flushLocked(session, &notify)
+	}
+	pc.lock.Unlock()
+	notify.fire(session)
+
+	if flushErr != nil {
+		log.Warn("event loop write failed, remoteAddr: %s, err: %s", session.remoteAddr, flushErr)
+		session.Close()
+		return flushErr
+	}
+	return err
+}
+
+func (pc *pollConn) encode(session *Session, p codec.Packet) error {
+	start := len(pc.out)
+	if vw, ok := session.codec.(codec.VectorWriter); ok {
+		bufs, err := vw.AppendBuffers(pc.out, p)
+		if err != nil {
+			return err
+		}
+		pc.out = bufs
+	} else {
+		e := encoderPool.Get().(*pollEncoder)
+		defer encoderPool.Put(e)
+		e.buf.Reset()
+		e.w.Reset(&e.buf)
+		var err error
+		if bw, ok := session.codec.(codec.BufferedWriter); ok {
+			err = bw.WriteBuffered(e.w, p)
+		} else {
+			err = session.codec.Write(e.w, p)
+		}
+		if err == nil {
+			err = e.w.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		pc.out = append(pc.out, append([]byte(nil), e.buf.Bytes()...))
+	}
+	for _, b := range pc.out[start:] {
+		pc.queued += uint64(len(b))
+	}
+	return nil
+}
+
+//read 非阻塞地读取一次，session关闭后fd可能已被复用，需在pc.lock内确认未关闭再读
+func (pc *pollConn) read(buf []byte) (int, error) {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	if pc.closed {
+		return 0, syscall.EBADF
+	}
+	return syscall.Read(pc.fd, buf)
+}
+
+//flush 循环收到可写事件时继续写出
+func (pc *pollConn) flush(session *Session) error {
+	var notify pollNotify
+	pc.lock.Lock()
+	var err error
+	if !pc.closed {
+		err = pc.flushLocked(session, &notify)
+	}
+	pc.lock.Unlock()
+	notify.fire(session)
+	return err
+}
+
+//flushLocked 非阻塞地写出排队的数据，写完后取消可写监听，未写完时开始监听，需持有pc.lock
+func (pc *pollConn) flushLocked(session *Session, notify *pollNotify) error {
+	for len(pc.out) > 0 {
+		n, err := writev(pc.fd, pc.out)
+		pc.written += uint64(n)
+		//n为0时也要去掉开头的空段，否则全是空段时不会前进
+		pc.consume(n)
+		if err == syscall.EAGAIN {
+			break
+		}
+		if err != nil && err != syscall.EINTR {
+			pc.complete(session, notify)
+			return err
+		}
+	}
+	pc.complete(session, notify)
+
+	if len(pc.out) > 0 {
+		pc.updateEvents(epollWrite, 0)
+	} else {
+		pc.out = nil
+		pc.updateEvents(0, epollWrite)
+	}
+	return nil
+}
+
+//consume 从out的开头去掉已写出的n个字节及其后的空段
+func (pc *pollConn) consume(n int) {
+	for len(pc.out) > 0 {
+		if n < len(pc.out[0]) {
+			pc.out[0] = pc.out[0][n:]
+			return
+		}
+		n -= len(pc.out[0])
+		pc.out[0] = nil
+		pc.out = pc.out[1:]
+	}
+}
+
+//complete 完成已全部写出的包，需持有pc.lock，通知记录在notify中
+func (pc *pollConn) complete(session *Session, notify *pollNotify) {
+	i := 0
+	for ; i < len(pc.pending) && pc.pending[i].end <= pc.written; i++ {
+		notify.writable(session.releaseWrite(pc.pending[i].packet))
+		notify.complete(pc.pending[i].future, nil)
+		pc.pending[i] = pollWrite{}
+	}
+	pc.pending = pc.pending[i:]
+	if len(pc.pending) == 0 {
+		pc.pending = nil
+	}
+}
+
+//updateEvents 增加add、去掉remove后修改监听的事件，需持有pc.lock
+func (pc *pollConn) updateEvents(add, remove uint32) {
+	events := (pc.events | add) &^ remove
+	if pc.closed || events == pc.events {
+		return
+	}
+	pc.events = events
+	ev := syscall.EpollEvent{Events: events, Fd: int32(pc.fd)}
+	syscall.EpollCtl(pc.loop.epfd, syscall.EPOLL_CTL_MOD, pc.fd, &ev)
+}
+
+//pauseRead 读水位暂停期间不再监听可读，恢复后重新监听
+func (pc *pollConn) pauseRead(session *Session, resume chan struct{}) {
+	pc.lock.Lock()
+	pc.updateEvents(0, epollRead)
+	pc.lock.Unlock()
+
+	go func() {
+		select {
+		case <-resume:
+			pc.lock.Lock()
+			pc.updateEvents(epollRead, 0)
+			pc.lock.Unlock()
+		case <-session.closeChan:
+		}
+	}()
+}
+
+//close 从事件循环中移除，令未写出的包失败
+func (pc *pollConn) close(session *Session) {
+	var notify pollNotify
+	pc.lock.Lock()
+	defer notify.fire(session)
+	defer pc.lock.Unlock()
+	if pc.closed {
+		return
+	}
+	pc.closed = true
+	if pc.loop != nil {
+		syscall.EpollCtl(pc.loop.epfd, syscall.EPOLL_CTL_DEL, pc.fd, nil)
+		pc.loop.lock.Lock()
+		delete(pc.loop.conns, pc.fd)
+		pc.loop.lock.Unlock()
+	}
+	for _, w := range pc.pending {
+		notify.writable(session.releaseWrite(w.packet))
+		notify.complete(w.future, SessionClosedError)
+	}
+	pc.pending = nil
+	pc.out = nil
+}
+
+//writev 将bufs一次写出，返回写出的字节数
+func writev(fd int, bufs net.Buffers) (int, error) {
+	n := len(bufs)
+	if n > maxIovec {
+		n = maxIovec
+	}
+	iovs := make([]syscall.Iovec, 0, n)
+	for _, b := range bufs[:n] {
+		if len(b) == 0 {
+			continue
+		}
+		iov := syscall.Iovec{Base: &b[0]}
+		iov.SetLen(len(b))
+		iovs = append(iovs, iov)
+	}
+	if len(iovs) == 0 {
+		return 0, nil
+	}
+	r, _, errno := syscall.Syscall(syscall.SYS_WRITEV, uintptr(fd), uintptr(unsafe.Pointer(&iovs[0])), uintptr(len(iovs)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
@@ -0,0 +1,403 @@
+//go:build linux
+
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/config"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+//pollTestServer 接受连接并注册到事件循环的本地服务
+type pollTestServer struct {
+	listener *net.TCPListener
+	group    *EventLoopGroup
+	sessions chan *Session
+}
+
+func newPollTestServer(loops int, cfg *config.GottyConfig, c codec.Codec, handler handlerFunc) *pollTestServer {
+	return newPollTestServerWithSetup(loops, cfg, c, handler, nil)
+}
+
+//newPollTestServerWithSetup 注册前先调用setup设置session
+func newPollTestServerWithSetup(loops int, cfg *config.GottyConfig, c codec.Codec, handler handlerFunc, setup func(s *Session)) *pollTestServer {
+	listener, _ := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	group, err := NewEventLoopGroup(loops, cfg.ReadBufSize)
+	if err != nil {
+		panic(err)
+	}
+	srv := &pollTestServer{listener: listener, group: group, sessions: make(chan *Session, 16)}
+	go func() {
+		for {
+			conn, err := listener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			s := NewPollSession(conn, c, cfg, handler)
+			if setup != nil {
+				setup(s)
+			}
+			if err := group.Register(s); err != nil {
+				s.Close()
+				continue
+			}
+			select {
+			case srv.sessions <- s:
+			default:
+			}
+		}
+	}()
+	return srv
+}
+
+func (srv *pollTestServer) dial() *net.TCPConn {
+	conn, _ := net.DialTCP("tcp4", nil, srv.listener.Addr().(*net.TCPAddr))
+	return conn
+}
+
+func (srv *pollTestServer) close() {
+	srv.listener.Close()
+	srv.group.Close()
+}
+
+func echoHandler(s *Session, p codec.Packet) {
+	s.Write(p)
+}
+
+func Test_EventLoopEcho(t *testing.T) {
+	convey.Convey("Event loop sessions should echo packets over many connections", t, func() {
+		cfg := config.NewDefaultGottyConfig()
+		lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		srv := newPollTestServer(2, cfg, lbc, echoHandler)
+		defer srv.close()
+
+		for i := 0; i < 8; i++ {
+			conn := srv.dial()
+			w := bufio.NewWriter(conn)
+			r := bufio.NewReader(conn)
+			for j := 0; j < 10; j++ {
+				lbc.Write(w, newTestPacket([]byte("x"), []byte{byte(i), byte(j)}))
+			}
+			//每个包由单独的goroutine处理，回包顺序不确定
+			seen := make(map[byte]bool)
+			for j := 0; j < 10; j++ {
+				p, err := lbc.Read(r)
+				convey.So(err, convey.ShouldBeNil)
+				data := p.(codec.LengthBasedPacket).Body.Data
+				convey.So(data[0], convey.ShouldEqual, byte(i))
+				seen[data[1]] = true
+			}
+			convey.So(len(seen), convey.ShouldEqual, 10)
+			conn.Close()
+		}
+	})
+
+	convey.Convey("Frames split into single bytes should be reassembled", t, func() {
+		cfg := config.NewDefaultGottyConfig()
+		lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		srv := newPollTestServer(1, cfg, lbc, echoHandler)
+		defer srv.close()
+
+		conn := srv.dial()
+		defer conn.Close()
+		var frames bytes.Buffer
+		w := bufio.NewWriter(&frames)
+		lbc.Write(w, newTestPacket(nil, []byte("first")))
+		lbc.Write(w, newTestPacket(nil, []byte("second")))
+		for _, c := range frames.Bytes() {
+			conn.Write([]byte{c})
+			time.Sleep(time.Millisecond)
+		}
+
+		r := bufio.NewReader(conn)
+		for _, want := range []string{"first", "second"} {
+			p, err := lbc.Read(r)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(string(p.(codec.LengthBasedPacket).Body.Data), convey.ShouldEqual, want)
+		}
+	})
+}
+
+func Test_EventLoopBackpressure(t *testing.T) {
+	convey.Convey("Writes should queue while the socket is full and complete once the peer reads", t, func() {
+		cfg := config.NewDefaultGottyConfig()
+		lbc := codec.NewLengthBasedCodec(binary.BigEndian, 8*1024*1024, nil, nil)
+		srv := newPollTestServer(1, cfg, lbc, echoHandler)
+		defer srv.close()
+
+		conn := srv.dial()
+		defer conn.Close()
+		conn.SetReadBuffer(64 * 1024)
+		s := <-srv.sessions
+
+		body := bytes.Repeat([]byte("0123456789abcdef"), 256*1024)
+		future := s.WriteAsync(newTestPacket(nil, body))
+		select {
+		case <-future.Done():
+			t.Fatal("4MB write should not fit in the socket buffers")
+		case <-time.After(50 * time.Millisecond):
+		}
+		convey.So(s.QueuedWriteBytes(), convey.ShouldBeGreaterThan, 0)
+
+		p, err := lbc.Read(bufio.NewReader(conn))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.(codec.LengthBasedPacket).Body.Data, convey.ShouldResemble, body)
+		convey.So(future.Wait(), convey.ShouldBeNil)
+		convey.So(s.QueuedWriteBytes(), convey.ShouldEqual, 0)
+	})
+}
+
+func Test_EventLoopReentrant(t *testing.T) {
+	convey.Convey("Writing from a writability event handler should not deadlock", t, func() {
+		cfg := config.NewDefaultGottyConfig()
+		cfg.WriteHighWatermarkBytes = 1
+		cfg.WriteLowWatermarkBytes = 0
+		lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		var writes int32
+		srv := newPollTestServerWithSetup(1, cfg, lbc, echoHandler, func(s *Session) {
+			s.SetEventHandler(func(s *Session, e Event) {
+				if e.Type == EventWritabilityChanged && atomic.AddInt32(&writes, 1) <= 3 {
+					s.Write(newTestPacket(nil, []byte("event")))
+				}
+			})
+		})
+		defer srv.close()
+
+		conn := srv.dial()
+		defer conn.Close()
+		w := bufio.NewWriter(conn)
+		lbc.Write(w, newTestPacket(nil, []byte("ping")))
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		r := bufio.NewReader(conn)
+		for _, want := range []string{"ping", "event", "event", "event"} {
+			p, err := lbc.Read(r)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(string(p.(codec.LengthBasedPacket).Body.Data), convey.ShouldEqual, want)
+		}
+	})
+
+	convey.Convey("A full dispatch queue should drop packets instead of blocking the loop", t, func() {
+		cfg := config.NewDefaultGottyConfig()
+		cfg.DispatcherQueueSize = make(chan int, 1)
+		lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		release := make(chan struct{})
+		dropped := make(chan struct{}, 1)
+		srv := newPollTestServerWithSetup(1, cfg, lbc, func(s *Session, p codec.Packet) {
+			if string(p.(codec.LengthBasedPacket).Body.Data) == "block" {
+				<-release
+			}
+			s.Write(p)
+		}, func(s *Session) {
+			s.SetEventHandler(func(s *Session, e Event) {
+				if e.Type == EventPacketDropped {
+					dropped <- struct{}{}
+				}
+			})
+		})
+		defer srv.close()
+
+		conn := srv.dial()
+		defer conn.Close()
+		w := bufio.NewWriter(conn)
+		lbc.Write(w, newTestPacket(nil, []byte("block")))
+		lbc.Write(w, newTestPacket(nil, []byte("dropped")))
+		select {
+		case <-dropped:
+		case <-time.After(time.Second):
+			t.Fatal("dispatch blocked the event loop")
+		}
+		close(release)
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		r := bufio.NewReader(conn)
+		p, err := lbc.Read(r)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(string(p.(codec.LengthBasedPacket).Body.Data), convey.ShouldEqual, "block")
+
+		//队列空出后恢复处理
+		for len(cfg.DispatcherQueueSize) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		lbc.Write(w, newTestPacket(nil, []byte("after")))
+		p, err = lbc.Read(r)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(string(p.(codec.LengthBasedPacket).Body.Data), convey.ShouldEqual, "after")
+	})
+}
+
+//emptyFrameCodec 把body为空的包编码为0字节
+type emptyFrameCodec struct {
+	*codec.LengthBasedCodec
+}
+
+func (ec emptyFrameCodec) AppendBuffers(bufs net.Buffers, p codec.Packet) (net.Buffers, error) {
+	if len(p.(codec.LengthBasedPacket).Body.Data) == 0 {
+		return append(bufs, nil), nil
+	}
+	return ec.LengthBasedCodec.AppendBuffers(bufs, p)
+}
+
+func Test_EventLoopEmptyFrame(t *testing.T) {
+	convey.Convey("A frame encoded to zero bytes should complete without stalling the loop", t, func() {
+		cfg := config.NewDefaultGottyConfig()
+		lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		srv := newPollTestServer(1, cfg, emptyFrameCodec{lbc}, echoHandler)
+		defer srv.close()
+
+		conn := srv.dial()
+		defer conn.Close()
+		s := <-srv.sessions
+
+		future := s.WriteAsync(newTestPacket(nil, nil))
+		select {
+		case <-future.Done():
+			convey.So(future.Wait(), convey.ShouldBeNil)
+		case <-time.After(time.Second):
+			t.Fatal("writing an empty frame did not complete")
+		}
+		convey.So(s.QueuedWriteBytes(), convey.ShouldEqual, 0)
+
+		w := bufio.NewWriter(conn)
+		lbc.Write(w, newTestPacket(nil, []byte("after")))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		p, err := lbc.Read(bufio.NewReader(conn))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(string(p.(codec.LengthBasedPacket).Body.Data), convey.ShouldEqual, "after")
+	})
+}
+
+func Test_EventLoopClose(t *testing.T) {
+	convey.Convey("Sessions should close when the peer disconnects", t, func() {
+		cfg := config.NewDefaultGottyConfig()
+		lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		srv := newPollTestServer(1, cfg, lbc, echoHandler)
+		defer srv.close()
+
+		conn := srv.dial()
+		s := <-srv.sessions
+		convey.So(srv.group.NumConns(), convey.ShouldEqual, 1)
+		conn.Close()
+
+		select {
+		case <-s.Done():
+		case <-time.After(time.Second):
+			t.Fatal("session was not closed")
+		}
+		convey.So(srv.group.NumConns(), convey.ShouldEqual, 0)
+		convey.So(s.Write(newTestPacket(nil, nil)), convey.ShouldEqual, SessionClosedError)
+	})
+
+	convey.Convey("Closing the group should close its sessions and reject new ones", t, func() {
+		cfg := config.NewDefaultGottyConfig()
+		lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		srv := newPollTestServer(2, cfg, lbc, echoHandler)
+
+		conn := srv.dial()
+		defer conn.Close()
+		s := <-srv.sessions
+		srv.close()
+		convey.So(s.Closed(), convey.ShouldBeTrue)
+
+		_, err := conn.Read(make([]byte, 1))
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(srv.group.Register(s), convey.ShouldEqual, EventLoopClosedError)
+	})
+}
+
+//raiseFileLimit 将打开文件数的软限制提高到硬限制，返回可用的连接数
+func raiseFileLimit(want int) int {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0
+	}
+	rlim.Cur = rlim.Max
+	syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rlim)
+	syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim)
+	//每条连接在同一进程内占用两端两个fd
+	if limit := (int(rlim.Cur) - 200) / 2; limit < want {
+		return limit
+	}
+	return want
+}
+
+//BenchmarkEventLoop_100kConns 两端都由事件循环驱动，建立10万条本地连接后在所有连接上轮流ping-pong
+//连接数受打开文件数限制，不足时按限制减少并打印实际数量
+func BenchmarkEventLoop_100kConns(b *testing.B) {
+	n := raiseFileLimit(100000)
+	if n < 100000 {
+		b.Logf("RLIMIT_NOFILE allows only %d connections", n)
+	}
+	if n <= 0 {
+		b.Skip("no file descriptors available")
+	}
+
+	cfg := config.NewDefaultGottyConfig()
+	lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+	srv := newPollTestServer(runtime.GOMAXPROCS(0), cfg, lbc, echoHandler)
+	defer srv.close()
+
+	var wg sync.WaitGroup
+	var pongs int64
+	clients, _ := NewEventLoopGroup(runtime.GOMAXPROCS(0), cfg.ReadBufSize)
+	defer clients.Close()
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	sessions := make([]*Session, 0, n)
+	for i := 0; i < n; i++ {
+		conn := srv.dial()
+		if conn == nil {
+			b.Fatalf("dial failed after %d connections", i)
+		}
+		s := NewPollSession(conn, lbc, cfg, func(s *Session, p codec.Packet) {
+			atomic.AddInt64(&pongs, 1)
+			wg.Done()
+		})
+		if err := clients.Register(s); err != nil {
+			b.Fatal(err)
+		}
+		sessions = append(sessions, s)
+	}
+	for srv.group.NumConns() < n {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	runtime.GC()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	memPerConn := float64(after.HeapInuse+after.StackInuse-before.HeapInuse-before.StackInuse) / float64(n)
+
+	ping := newTestPacket(nil, []byte("ping"))
+	b.ResetTimer()
+	for i := 0; i < b.N; {
+		batch := n
+		if b.N-i < batch {
+			batch = b.N - i
+		}
+		wg.Add(batch)
+		for j := 0; j < batch; j++ {
+			sessions[(i+j)%n].Write(ping)
+		}
+		wg.Wait()
+		i += batch
+	}
+	b.StopTimer()
+	b.ReportMetric(memPerConn, "B/conn")
+	b.ReportMetric(float64(n), "conns")
+	if int(pongs) != b.N {
+		b.Fatalf("got %d pongs, want %d", pongs, b.N)
+	}
+}
@@ -0,0 +1,44 @@
+//go:build !linux
+
+package session
+
+import (
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/config"
+	"net"
+)
+
+//EventLoopGroup 事件循环模式只支持linux，其他平台上NewEventLoopGroup返回EventLoopUnsupportedError
+type EventLoopGroup struct{}
+
+//NewEventLoopGroup 非linux平台不支持
+func NewEventLoopGroup(n, readBufSize int) (*EventLoopGroup, error) {
+	return nil, EventLoopUnsupportedError
+}
+
+//NewPollSession 非linux平台退回普通session
+func NewPollSession(conn *net.TCPConn, sessionCodec codec.Codec, config *config.GottyConfig, handler handlerFunc) *Session {
+	return NewSession(conn, sessionCodec, config, handler)
+}
+
+//Register 非linux平台不支持
+func (g *EventLoopGroup) Register(session *Session) error {
+	return EventLoopUnsupportedError
+}
+
+//NumConns 非linux平台始终为0
+func (g *EventLoopGroup) NumConns() int {
+	return 0
+}
+
+//Close 非linux平台无需处理
+func (g *EventLoopGroup) Close() {}
+
+type pollConn struct{}
+
+func (pc *pollConn) write(session *Session, p codec.Packet, future *WriteFuture) error {
+	future.complete(EventLoopUnsupportedError)
+	return EventLoopUnsupportedError
+}
+
+func (pc *pollConn) close(session *Session) {}
@@ -0,0 +1,248 @@
+package session
+
+import (
+	"errors"
+	"github.com/sumory/gotty/codec"
+	log "github.com/sumory/log4go"
+	"sync"
+	"sync/atomic"
+)
+
+// Errors
+var (
+	ExecutorBusyError   = errors.New("Executor queue is full")
+	ExecutorClosedError = errors.New("Executor shut down")
+)
+
+//Executor 执行包处理函数，取代每个包一个goroutine的分发方式
+//可在多个session间共享，通过GottyServer.SetExecutor或GottyClient.SetExecutor设置
+type Executor interface {
+	//Execute 提交一个包，由执行器调用session的handler处理，返回error表示该包被拒绝
+	Execute(session *Session, p codec.Packet) error
+	//Shutdown 停止执行器，已排队的包处理完后返回
+	Shutdown()
+}
+
+//TryExecutor 可以不阻塞地提交的执行器
+//事件循环模式下分发包的是循环协程，阻塞会停住同一循环的所有连接，此时改用TryExecute
+type TryExecutor interface {
+	//TryExecute 同Execute，但队列满时不阻塞，RejectBlock按RejectDropNewest处理
+	TryExecute(session *Session, p codec.Packet) error
+}
+
+//RejectPolicy 队列满时的处理策略
+type RejectPolicy int
+
+const (
+	RejectBlock      RejectPolicy = iota //阻塞分发协程，直到队列有空位
+	RejectDropNewest                     //丢弃新提交的包
+	RejectDropOldest                     //丢弃队列中最早的包
+	RejectReplyBusy                      //丢弃新提交的包，并回复OperationBusy
+)
+
+func (p RejectPolicy) String() string {
+	switch p {
+	case RejectBlock:
+		return "Block"
+	case RejectDropNewest:
+		return "DropNewest"
+	case RejectDropOldest:
+		return "DropOldest"
+	case RejectReplyBusy:
+		return "ReplyBusy"
+	}
+	return "Unknown"
+}
+
+//ExecutorStats 执行器统计
+type ExecutorStats struct {
+	Workers       int    //worker个数
+	Busy          int    //正在处理的worker个数
+	QueueDepth    int    //排队的包个数
+	QueueCapacity int    //队列容量
+	Completed     uint64 //已处理的包个数
+	Rejected      uint64 //被拒绝或丢弃的包个数
+}
+
+//Utilization worker利用率，0~1
+func (s ExecutorStats) Utilization() float64 {
+	if s.Workers == 0 {
+		return 0
+	}
+	return float64(s.Busy) / float64(s.Workers)
+}
+
+type task struct {
+	session *Session
+	packet  codec.Packet
+}
+
+//WorkerPool 固定worker个数、有界队列的执行器
+type WorkerPool struct {
+	workers int
+	policy  RejectPolicy
+	queue   chan task
+
+	busy      int32
+	completed uint64
+	rejected  uint64
+
+	lock      sync.RWMutex //保护closed与向queue发送
+	closed    bool
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+//NewWorkerPool 新建执行器并启动worker
+func NewWorkerPool(workers, queueSize int, policy RejectPolicy) *WorkerPool {
+	wp := &WorkerPool{
+		workers: workers,
+		policy:  policy,
+		queue:   make(chan task, queueSize),
+		closeCh: make(chan struct{}),
+	}
+	wp.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go wp.work()
+	}
+	return wp
+}
+
+func (wp *WorkerPool) work() {
+	defer wp.wg.Done()
+	for t := range wp.queue {
+		atomic.AddInt32(&wp.busy, 1)
+		wp.run(t)
+		atomic.AddInt32(&wp.busy, -1)
+		atomic.AddUint64(&wp.completed, 1)
+	}
+}
+
+func (wp *WorkerPool) run(t task) {
+	defer func() {
+		if err := recover(); nil != err {
+			log.Error("handle packet panic, remoteAddr: %s, err: %s", t.session.remoteAddr, err)
+		}
+	}()
+	t.session.handle(t.packet)
+}
+
+//Execute 实现Executor
+func (wp *WorkerPool) Execute(session *Session, p codec.Packet) error {
+	return wp.submit(session, p, wp.policy)
+}
+
+//TryExecute 实现TryExecutor
+func (wp *WorkerPool) TryExecute(session *Session, p codec.Packet) error {
+	policy := wp.policy
+	if policy == RejectBlock {
+		policy = RejectDropNewest
+	}
+	return wp.submit(session, p, policy)
+}
+
+//submit 提交一个包，队列满时按policy处理
+func (wp *WorkerPool) submit(session *Session, p codec.Packet, policy RejectPolicy) error {
+	t := task{session: session, packet: p}
+
+	wp.lock.RLock()
+	defer wp.lock.RUnlock()
+	if wp.closed {
+		return ExecutorClosedError
+	}
+
+	select {
+	case wp.queue <- t:
+		return nil
+	default:
+	}
+
+	switch policy {
+	case RejectBlock:
+		select {
+		case wp.queue <- t:
+			return nil
+		case <-session.Done():
+			return ExecutorBusyError
+		case <-wp.closeCh:
+			return ExecutorClosedError
+		}
+	case RejectDropOldest:
+		for {
+			select {
+			case wp.queue <- t:
+				return nil
+			case old := <-wp.queue:
+				wp.reject(old, false)
+				old.session.readDone(old.packet)
+			}
+		}
+	case RejectReplyBusy:
+		wp.reject(t, true)
+		return ExecutorBusyError
+	}
+	wp.reject(t, false)
+	return ExecutorBusyError
+}
+
+//reject 记录被拒绝的包，需要时回复繁忙
+func (wp *WorkerPool) reject(t task, reply bool) {
+	atomic.AddUint64(&wp.rejected, 1)
+	if reply {
+		if busy, ok := busyReply(t.packet); ok {
+			t.session.Write(busy)
+		}
+	}
+	t.session.fireEvent(Event{Type: EventPacketDropped, Packet: t.packet, Err: ExecutorBusyError})
+}
+
+//Shutdown 实现Executor
+func (wp *WorkerPool) Shutdown() {
+	wp.closeOnce.Do(func() {
+		//先通知阻塞在Execute中的提交方退出，释放读锁
+		close(wp.closeCh)
+		wp.lock.Lock()
+		wp.closed = true
+		close(wp.queue)
+		wp.lock.Unlock()
+		wp.wg.Wait()
+	})
+}
+
+//Stats 统计信息
+func (wp *WorkerPool) Stats() ExecutorStats {
+	return ExecutorStats{
+		Workers:       wp.workers,
+		Busy:          int(atomic.LoadInt32(&wp.busy)),
+		QueueDepth:    len(wp.queue),
+		QueueCapacity: cap(wp.queue),
+		Completed:     atomic.LoadUint64(&wp.completed),
+		Rejected:      atomic.LoadUint64(&wp.rejected),
+	}
+}
+
+//busyReply 构造繁忙响应，沿用请求的Sequence以便对方匹配
+func busyReply(p codec.Packet) (codec.Packet, bool) {
+	lbp, err := asLengthBasedPacket(p)
+	if err != nil {
+		return nil, false
+	}
+	header := &codec.LengthBasedPacketHeader{
+		Sequence:  lbp.Header.Sequence,
+		Operation: codec.OperationBusy,
+		Version:   lbp.Header.Version,
+	}
+	body := &codec.LengthBasedPacketBody{}
+	meta := &codec.LengthBasedPacketMeta{
+		TotalLen:  uint32(8 + header.Len() + body.Len()),
+		HeaderLen: uint32(header.Len()),
+	}
+	return codec.LengthBasedPacket{Meta: meta, Header: header, Body: body}, true
+}
+
+//IsBusy 是否为对端的繁忙响应，请求方收到后应退避重试
+func IsBusy(p codec.Packet) bool {
+	lbp, err := asLengthBasedPacket(p)
+	return err == nil && lbp.Header.Operation == codec.OperationBusy
+}
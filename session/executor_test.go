@@ -0,0 +1,343 @@
+package session
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/config"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//newTestSession 建立一条本地连接并返回未Start的session
+func newTestSession(handler handlerFunc) *Session {
+	listener, _ := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	defer listener.Close()
+
+	go func() {
+		conn, _ := listener.AcceptTCP()
+		if conn != nil {
+			defer conn.Close()
+			conn.Read(make([]byte, 1))
+		}
+	}()
+	conn, _ := net.DialTCP("tcp4", nil, listener.Addr().(*net.TCPAddr))
+	return NewSession(conn, nil, config.NewDefaultGottyConfig(), handler)
+}
+
+//blockingHandler 处理函数阻塞到release被关闭
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) handlerFunc {
+	return func(s *Session, p codec.Packet) {
+		started <- struct{}{}
+		<-release
+	}
+}
+
+func Test_WorkerPool(t *testing.T) {
+	convey.Convey("Worker pool should bound concurrency", t, func() {
+		var running, peak, handled int32
+		s := newTestSession(func(s *Session, p codec.Packet) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				old := atomic.LoadInt32(&peak)
+				if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&handled, 1)
+		})
+		defer s.Close()
+
+		wp := NewWorkerPool(4, 16, RejectBlock)
+		for i := 0; i < 100; i++ {
+			convey.So(wp.Execute(s, newTestPacket(nil, nil)), convey.ShouldBeNil)
+		}
+		wp.Shutdown()
+		convey.So(atomic.LoadInt32(&handled), convey.ShouldEqual, 100)
+		convey.So(atomic.LoadInt32(&peak), convey.ShouldBeLessThanOrEqualTo, 4)
+		convey.So(wp.Stats().Completed, convey.ShouldEqual, 100)
+		convey.So(wp.Execute(s, newTestPacket(nil, nil)), convey.ShouldEqual, ExecutorClosedError)
+	})
+
+	convey.Convey("Full queue should follow the reject policy", t, func() {
+		for _, policy := range []RejectPolicy{RejectDropNewest, RejectDropOldest, RejectReplyBusy} {
+			started := make(chan struct{}, 3)
+			release := make(chan struct{})
+			var handled []uint32
+			var lock sync.Mutex
+			handler := blockingHandler(started, release)
+			s := newTestSession(func(s *Session, p codec.Packet) {
+				handler(s, p)
+				lock.Lock()
+				handled = append(handled, p.(codec.LengthBasedPacket).Header.Sequence)
+				lock.Unlock()
+			})
+			var dropped int32
+			s.SetEventHandler(func(s *Session, e Event) {
+				if e.Type == EventPacketDropped {
+					atomic.AddInt32(&dropped, 1)
+				}
+			})
+
+			wp := NewWorkerPool(1, 1, policy)
+			submit := func(seq uint32) error {
+				p := newTestPacket(nil, nil)
+				p.Header.Sequence = seq
+				return wp.Execute(s, p)
+			}
+			convey.So(submit(1), convey.ShouldBeNil)
+			<-started
+			convey.So(submit(2), convey.ShouldBeNil)
+
+			stats := wp.Stats()
+			convey.So(stats.Busy, convey.ShouldEqual, 1)
+			convey.So(stats.QueueDepth, convey.ShouldEqual, 1)
+			convey.So(stats.Utilization(), convey.ShouldEqual, 1)
+
+			err := submit(3)
+			if policy == RejectDropOldest {
+				convey.So(err, convey.ShouldBeNil)
+			} else {
+				convey.So(err, convey.ShouldEqual, ExecutorBusyError)
+			}
+			if policy == RejectReplyBusy {
+				busy := (<-s.WriteChannel).(codec.LengthBasedPacket)
+				convey.So(busy.Header.Operation, convey.ShouldEqual, codec.OperationBusy)
+				convey.So(busy.Header.Sequence, convey.ShouldEqual, 3)
+			}
+
+			close(release)
+			wp.Shutdown()
+			s.Close()
+			convey.So(wp.Stats().Rejected, convey.ShouldEqual, 1)
+			convey.So(atomic.LoadInt32(&dropped), convey.ShouldEqual, 1)
+			if policy == RejectDropOldest {
+				convey.So(handled, convey.ShouldResemble, []uint32{1, 3})
+			} else {
+				convey.So(handled, convey.ShouldResemble, []uint32{1, 2})
+			}
+		}
+	})
+
+	convey.Convey("Shutdown should release blocked submitters", t, func() {
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		s := newTestSession(blockingHandler(started, release))
+		defer s.Close()
+
+		wp := NewWorkerPool(1, 1, RejectBlock)
+		wp.Execute(s, newTestPacket(nil, nil))
+		<-started
+		wp.Execute(s, newTestPacket(nil, nil))
+
+		result := make(chan error, 1)
+		go func() {
+			result <- wp.Execute(s, newTestPacket(nil, nil))
+		}()
+		time.Sleep(20 * time.Millisecond)
+		go wp.Shutdown()
+		convey.So(<-result, convey.ShouldEqual, ExecutorClosedError)
+		close(release)
+	})
+}
+
+func Test_OrderedExecutor(t *testing.T) {
+	convey.Convey("Packets of one session should be handled in order", t, func() {
+		var lock sync.Mutex
+		handled := make(map[uint64][]uint32)
+		handler := func(s *Session, p codec.Packet) {
+			lock.Lock()
+			handled[s.ID()] = append(handled[s.ID()], p.(codec.LengthBasedPacket).Header.Sequence)
+			lock.Unlock()
+		}
+		sessions := []*Session{newTestSession(handler), newTestSession(handler), newTestSession(handler)}
+
+		oe := NewOrderedExecutor(4, 16, RejectBlock, SessionKey)
+		for seq := uint32(0); seq < 200; seq++ {
+			for _, s := range sessions {
+				p := newTestPacket(nil, nil)
+				p.Header.Sequence = seq
+				convey.So(oe.Execute(s, p), convey.ShouldBeNil)
+			}
+		}
+		oe.Shutdown()
+
+		convey.So(oe.Stats().Completed, convey.ShouldEqual, 600)
+		for _, s := range sessions {
+			s.Close()
+			seqs := handled[s.ID()]
+			convey.So(len(seqs), convey.ShouldEqual, 200)
+			for i, seq := range seqs {
+				if seq != uint32(i) {
+					convey.So(seq, convey.ShouldEqual, i)
+				}
+			}
+		}
+	})
+
+	convey.Convey("Different keys should run in parallel", t, func() {
+		started := make(chan string, 2)
+		release := make(chan struct{})
+		s := newTestSession(func(s *Session, p codec.Packet) {
+			user := string(p.(codec.LengthBasedPacket).Header.Extra)
+			started <- user
+			if user == "alice" {
+				<-release
+			}
+		})
+		defer s.Close()
+
+		oe := NewOrderedExecutor(2, 16, RejectBlock, ExtraKey(0, 5))
+		key := ExtraKey(0, 5)
+		shardOf := func(user string) int {
+			return oe.shard(key(s, newTestPacket([]byte(user), nil)))
+		}
+		//找一个与alice不在同一分片的用户
+		other := ""
+		for _, user := range []string{"bob00", "carol", "dave0", "erin0", "frank"} {
+			if shardOf(user) != shardOf("alice") {
+				other = user
+				break
+			}
+		}
+		convey.So(other, convey.ShouldNotEqual, "")
+
+		oe.Execute(s, newTestPacket([]byte("alice"), nil))
+		convey.So(<-started, convey.ShouldEqual, "alice")
+		oe.Execute(s, newTestPacket([]byte("alice"), nil))
+		oe.Execute(s, newTestPacket([]byte(other), nil))
+		convey.So(<-started, convey.ShouldEqual, other)
+		convey.So(oe.Stats().QueueDepth, convey.ShouldEqual, 1)
+
+		close(release)
+		oe.Shutdown()
+		convey.So(<-started, convey.ShouldEqual, "alice")
+	})
+}
+
+func Test_FairExecutor(t *testing.T) {
+	newTenantSession := func(tenant string, handler handlerFunc) *Session {
+		s := newTestSession(handler)
+		s.Set("tenant", tenant)
+		return s
+	}
+
+	convey.Convey("Tenants should be scheduled by weight", t, func() {
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		var lock sync.Mutex
+		var order []string
+		var gate sync.Once
+		handler := func(s *Session, p codec.Packet) {
+			gate.Do(func() {
+				started <- struct{}{}
+				<-release
+			})
+			lock.Lock()
+			order = append(order, s.Get("tenant").(string))
+			lock.Unlock()
+		}
+		a, b := newTenantSession("a", handler), newTenantSession("b", handler)
+		defer a.Close()
+		defer b.Close()
+
+		fe := NewFairExecutor(1, 100, 0, AttrTenant("tenant"))
+		fe.SetWeight("a", 3)
+		fe.Execute(a, newTestPacket(nil, nil))
+		<-started
+		for i := 0; i < 8; i++ {
+			fe.Execute(a, newTestPacket(nil, nil))
+			fe.Execute(b, newTestPacket(nil, nil))
+		}
+		convey.So(fe.Stats().QueueDepth, convey.ShouldEqual, 16)
+		close(release)
+		fe.Shutdown()
+
+		convey.So(order[1:9], convey.ShouldResemble, []string{"a", "a", "a", "b", "a", "a", "a", "b"})
+		convey.So(fe.Stats().Completed, convey.ShouldEqual, 17)
+	})
+
+	convey.Convey("Non-positive weights should not starve other tenants", t, func() {
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		var lock sync.Mutex
+		var order []string
+		var gate sync.Once
+		handler := func(s *Session, p codec.Packet) {
+			gate.Do(func() {
+				started <- struct{}{}
+				<-release
+			})
+			lock.Lock()
+			order = append(order, s.Get("tenant").(string))
+			lock.Unlock()
+		}
+		a, b, c := newTenantSession("a", handler), newTenantSession("b", handler), newTenantSession("c", handler)
+		defer a.Close()
+		defer b.Close()
+		defer c.Close()
+
+		fe := NewFairExecutor(1, 100, 0, AttrTenant("tenant"))
+		fe.SetWeight("a", 0)
+		fe.SetWeight("b", -2)
+		fe.Execute(c, newTestPacket(nil, nil))
+		<-started
+		for i := 0; i < 4; i++ {
+			fe.Execute(a, newTestPacket(nil, nil))
+			fe.Execute(b, newTestPacket(nil, nil))
+			fe.Execute(c, newTestPacket(nil, nil))
+		}
+		close(release)
+		fe.Shutdown()
+
+		convey.So(fe.Stats().Completed, convey.ShouldEqual, 13)
+		//按权重1轮转，每轮每个租户一个包
+		for round := 0; round < 4; round++ {
+			convey.So(order[1+round*3:4+round*3], convey.ShouldContain, "a")
+			convey.So(order[1+round*3:4+round*3], convey.ShouldContain, "b")
+			convey.So(order[1+round*3:4+round*3], convey.ShouldContain, "c")
+		}
+	})
+
+	convey.Convey("Per-tenant caps should protect other tenants", t, func() {
+		started := make(chan string, 4)
+		release := make(chan struct{})
+		handler := func(s *Session, p codec.Packet) {
+			tenant := s.Get("tenant").(string)
+			started <- tenant
+			if tenant == "chatty" {
+				<-release
+			}
+		}
+		chatty, quiet := newTenantSession("chatty", handler), newTenantSession("quiet", handler)
+		defer chatty.Close()
+		defer quiet.Close()
+
+		fe := NewFairExecutor(4, 2, 1, AttrTenant("tenant"))
+		convey.So(fe.Execute(chatty, newTestPacket(nil, nil)), convey.ShouldBeNil)
+		convey.So(<-started, convey.ShouldEqual, "chatty")
+		for i := 0; i < 2; i++ {
+			convey.So(fe.Execute(chatty, newTestPacket(nil, nil)), convey.ShouldBeNil)
+		}
+		convey.So(fe.Execute(chatty, newTestPacket(nil, nil)), convey.ShouldEqual, ExecutorBusyError)
+		convey.So(IsBusy(<-chatty.WriteChannel), convey.ShouldBeTrue)
+
+		convey.So(fe.Execute(quiet, newTestPacket(nil, nil)), convey.ShouldBeNil)
+		convey.So(<-started, convey.ShouldEqual, "quiet")
+
+		stats := fe.TenantStats()
+		convey.So(stats[0].Tenant, convey.ShouldEqual, "chatty")
+		convey.So(stats[0].QueueDepth, convey.ShouldEqual, 2)
+		convey.So(stats[0].Inflight, convey.ShouldEqual, 1)
+		convey.So(stats[0].Rejected, convey.ShouldEqual, 1)
+
+		close(release)
+		fe.Shutdown()
+		convey.So(fe.Stats().Completed, convey.ShouldEqual, 4)
+		convey.So(len(fe.TenantStats()), convey.ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,281 @@
+package session
+
+import (
+	"fmt"
+	"github.com/sumory/gotty/codec"
+	log "github.com/sumory/log4go"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+//TenantFunc 返回包所属的租户，公平调度在租户之间进行
+type TenantFunc func(session *Session, p codec.Packet) string
+
+//SessionTenant 每个session作为一个租户
+func SessionTenant(session *Session, p codec.Packet) string {
+	return strconv.FormatUint(session.ID(), 10)
+}
+
+//AttrTenant 以session的name属性作为租户，如认证后保存的租户id，未设置时退回以session为租户
+func AttrTenant(name string) TenantFunc {
+	return func(session *Session, p codec.Packet) string {
+		if v := session.Get(name); v != nil {
+			return fmt.Sprint(v)
+		}
+		return SessionTenant(session, p)
+	}
+}
+
+//TenantStats 单个租户的统计
+type TenantStats struct {
+	Tenant     string
+	Weight     int
+	QueueDepth int    //排队的包个数
+	Inflight   int    //正在处理的包个数
+	Completed  uint64 //已处理的包个数
+	Rejected   uint64 //因队列满被拒绝的包个数
+}
+
+type tenantQueue struct {
+	name     string
+	weight   int
+	deficit  int //本轮剩余可调度的包个数
+	queue    []task
+	inflight int
+	ready    bool //是否在调度环中
+	pinned   bool //通过SetWeight设置，空闲时不回收
+
+	completed uint64
+	rejected  uint64
+}
+
+//FairExecutor 租户间加权公平调度的执行器
+//采用按包计数的差额轮转(DRR)：每轮每个租户最多调度weight个包，
+//单个租户的排队上限和在途上限防止其占满队列或worker，队列满时回复OperationBusy
+type FairExecutor struct {
+	workers     int
+	maxQueue    int //每个租户的排队上限
+	maxInflight int //每个租户的在途上限，0表示不限制
+	tenant      TenantFunc
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	tenants map[string]*tenantQueue
+	ring    []*tenantQueue //有待调度包的租户
+	cursor  int
+	pending int //所有租户排队的包个数
+	busy    int
+	closed  bool
+	wg      sync.WaitGroup
+
+	completed uint64
+	rejected  uint64
+}
+
+//NewFairExecutor 新建公平调度执行器
+func NewFairExecutor(workers, maxQueue, maxInflight int, tenant TenantFunc) *FairExecutor {
+	fe := &FairExecutor{
+		workers:     workers,
+		maxQueue:    maxQueue,
+		maxInflight: maxInflight,
+		tenant:      tenant,
+		tenants:     make(map[string]*tenantQueue),
+	}
+	fe.cond = sync.NewCond(&fe.lock)
+	fe.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go fe.work()
+	}
+	return fe
+}
+
+//SetWeight 设置租户权重，未设置的租户权重为1，小于1的权重按1处理
+func (fe *FairExecutor) SetWeight(tenant string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	fe.lock.Lock()
+	defer fe.lock.Unlock()
+	tq := fe.tenantQueue(tenant)
+	tq.weight = weight
+	tq.pinned = true
+}
+
+func (fe *FairExecutor) tenantQueue(name string) *tenantQueue {
+	tq := fe.tenants[name]
+	if tq == nil {
+		tq = &tenantQueue{name: name, weight: 1}
+		fe.tenants[name] = tq
+	}
+	return tq
+}
+
+//Execute 实现Executor
+func (fe *FairExecutor) Execute(session *Session, p codec.Packet) error {
+	name := fe.tenant(session, p)
+
+	fe.lock.Lock()
+	if fe.closed {
+		fe.lock.Unlock()
+		return ExecutorClosedError
+	}
+	tq := fe.tenantQueue(name)
+	if len(tq.queue) >= fe.maxQueue {
+		tq.rejected++
+		fe.rejected++
+		fe.lock.Unlock()
+
+		if busy, ok := busyReply(p); ok {
+			session.Write(busy)
+		}
+		session.fireEvent(Event{Type: EventPacketDropped, Packet: p, Err: ExecutorBusyError})
+		return ExecutorBusyError
+	}
+	tq.queue = append(tq.queue, task{session: session, packet: p})
+	fe.pending++
+	if !tq.ready {
+		tq.ready = true
+		fe.ring = append(fe.ring, tq)
+	}
+	fe.lock.Unlock()
+	fe.cond.Signal()
+	return nil
+}
+
+//next 按DRR取出下一个可处理的包，需持有锁
+func (fe *FairExecutor) next() (task, *tenantQueue, bool) {
+	for checked := 0; checked < len(fe.ring); {
+		if fe.cursor >= len(fe.ring) {
+			fe.cursor = 0
+		}
+		tq := fe.ring[fe.cursor]
+		if len(tq.queue) == 0 {
+			fe.unready(fe.cursor)
+			continue
+		}
+		if fe.maxInflight > 0 && tq.inflight >= fe.maxInflight {
+			fe.cursor++
+			checked++
+			continue
+		}
+
+		if tq.deficit <= 0 {
+			tq.deficit = tq.weight
+		}
+		t := tq.queue[0]
+		tq.queue[0] = task{}
+		tq.queue = tq.queue[1:]
+		tq.deficit--
+		fe.pending--
+		if len(tq.queue) == 0 {
+			fe.unready(fe.cursor)
+		} else if tq.deficit == 0 {
+			fe.cursor++
+		}
+		return t, tq, true
+	}
+	return task{}, nil, false
+}
+
+//unready 将没有排队包的租户移出调度环
+func (fe *FairExecutor) unready(i int) {
+	tq := fe.ring[i]
+	tq.ready = false
+	tq.deficit = 0
+	copy(fe.ring[i:], fe.ring[i+1:])
+	fe.ring[len(fe.ring)-1] = nil
+	fe.ring = fe.ring[:len(fe.ring)-1]
+}
+
+func (fe *FairExecutor) work() {
+	defer fe.wg.Done()
+	fe.lock.Lock()
+	for {
+		t, tq, ok := fe.next()
+		if !ok {
+			if fe.closed && fe.pending == 0 {
+				fe.lock.Unlock()
+				return
+			}
+			fe.cond.Wait()
+			continue
+		}
+		tq.inflight++
+		fe.busy++
+		fe.lock.Unlock()
+
+		fe.run(t)
+
+		fe.lock.Lock()
+		tq.inflight--
+		tq.completed++
+		fe.completed++
+		fe.busy--
+		if !tq.ready && tq.inflight == 0 && !tq.pinned {
+			delete(fe.tenants, tq.name)
+		}
+		//租户在途数下降后，其排队的包可能重新可调度
+		fe.cond.Signal()
+	}
+}
+
+func (fe *FairExecutor) run(t task) {
+	defer func() {
+		if err := recover(); nil != err {
+			log.Error("handle packet panic, remoteAddr: %s, err: %s", t.session.remoteAddr, err)
+		}
+	}()
+	t.session.handle(t.packet)
+}
+
+//Shutdown 实现Executor，已排队的包处理完后返回
+func (fe *FairExecutor) Shutdown() {
+	fe.lock.Lock()
+	fe.closed = true
+	fe.lock.Unlock()
+	fe.cond.Broadcast()
+	fe.wg.Wait()
+}
+
+//Stats 汇总统计
+func (fe *FairExecutor) Stats() ExecutorStats {
+	fe.lock.Lock()
+	defer fe.lock.Unlock()
+	return ExecutorStats{
+		Workers:    fe.workers,
+		Busy:       fe.busy,
+		QueueDepth: fe.pending,
+		Completed:  fe.completed,
+		Rejected:   fe.rejected,
+	}
+}
+
+//TenantStats 各租户的统计，按排队数和在途数降序，排在前面的租户占用最多
+//空闲的租户会被回收，只统计当前活跃和设置过权重的租户
+func (fe *FairExecutor) TenantStats() []TenantStats {
+	fe.lock.Lock()
+	stats := make([]TenantStats, 0, len(fe.tenants))
+	for _, tq := range fe.tenants {
+		stats = append(stats, TenantStats{
+			Tenant:     tq.name,
+			Weight:     tq.weight,
+			QueueDepth: len(tq.queue),
+			Inflight:   tq.inflight,
+			Completed:  tq.completed,
+			Rejected:   tq.rejected,
+		})
+	}
+	fe.lock.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].QueueDepth != stats[j].QueueDepth {
+			return stats[i].QueueDepth > stats[j].QueueDepth
+		}
+		if stats[i].Inflight != stats[j].Inflight {
+			return stats[i].Inflight > stats[j].Inflight
+		}
+		return stats[i].Tenant < stats[j].Tenant
+	})
+	return stats
+}
@@ -0,0 +1,49 @@
+package session
+
+import (
+	"github.com/sumory/gotty/codec"
+)
+
+//Filter 包过滤器，挂在session的读写路径上
+//读入时按添加顺序调用OnRead，写出时按添加的逆序调用OnWrite
+//每个session持有独立的Filter实例，可在其中保存连接级别的状态
+type Filter interface {
+	//OnRead 包被分发前调用，返回nil包表示该包已被消费，返回error表示拒绝该包
+	OnRead(session *Session, p codec.Packet) (codec.Packet, error)
+	//OnWrite 包被编码写出前调用，可将一个包转换为多个包依次写出
+	OnWrite(session *Session, p codec.Packet) ([]codec.Packet, error)
+}
+
+//FilterFactory 为每个新建的session创建Filter
+type FilterFactory func(session *Session) Filter
+
+//AddFilter 添加过滤器，需在Start之前调用
+func (session *Session) AddFilter(f Filter) {
+	session.filters = append(session.filters, f)
+}
+
+func (session *Session) filterRead(p codec.Packet) (codec.Packet, error) {
+	var err error
+	for _, f := range session.filters {
+		if p, err = f.OnRead(session, p); err != nil || p == nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (session *Session) filterWrite(p codec.Packet) ([]codec.Packet, error) {
+	packets := []codec.Packet{p}
+	for i := len(session.filters) - 1; i >= 0; i-- {
+		var next []codec.Packet
+		for _, p := range packets {
+			out, err := session.filters[i].OnWrite(session, p)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, out...)
+		}
+		packets = next
+	}
+	return packets, nil
+}
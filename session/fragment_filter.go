@@ -0,0 +1,271 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/sumory/gotty/codec"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//分片头放在Header.Extra开头: msgId(4) + index(4) + count(4) + 原始operation(2)
+	//第0个分片在分片头之后携带原始的Header.Extra
+	fragmentHeaderLen = 4 + 4 + 4 + 2
+	packetFixedLen    = 8 + 4 + 2 + 2 //meta + sequence + operation + version
+
+	//未完成消息和每个分片的固定开销，与数据一起计入maxPending，
+	//避免大量空分片在超时之前无限占用内存
+	reassemblyOverhead = 128
+	fragmentOverhead   = 32
+)
+
+// Errors
+var (
+	InvalidFragmentError      = errors.New("Invalid fragment")
+	MessageTooLargeError      = errors.New("Reassembled message too large")
+	ReassemblyBufferFullError = errors.New("Reassembly buffer is full")
+	ReassemblyTimeoutError    = errors.New("Reassembly timeout")
+)
+
+//FragmentFilter 将超过单帧上限的LengthBasedPacket拆分为多个分片写出，读入时重组
+//不同消息的分片可以交错到达，按msgId分别重组
+type FragmentFilter struct {
+	maxFrameSize   int           //单帧最大长度，超过则分片，应不大于codec的maxSize
+	maxMessageSize int           //重组后消息的最大长度
+	maxPending     int           //所有未完成消息占用的内存上限，含固定开销
+	timeout        time.Duration //未完成消息的超时时间
+
+	msgId        uint32 //发送方消息id
+	pending      map[uint32]*reassembly
+	pendingBytes int
+	lastSweep    time.Time
+}
+
+//reassembly 一条正在重组的消息
+type reassembly struct {
+	count     uint32
+	received  uint32
+	chunks    map[uint32][]byte
+	size      int //已收到的数据长度
+	cost      int //计入pendingBytes的长度，含固定开销
+	deadline  time.Time
+	discarded bool //已因超限被丢弃，继续吞掉剩余分片
+	header    codec.LengthBasedPacketHeader
+}
+
+//NewFragmentFilterFactory 创建分片过滤器工厂
+func NewFragmentFilterFactory(maxFrameSize, maxMessageSize, maxPending int, timeout time.Duration) FilterFactory {
+	return func(session *Session) Filter {
+		return NewFragmentFilter(maxFrameSize, maxMessageSize, maxPending, timeout)
+	}
+}
+
+//NewFragmentFilter 新建分片过滤器
+func NewFragmentFilter(maxFrameSize, maxMessageSize, maxPending int, timeout time.Duration) *FragmentFilter {
+	return &FragmentFilter{
+		maxFrameSize:   maxFrameSize,
+		maxMessageSize: maxMessageSize,
+		maxPending:     maxPending,
+		timeout:        timeout,
+		pending:        make(map[uint32]*reassembly),
+		lastSweep:      time.Now(),
+	}
+}
+
+//OnWrite 大包拆分为分片，分片body直接引用原始body不做拷贝
+func (ff *FragmentFilter) OnWrite(session *Session, p codec.Packet) ([]codec.Packet, error) {
+	lbp, err := asLengthBasedPacket(p)
+	if err != nil {
+		return nil, err
+	}
+	if int(lbp.Meta.TotalLen) <= ff.maxFrameSize {
+		return []codec.Packet{p}, nil
+	}
+	if int(lbp.Meta.TotalLen) > ff.maxMessageSize {
+		return nil, MessageTooLargeError
+	}
+
+	chunkSize := ff.maxFrameSize - packetFixedLen - fragmentHeaderLen - len(lbp.Header.Extra)
+	if chunkSize <= 0 {
+		return nil, codec.HeaderTooLargeError
+	}
+	data := lbp.Body.Data
+	count := (len(data) + chunkSize - 1) / chunkSize
+	msgId := atomic.AddUint32(&ff.msgId, 1)
+
+	fragments := make([]codec.Packet, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		extraLen := fragmentHeaderLen
+		if i == 0 {
+			extraLen += len(lbp.Header.Extra)
+		}
+		extra := make([]byte, extraLen)
+		binary.BigEndian.PutUint32(extra[0:4], msgId)
+		binary.BigEndian.PutUint32(extra[4:8], uint32(i))
+		binary.BigEndian.PutUint32(extra[8:12], uint32(count))
+		binary.BigEndian.PutUint16(extra[12:14], lbp.Header.Operation)
+		if i == 0 {
+			copy(extra[fragmentHeaderLen:], lbp.Header.Extra)
+		}
+
+		header := &codec.LengthBasedPacketHeader{
+			Sequence:  lbp.Header.Sequence,
+			Operation: codec.OperationFragment,
+			Version:   lbp.Header.Version,
+			Extra:     extra,
+		}
+		body := &codec.LengthBasedPacketBody{
+			Data: data[i*chunkSize : end],
+		}
+		meta := &codec.LengthBasedPacketMeta{
+			TotalLen:  uint32(8 + header.Len() + body.Len()),
+			HeaderLen: uint32(header.Len()),
+		}
+		fragments = append(fragments, codec.LengthBasedPacket{Meta: meta, Header: header, Body: body})
+	}
+	return fragments, nil
+}
+
+//OnRead 收集分片，消息完整后返回重组的包
+func (ff *FragmentFilter) OnRead(session *Session, p codec.Packet) (codec.Packet, error) {
+	lbp, err := asLengthBasedPacket(p)
+	if err != nil || lbp.Header.Operation != codec.OperationFragment {
+		return p, nil
+	}
+
+	now := time.Now()
+	ff.sweep(session, now)
+
+	extra := lbp.Header.Extra
+	if len(extra) < fragmentHeaderLen {
+		return nil, InvalidFragmentError
+	}
+	msgId := binary.BigEndian.Uint32(extra[0:4])
+	index := binary.BigEndian.Uint32(extra[4:8])
+	count := binary.BigEndian.Uint32(extra[8:12])
+	if count == 0 || index >= count || int64(count) > int64(ff.maxMessageSize) {
+		return nil, InvalidFragmentError
+	}
+
+	r, ok := ff.pending[msgId]
+	if !ok {
+		r = &reassembly{
+			count:    count,
+			chunks:   make(map[uint32][]byte),
+			deadline: now.Add(ff.timeout),
+		}
+		ff.pending[msgId] = r
+		ff.charge(r, reassemblyOverhead)
+	}
+	if r.count != count {
+		return nil, InvalidFragmentError
+	}
+	if _, dup := r.chunks[index]; dup {
+		return nil, InvalidFragmentError
+	}
+	r.received++
+	ff.charge(r, fragmentOverhead)
+
+	if r.discarded {
+		r.chunks[index] = nil
+		if r.received == r.count {
+			ff.remove(msgId, r)
+		} else if ff.pendingBytes > ff.maxPending {
+			ff.remove(msgId, r)
+			return nil, ReassemblyBufferFullError
+		}
+		return nil, nil
+	}
+
+	r.chunks[index] = lbp.Body.Data
+	r.size += len(lbp.Body.Data)
+	ff.charge(r, len(lbp.Body.Data))
+	if index == 0 {
+		r.header = codec.LengthBasedPacketHeader{
+			Sequence:  lbp.Header.Sequence,
+			Operation: binary.BigEndian.Uint16(extra[12:14]),
+			Version:   lbp.Header.Version,
+			Extra:     extra[fragmentHeaderLen:],
+		}
+		r.size += len(r.header.Extra)
+		ff.charge(r, len(r.header.Extra))
+	}
+
+	if packetFixedLen+r.size > ff.maxMessageSize {
+		ff.discard(msgId, r)
+		return nil, MessageTooLargeError
+	}
+	if ff.pendingBytes > ff.maxPending {
+		ff.discard(msgId, r)
+		return nil, ReassemblyBufferFullError
+	}
+	if r.received < r.count {
+		return nil, nil
+	}
+
+	ff.remove(msgId, r)
+	data := make([]byte, 0, r.size-len(r.header.Extra))
+	for i := uint32(0); i < r.count; i++ {
+		data = append(data, r.chunks[i]...)
+	}
+
+	header := r.header
+	body := &codec.LengthBasedPacketBody{
+		Data: data,
+	}
+	meta := &codec.LengthBasedPacketMeta{
+		TotalLen:  uint32(8 + header.Len() + body.Len()),
+		HeaderLen: uint32(header.Len()),
+	}
+	return codec.LengthBasedPacket{Meta: meta, Header: &header, Body: body}, nil
+}
+
+//charge 将n个字节计入消息及所有未完成消息占用的内存
+func (ff *FragmentFilter) charge(r *reassembly, n int) {
+	r.cost += n
+	ff.pendingBytes += n
+}
+
+//remove 移除消息并释放它占用的内存
+func (ff *FragmentFilter) remove(msgId uint32, r *reassembly) {
+	delete(ff.pending, msgId)
+	ff.pendingBytes -= r.cost
+}
+
+//discard 丢弃消息已收到的数据，剩余分片到达时直接忽略，只保留固定开销用于识别剩余分片
+//固定开销也超过上限时整条消息移除，剩余分片按新消息处理
+func (ff *FragmentFilter) discard(msgId uint32, r *reassembly) {
+	r.cost -= r.size
+	ff.pendingBytes -= r.size
+	r.discarded = true
+	r.size = 0
+	for i := range r.chunks {
+		r.chunks[i] = nil
+	}
+	if r.received == r.count || ff.pendingBytes > ff.maxPending {
+		ff.remove(msgId, r)
+	}
+}
+
+//sweep 清理超时的消息
+func (ff *FragmentFilter) sweep(session *Session, now time.Time) {
+	if now.Sub(ff.lastSweep) < ff.timeout/2 {
+		return
+	}
+	ff.lastSweep = now
+	for msgId, r := range ff.pending {
+		if now.Before(r.deadline) {
+			continue
+		}
+		if !r.discarded && session != nil {
+			session.fireEvent(Event{Type: EventPacketRejected, Err: ReassemblyTimeoutError})
+		}
+		ff.remove(msgId, r)
+	}
+}
@@ -0,0 +1,147 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/sumory/gotty/codec"
+	"testing"
+	"time"
+)
+
+func reassemble(ff *FragmentFilter, fragments []codec.Packet) (codec.Packet, error) {
+	var out codec.Packet
+	for _, f := range fragments {
+		p, err := ff.OnRead(nil, f)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			out = p
+		}
+	}
+	return out, nil
+}
+
+func Test_FragmentFilter(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+
+	convey.Convey("Small packet should pass through untouched", t, func() {
+		ff := NewFragmentFilter(1024, 1<<20, 1<<20, time.Minute)
+		p := newTestPacket([]byte("extra"), []byte("small"))
+		out, err := ff.OnWrite(nil, p)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(out), convey.ShouldEqual, 1)
+
+		got, err := ff.OnRead(nil, out[0])
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(string(got.(codec.LengthBasedPacket).Body.Data), convey.ShouldEqual, "small")
+	})
+
+	convey.Convey("Large packet should be fragmented and reassembled", t, func() {
+		sender := NewFragmentFilter(1024, 1<<20, 1<<20, time.Minute)
+		receiver := NewFragmentFilter(1024, 1<<20, 1<<20, time.Minute)
+		p := newTestPacket([]byte("extra"), data)
+
+		fragments, err := sender.OnWrite(nil, p)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(fragments), convey.ShouldBeGreaterThan, 1)
+		for _, f := range fragments {
+			lbp := f.(codec.LengthBasedPacket)
+			convey.So(lbp.Meta.TotalLen, convey.ShouldBeLessThanOrEqualTo, 1024)
+			convey.So(lbp.Header.Operation, convey.ShouldEqual, codec.OperationFragment)
+		}
+
+		got, err := reassemble(receiver, fragments)
+		convey.So(err, convey.ShouldBeNil)
+		lbp := got.(codec.LengthBasedPacket)
+		convey.So(bytes.Equal(lbp.Body.Data, data), convey.ShouldBeTrue)
+		convey.So(string(lbp.Header.Extra), convey.ShouldEqual, "extra")
+		convey.So(lbp.Header.Operation, convey.ShouldEqual, p.Header.Operation)
+		convey.So(lbp.Header.Sequence, convey.ShouldEqual, p.Header.Sequence)
+		convey.So(lbp.Meta.TotalLen, convey.ShouldEqual, p.Meta.TotalLen)
+		convey.So(receiver.pendingBytes, convey.ShouldEqual, 0)
+	})
+
+	convey.Convey("Interleaved fragments should be reassembled separately", t, func() {
+		sender := NewFragmentFilter(1024, 1<<20, 1<<20, time.Minute)
+		receiver := NewFragmentFilter(1024, 1<<20, 1<<20, time.Minute)
+		other := bytes.Repeat([]byte("abcdefghij"), 500)
+		f1, _ := sender.OnWrite(nil, newTestPacket(nil, data))
+		f2, _ := sender.OnWrite(nil, newTestPacket(nil, other))
+
+		//逆序交错
+		var mixed []codec.Packet
+		for i := len(f1) - 1; i >= 0; i-- {
+			mixed = append(mixed, f1[i])
+			if i < len(f2) {
+				mixed = append(mixed, f2[i])
+			}
+		}
+		var done [][]byte
+		for _, f := range mixed {
+			p, err := receiver.OnRead(nil, f)
+			convey.So(err, convey.ShouldBeNil)
+			if p != nil {
+				done = append(done, p.(codec.LengthBasedPacket).Body.Data)
+			}
+		}
+		convey.So(len(done), convey.ShouldEqual, 2)
+		convey.So(bytes.Equal(done[0], data), convey.ShouldBeTrue)
+		convey.So(bytes.Equal(done[1], other), convey.ShouldBeTrue)
+	})
+
+	convey.Convey("Reassembly limits should be enforced", t, func() {
+		sender := NewFragmentFilter(1024, 1<<20, 1<<20, time.Minute)
+		fragments, _ := sender.OnWrite(nil, newTestPacket(nil, data))
+
+		receiver := NewFragmentFilter(1024, 4096, 1<<20, time.Minute)
+		_, err := reassemble(receiver, fragments)
+		convey.So(err, convey.ShouldEqual, MessageTooLargeError)
+
+		receiver = NewFragmentFilter(1024, 1<<20, 2048, time.Minute)
+		_, err = reassemble(receiver, fragments)
+		convey.So(err, convey.ShouldEqual, ReassemblyBufferFullError)
+		//只保留识别剩余分片的固定开销
+		convey.So(receiver.pendingBytes, convey.ShouldEqual, reassemblyOverhead+2*fragmentOverhead)
+
+		//剩余分片被忽略，完成后清理
+		for _, f := range fragments[2:] {
+			p, err := receiver.OnRead(nil, f)
+			convey.So(p, convey.ShouldBeNil)
+			convey.So(err, convey.ShouldBeNil)
+		}
+		convey.So(len(receiver.pending), convey.ShouldEqual, 0)
+	})
+
+	convey.Convey("Empty fragments of many messages should count against the pending limit", t, func() {
+		receiver := NewFragmentFilter(1024, 1<<20, 4096, time.Minute)
+		var err error
+		msgs := 0
+		for ; msgs < 1000 && err == nil; msgs++ {
+			extra := make([]byte, fragmentHeaderLen)
+			binary.BigEndian.PutUint32(extra[0:4], uint32(msgs))
+			binary.BigEndian.PutUint32(extra[8:12], 1<<16)
+			f := newTestPacket(extra, nil)
+			f.Header.Operation = codec.OperationFragment
+			_, err = receiver.OnRead(nil, f)
+		}
+		convey.So(err, convey.ShouldEqual, ReassemblyBufferFullError)
+		convey.So(msgs, convey.ShouldBeLessThan, 4096/reassemblyOverhead+1)
+		convey.So(receiver.pendingBytes, convey.ShouldBeLessThanOrEqualTo, 4096)
+		convey.So(len(receiver.pending), convey.ShouldEqual, msgs-1)
+	})
+
+	convey.Convey("Incomplete message should expire", t, func() {
+		sender := NewFragmentFilter(1024, 1<<20, 1<<20, time.Minute)
+		fragments, _ := sender.OnWrite(nil, newTestPacket(nil, data))
+
+		receiver := NewFragmentFilter(1024, 1<<20, 1<<20, 10*time.Millisecond)
+		receiver.OnRead(nil, fragments[0])
+		convey.So(len(receiver.pending), convey.ShouldEqual, 1)
+		time.Sleep(20 * time.Millisecond)
+		receiver.OnRead(nil, fragments[1])
+		convey.So(len(receiver.pending), convey.ShouldEqual, 1)
+		convey.So(receiver.pending[1].received, convey.ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,141 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"github.com/sumory/gotty/codec"
+)
+
+// Errors
+var (
+	SessionClosedError    = errors.New("Session closed")
+	WriteChannelFullError = errors.New("Write channel is full")
+)
+
+//WriteFuture 异步写的结果，包被编码并flush到socket，或写出失败时完成
+type WriteFuture struct {
+	done    chan struct{}
+	err     error
+	session *Session
+}
+
+func newWriteFuture(session *Session) *WriteFuture {
+	return &WriteFuture{
+		done:    make(chan struct{}),
+		session: session,
+	}
+}
+
+//complete 设置结果，future为nil时忽略
+func (f *WriteFuture) complete(err error) {
+	if f == nil {
+		return
+	}
+	f.err = err
+	close(f.done)
+}
+
+//Done 完成时被close的channel
+func (f *WriteFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+//Err 写出结果，需在Done之后调用
+func (f *WriteFuture) Err() error {
+	return f.err
+}
+
+//Wait 等待写出完成，返回编码或socket的错误
+func (f *WriteFuture) Wait() error {
+	return f.WaitContext(context.Background())
+}
+
+//WaitContext 等待写出完成或ctx结束
+func (f *WriteFuture) WaitContext(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.session.closeChan:
+		//写协程退出前可能已完成该包
+		select {
+		case <-f.done:
+			return f.err
+		default:
+			return SessionClosedError
+		}
+	}
+}
+
+//futurePacket 携带WriteFuture的包，经WriteChannel传给写协程
+type futurePacket struct {
+	codec.Packet
+	future *WriteFuture
+}
+
+func unwrapWrite(p codec.Packet) (codec.Packet, *WriteFuture) {
+	if fp, ok := p.(*futurePacket); ok {
+		return fp.Packet, fp.future
+	}
+	return p, nil
+}
+
+//WriteAsync 异步写出，写队列满或session已关闭时返回的future立即失败
+func (session *Session) WriteAsync(p codec.Packet) *WriteFuture {
+	future := newWriteFuture(session)
+	if session.Closed() {
+		future.complete(SessionClosedError)
+		return future
+	}
+	if session.poll != nil {
+		session.poll.write(session, p, future)
+		return future
+	}
+
+	session.writeHold(p)
+	select {
+	case session.WriteChannel <- &futurePacket{Packet: p, future: future}:
+	default:
+		session.writeDone(p)
+		future.complete(WriteChannelFullError)
+	}
+	return future
+}
+
+//WriteContext 写队列满时阻塞等待，直到包进入写队列、ctx结束或session关闭
+func (session *Session) WriteContext(ctx context.Context, p codec.Packet) error {
+	if session.Closed() {
+		return SessionClosedError
+	}
+	if session.poll != nil {
+		//事件循环模式下写入不会阻塞，未写出的数据排在连接的发送队列中
+		return session.poll.write(session, p, nil)
+	}
+
+	session.writeHold(p)
+	select {
+	case session.WriteChannel <- p:
+		return nil
+	case <-ctx.Done():
+		session.writeDone(p)
+		return ctx.Err()
+	case <-session.closeChan:
+		session.writeDone(p)
+		return SessionClosedError
+	}
+}
+
+//failPendingWrites 写协程退出时，令写队列中剩余的future失败
+func (session *Session) failPendingWrites() {
+	for {
+		select {
+		case p := <-session.WriteChannel:
+			p, future := unwrapWrite(p)
+			session.writeDone(p)
+			future.complete(SessionClosedError)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package session
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/config"
+	"testing"
+	"time"
+)
+
+//failingFilter 写出时拒绝带Extra的包
+type failingFilter struct{}
+
+func (f failingFilter) OnRead(s *Session, p codec.Packet) (codec.Packet, error) {
+	return p, nil
+}
+
+func (f failingFilter) OnWrite(s *Session, p codec.Packet) ([]codec.Packet, error) {
+	if len(p.(codec.LengthBasedPacket).Header.Extra) > 0 {
+		return nil, errors.New("rejected by filter")
+	}
+	return []codec.Packet{p}, nil
+}
+
+func Test_WriteFuture(t *testing.T) {
+	convey.Convey("Future should complete after the packet is written", t, func() {
+		s, peer := newStartedSession(config.NewDefaultGottyConfig(), nil, func(s *Session, p codec.Packet) {}, nil)
+		defer s.Close()
+		defer peer.Close()
+		s.AddFilter(failingFilter{})
+
+		convey.So(s.WriteAsync(newTestPacket(nil, []byte("hello"))).Wait(), convey.ShouldBeNil)
+		lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		p, err := lbc.Read(bufio.NewReader(peer))
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(string(p.(codec.LengthBasedPacket).Body.Data), convey.ShouldEqual, "hello")
+
+		future := s.WriteAsync(newTestPacket([]byte("x"), nil))
+		<-future.Done()
+		convey.So(future.Err(), convey.ShouldNotBeNil)
+		convey.So(future.Err().Error(), convey.ShouldEqual, "rejected by filter")
+	})
+
+	convey.Convey("Full queue should fail async writes and block WriteContext", t, func() {
+		s := newTestSession(func(s *Session, p codec.Packet) {})
+		s.WriteChannel = make(chan codec.Packet, 1)
+
+		queued := s.WriteAsync(newTestPacket(nil, nil))
+		convey.So(s.WriteAsync(newTestPacket(nil, nil)).Wait(), convey.ShouldEqual, WriteChannelFullError)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		convey.So(s.WriteContext(ctx, newTestPacket(nil, nil)), convey.ShouldEqual, context.DeadlineExceeded)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- s.WriteContext(context.Background(), newTestPacket(nil, nil))
+		}()
+		time.Sleep(10 * time.Millisecond)
+		s.Close()
+		convey.So(<-done, convey.ShouldEqual, SessionClosedError)
+		convey.So(queued.Wait(), convey.ShouldEqual, SessionClosedError)
+	})
+}
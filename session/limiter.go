@@ -0,0 +1,199 @@
+package session
+
+import (
+	"errors"
+	"github.com/sumory/gotty/codec"
+	log "github.com/sumory/log4go"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Errors
+var (
+	OverloadError = errors.New("Concurrency limit exceeded")
+)
+
+//Limit 并发上限算法，根据观测到的处理耗时调整上限
+type Limit interface {
+	//Limit 当前并发上限
+	Limit() int
+	//OnSample 每个包处理完后调用，inflight为该包开始处理时的并发数
+	OnSample(latency time.Duration, inflight int)
+}
+
+//AIMDLimit 加性增、乘性减：耗时超过阈值时按比例缩小上限，否则在并发接近上限时加一
+type AIMDLimit struct {
+	lock      sync.Mutex
+	limit     int
+	min       int
+	max       int
+	backoff   float64       //缩小比例，0~1
+	threshold time.Duration //耗时阈值
+}
+
+//NewAIMDLimit 新建AIMD算法，初始上限为min
+func NewAIMDLimit(min, max int, backoff float64, threshold time.Duration) *AIMDLimit {
+	return &AIMDLimit{
+		limit:     min,
+		min:       min,
+		max:       max,
+		backoff:   backoff,
+		threshold: threshold,
+	}
+}
+
+//Limit 实现Limit
+func (l *AIMDLimit) Limit() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.limit
+}
+
+//OnSample 实现Limit
+func (l *AIMDLimit) OnSample(latency time.Duration, inflight int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if latency > l.threshold {
+		l.limit = int(float64(l.limit) * l.backoff)
+		if l.limit < l.min {
+			l.limit = l.min
+		}
+	} else if inflight*2 >= l.limit && l.limit < l.max {
+		//并发远低于上限时不增长，避免空闲时上限无限膨胀
+		l.limit++
+	}
+}
+
+//GradientLimit 梯度算法：比较长期平均耗时与当前耗时，耗时上升时按比例收缩，平稳时缓慢增长
+type GradientLimit struct {
+	lock      sync.Mutex
+	limit     float64
+	min       int
+	max       int
+	smoothing float64 //新上限的权重，0~1
+	longRTT   float64 //长期平均耗时，纳秒
+	longCount int     //长期平均的窗口大小
+	samples   int
+}
+
+//NewGradientLimit 新建梯度算法，初始上限为min
+func NewGradientLimit(min, max int) *GradientLimit {
+	return &GradientLimit{
+		limit:     float64(min),
+		min:       min,
+		max:       max,
+		smoothing: 0.2,
+		longCount: 100,
+	}
+}
+
+//Limit 实现Limit
+func (l *GradientLimit) Limit() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return int(l.limit)
+}
+
+//OnSample 实现Limit
+func (l *GradientLimit) OnSample(latency time.Duration, inflight int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	rtt := float64(latency)
+	if rtt <= 0 {
+		rtt = 1
+	}
+	if l.samples < l.longCount {
+		l.samples++
+	}
+	l.longRTT += (rtt - l.longRTT) / float64(l.samples)
+	//负载下降后长期平均偏高，向当前耗时靠拢，以便尽快恢复
+	if l.longRTT > rtt*2 {
+		l.longRTT = rtt * 2
+	}
+
+	//并发不足上限一半时耗时不能说明上限是否合适
+	if float64(inflight)*2 < l.limit {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1.0, l.longRTT/rtt))
+	next := l.limit*gradient + math.Sqrt(l.limit)
+	l.limit = l.limit*(1-l.smoothing) + next*l.smoothing
+	l.limit = math.Max(float64(l.min), math.Min(float64(l.max), l.limit))
+}
+
+//LimitedExecutor 自适应并发限制的执行器，并发超过上限的包立即被拒绝并回复OperationBusy
+//与排队相比，过载时请求方能尽快得知并退避，已接受的请求耗时保持稳定
+type LimitedExecutor struct {
+	limit    Limit
+	inflight int32
+	rejected uint64
+	complete uint64
+
+	lock   sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+//NewLimitedExecutor 新建自适应并发限制的执行器
+func NewLimitedExecutor(limit Limit) *LimitedExecutor {
+	return &LimitedExecutor{limit: limit}
+}
+
+//Execute 实现Executor
+func (le *LimitedExecutor) Execute(session *Session, p codec.Packet) error {
+	le.lock.RLock()
+	defer le.lock.RUnlock()
+	if le.closed {
+		return ExecutorClosedError
+	}
+
+	inflight := int(atomic.AddInt32(&le.inflight, 1))
+	if inflight > le.limit.Limit() {
+		atomic.AddInt32(&le.inflight, -1)
+		atomic.AddUint64(&le.rejected, 1)
+		if busy, ok := busyReply(p); ok {
+			session.Write(busy)
+		}
+		session.fireEvent(Event{Type: EventPacketDropped, Packet: p, Err: OverloadError})
+		return OverloadError
+	}
+
+	le.wg.Add(1)
+	go func() {
+		defer func() {
+			if err := recover(); nil != err {
+				log.Error("handle packet panic, remoteAddr: %s, err: %s", session.remoteAddr, err)
+			}
+			atomic.AddInt32(&le.inflight, -1)
+			atomic.AddUint64(&le.complete, 1)
+			le.wg.Done()
+		}()
+
+		start := time.Now()
+		session.handle(p)
+		le.limit.OnSample(time.Since(start), inflight)
+	}()
+	return nil
+}
+
+//Shutdown 实现Executor
+func (le *LimitedExecutor) Shutdown() {
+	le.lock.Lock()
+	le.closed = true
+	le.lock.Unlock()
+	le.wg.Wait()
+}
+
+//Stats 统计信息，Workers为当前并发上限
+func (le *LimitedExecutor) Stats() ExecutorStats {
+	return ExecutorStats{
+		Workers:   le.limit.Limit(),
+		Busy:      int(atomic.LoadInt32(&le.inflight)),
+		Completed: atomic.LoadUint64(&le.complete),
+		Rejected:  atomic.LoadUint64(&le.rejected),
+	}
+}
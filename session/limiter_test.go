@@ -0,0 +1,69 @@
+package session
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/sumory/gotty/codec"
+	"testing"
+	"time"
+)
+
+func Test_Limit(t *testing.T) {
+	convey.Convey("AIMD limit should grow under fast load and back off when slow", t, func() {
+		l := NewAIMDLimit(4, 10, 0.5, 10*time.Millisecond)
+		for i := 0; i < 20; i++ {
+			l.OnSample(time.Millisecond, l.Limit())
+		}
+		convey.So(l.Limit(), convey.ShouldEqual, 10)
+
+		l.OnSample(time.Millisecond, 1)
+		convey.So(l.Limit(), convey.ShouldEqual, 10)
+
+		l.OnSample(20*time.Millisecond, 10)
+		convey.So(l.Limit(), convey.ShouldEqual, 5)
+		l.OnSample(20*time.Millisecond, 5)
+		convey.So(l.Limit(), convey.ShouldEqual, 4)
+	})
+
+	convey.Convey("Gradient limit should shrink when latency rises", t, func() {
+		l := NewGradientLimit(4, 100)
+		for i := 0; i < 100; i++ {
+			l.OnSample(time.Millisecond, l.Limit())
+		}
+		grown := l.Limit()
+		convey.So(grown, convey.ShouldBeGreaterThan, 20)
+
+		for i := 0; i < 10; i++ {
+			l.OnSample(5*time.Millisecond, l.Limit())
+		}
+		convey.So(l.Limit(), convey.ShouldBeLessThan, grown/2)
+		convey.So(l.Limit(), convey.ShouldBeGreaterThanOrEqualTo, 4)
+	})
+
+	convey.Convey("Limited executor should reject excess requests with a busy reply", t, func() {
+		started := make(chan struct{}, 2)
+		release := make(chan struct{})
+		s := newTestSession(blockingHandler(started, release))
+		defer s.Close()
+
+		le := NewLimitedExecutor(NewAIMDLimit(2, 2, 0.5, time.Second))
+		convey.So(le.Execute(s, newTestPacket(nil, nil)), convey.ShouldBeNil)
+		convey.So(le.Execute(s, newTestPacket(nil, nil)), convey.ShouldBeNil)
+		<-started
+		<-started
+
+		p := newTestPacket(nil, nil)
+		p.Header.Sequence = 42
+		convey.So(le.Execute(s, p), convey.ShouldEqual, OverloadError)
+		busy := <-s.WriteChannel
+		convey.So(IsBusy(busy), convey.ShouldBeTrue)
+		convey.So(IsBusy(p), convey.ShouldBeFalse)
+		convey.So(busy.(codec.LengthBasedPacket).Header.Sequence, convey.ShouldEqual, 42)
+		convey.So(le.Stats().Busy, convey.ShouldEqual, 2)
+		convey.So(le.Stats().Rejected, convey.ShouldEqual, 1)
+
+		close(release)
+		le.Shutdown()
+		convey.So(le.Stats().Completed, convey.ShouldEqual, 2)
+		convey.So(le.Execute(s, p), convey.ShouldEqual, ExecutorClosedError)
+	})
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"github.com/sumory/gotty/codec"
+	"hash/fnv"
+)
+
+//KeyFunc 从包中提取顺序键，键相同的包按到达顺序串行处理
+type KeyFunc func(session *Session, p codec.Packet) uint64
+
+//SessionKey 以session为顺序键，同一session的包串行处理
+func SessionKey(session *Session, p codec.Packet) uint64 {
+	return session.ID()
+}
+
+//ExtraKey 以Header.Extra中[offset, offset+size)的字节为顺序键，如放在元数据中的用户id
+//不是LengthBasedPacket或Extra长度不足时退回以session为键
+func ExtraKey(offset, size int) KeyFunc {
+	return func(session *Session, p codec.Packet) uint64 {
+		lbp, err := asLengthBasedPacket(p)
+		if err != nil || len(lbp.Header.Extra) < offset+size {
+			return session.ID()
+		}
+		h := fnv.New64a()
+		h.Write(lbp.Header.Extra[offset : offset+size])
+		return h.Sum64()
+	}
+}
+
+//OrderedExecutor 有序执行器，按顺序键分片，每个分片只有一个worker
+//键相同的包总落在同一分片因而串行且有序，不同分片之间并行，可在多个session间共享
+type OrderedExecutor struct {
+	shards []*WorkerPool
+	key    KeyFunc
+}
+
+//NewOrderedExecutor 新建有序执行器，queueSize为每个分片的队列容量
+func NewOrderedExecutor(shards, queueSize int, policy RejectPolicy, key KeyFunc) *OrderedExecutor {
+	oe := &OrderedExecutor{
+		shards: make([]*WorkerPool, shards),
+		key:    key,
+	}
+	for i := range oe.shards {
+		oe.shards[i] = NewWorkerPool(1, queueSize, policy)
+	}
+	return oe
+}
+
+//shard 顺序键对应的分片，先打散以免连续的键集中在相邻分片
+func (oe *OrderedExecutor) shard(key uint64) int {
+	key *= 0x9E3779B97F4A7C15
+	return int((key >> 32) % uint64(len(oe.shards)))
+}
+
+//Execute 实现Executor
+func (oe *OrderedExecutor) Execute(session *Session, p codec.Packet) error {
+	return oe.shards[oe.shard(oe.key(session, p))].Execute(session, p)
+}
+
+//TryExecute 实现TryExecutor
+func (oe *OrderedExecutor) TryExecute(session *Session, p codec.Packet) error {
+	return oe.shards[oe.shard(oe.key(session, p))].TryExecute(session, p)
+}
+
+//Shutdown 实现Executor
+func (oe *OrderedExecutor) Shutdown() {
+	for _, wp := range oe.shards {
+		wp.Shutdown()
+	}
+}
+
+//Stats 所有分片的统计之和
+func (oe *OrderedExecutor) Stats() ExecutorStats {
+	var stats ExecutorStats
+	for _, wp := range oe.shards {
+		s := wp.Stats()
+		stats.Workers += s.Workers
+		stats.Busy += s.Busy
+		stats.QueueDepth += s.QueueDepth
+		stats.QueueCapacity += s.QueueCapacity
+		stats.Completed += s.Completed
+		stats.Rejected += s.Rejected
+	}
+	return stats
+}
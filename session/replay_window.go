@@ -0,0 +1,73 @@
+package session
+
+import (
+	"sync"
+)
+
+//replayWindow 有界滑动窗口，记录最近size个nonce是否已出现
+//比窗口下沿更旧的nonce一律视为重放
+type replayWindow struct {
+	lock    sync.Mutex
+	size    uint64
+	highest uint64   //已见到的最大nonce
+	bitmap  []uint64 //bit i 表示 highest-i 是否已出现
+}
+
+func newReplayWindow(size int) *replayWindow {
+	if size <= 0 {
+		size = 1024
+	}
+	words := (size + 63) / 64
+	return &replayWindow{
+		size:   uint64(words * 64),
+		bitmap: make([]uint64, words),
+	}
+}
+
+//check 校验并记录nonce，nonce必须大于0，重复或过旧返回false
+func (w *replayWindow) check(nonce uint64) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if nonce == 0 {
+		return false
+	}
+	if nonce > w.highest {
+		w.shift(nonce - w.highest)
+		w.highest = nonce
+		w.bitmap[0] |= 1
+		return true
+	}
+
+	offset := w.highest - nonce
+	if offset >= w.size {
+		return false
+	}
+	word, bit := offset/64, offset%64
+	if w.bitmap[word]&(1<<bit) != 0 {
+		return false
+	}
+	w.bitmap[word] |= 1 << bit
+	return true
+}
+
+//shift 窗口整体前移n位
+func (w *replayWindow) shift(n uint64) {
+	if n >= w.size {
+		for i := range w.bitmap {
+			w.bitmap[i] = 0
+		}
+		return
+	}
+	words, bits := int(n/64), n%64
+	for i := len(w.bitmap) - 1; i >= 0; i-- {
+		var v uint64
+		if src := i - words; src >= 0 {
+			v = w.bitmap[src] << bits
+			if bits > 0 && src-1 >= 0 {
+				v |= w.bitmap[src-1] >> (64 - bits)
+			}
+		}
+		w.bitmap[i] = v
+	}
+}
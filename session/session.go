@@ -7,6 +7,7 @@ import (
 	"github.com/sumory/gotty/config"
 	log "github.com/sumory/log4go"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -27,16 +28,37 @@ type Session struct {
 
 	//消息传输
 	bReader      *bufio.Reader
+	readState    codec.ReadState //codec已从bReader取出、还没有解析的数据
 	bWriter      *bufio.Writer
 	ReadChannel  chan codec.Packet //传输请求体的channel
 	WriteChannel chan codec.Packet //传输响应体的channel
 
-	isClose  bool
-	lastTime time.Time              //最后活跃时间
-	attrs    map[string]interface{} //其他属性数据
+	isClose   int32                  //是否已关闭，原子操作
+	closeChan chan struct{}          //关闭时close，通知各协程退出
+	lastTime  time.Time              //最后活跃时间
+	attrs     map[string]interface{} //其他属性数据
+	attrsLock sync.RWMutex
 
-	codec   codec.Codec //编解码器
-	handler handlerFunc //包处理函数
+	codec    codec.Codec //编解码器
+	handler  handlerFunc //包处理函数
+	executor Executor    //包处理执行器，为nil时每个包启动一个goroutine
+
+	filters      []Filter     //读写过滤器
+	eventHandler EventHandler //事件处理函数
+
+	//读水位，统计已读入但未处理完的包
+	readLock    sync.Mutex
+	readPending int
+	readBytes   int
+	readPaused  bool
+	readResume  chan struct{} //暂停时创建，恢复时close
+
+	//写水位，统计写队列中待写出的字节
+	writeLock    sync.Mutex
+	writeQueued  int
+	writeBlocked bool //超过写高水位，IsWritable为false
+
+	poll *pollConn //事件循环模式下的连接状态，普通模式为nil
 }
 
 //NewSession 创建新的session对话
@@ -58,8 +80,10 @@ func NewSession(conn *net.TCPConn, sessionCodec codec.Codec, config *config.Gott
 		ReadChannel:  make(chan codec.Packet, config.ReadChanSize),
 		WriteChannel: make(chan codec.Packet, config.WriteChanSize),
 
-		isClose: false,
-		config:  config,
+		isClose:   0,
+		closeChan: make(chan struct{}),
+		attrs:     make(map[string]interface{}),
+		config:    config,
 
 		codec:   sessionCodec,
 		handler: handler,
@@ -69,14 +93,23 @@ func NewSession(conn *net.TCPConn, sessionCodec codec.Codec, config *config.Gott
 
 //Set 保存自定义的kv数据
 func (session *Session) Set(name string, v interface{}) {
+	session.attrsLock.Lock()
 	session.attrs[name] = v
+	session.attrsLock.Unlock()
 }
 
 //Get 获取自定义的kv数据
 func (session *Session) Get(name string) interface{} {
+	session.attrsLock.RLock()
+	defer session.attrsLock.RUnlock()
 	return session.attrs[name]
 }
 
+//ID 获取session标识
+func (session *Session) ID() uint64 {
+	return session.id
+}
+
 //RemoteAddr 获取连接的远程地址
 func (session *Session) RemoteAddr() string {
 	return session.remoteAddr
@@ -92,6 +125,23 @@ func (session *Session) Idle() bool {
 	return time.Now().After(session.lastTime.Add(session.config.IdleTime))
 }
 
+//SetEventHandler 设置事件处理函数
+func (session *Session) SetEventHandler(h EventHandler) {
+	session.eventHandler = h
+}
+
+//SetExecutor 设置包处理执行器，需在Start之前调用
+func (session *Session) SetExecutor(e Executor) {
+	session.executor = e
+}
+
+//fireEvent 触发事件
+func (session *Session) fireEvent(e Event) {
+	if session.eventHandler != nil {
+		session.eventHandler(session, e)
+	}
+}
+
 //ReadPacket 读取
 func (session *Session) ReadPacket() {
 	defer func() {
@@ -100,32 +150,67 @@ func (session *Session) ReadPacket() {
 				session.localAddr, session.remoteAddr, err)
 		}
 	}()
-	for !session.isClose {
-		packet, err := session.codec.Read(session.bReader)
+	for !session.Closed() {
+		packet, err := session.readPacket()
 		if err != nil {
 			log.Error("read packet error, ", err)
 			session.Close()
+			break
+		}
+
+		p, err := session.filterRead(packet)
+		if err != nil {
+			log.Warn("packet rejected, remoteAddr: %s, err: %s", session.remoteAddr, err)
+			session.fireEvent(Event{Type: EventPacketRejected, Packet: packet, Err: err})
+			continue
+		}
+		if p == nil {
+			continue
+		}
+
+		session.readHold(p)
+		select {
+		case session.ReadChannel <- p:
+		case <-session.closeChan:
+			return
 		}
+		session.waitReadable()
+	}
+}
 
-		session.ReadChannel <- packet
+//readPacket 从bReader读取一个包，codec需要时使用本连接的读取状态
+func (session *Session) readPacket() (codec.Packet, error) {
+	if sr, ok := session.codec.(codec.StatefulReader); ok {
+		return sr.ReadWithState(session.bReader, &session.readState)
 	}
+	return session.codec.Read(session.bReader)
 }
 
-//WritePacket 从channel中取出包并写出
+//WritePacket 从channel中取出包并写出，每次取空写队列后统一flush
 func (session *Session) WritePacket() {
-	var p codec.Packet
-	for !session.isClose {
-		p = <-session.WriteChannel
-		if nil != p {
-			err := session.codec.Write(session.bWriter, p)
-			if err != nil {
-				log.Error("写出包错误", err)
-			}
+	batch := make([]codec.Packet, 0, maxWriteBatch)
+	for !session.Closed() {
+		var p codec.Packet
+		select {
+		case p = <-session.WriteChannel:
+		case <-session.closeChan:
+			session.failPendingWrites()
+			return
+		}
 
-			session.lastTime = time.Now()
-		} else {
-			log.Warn("the packet from WriteChannel is nil")
+		batch = append(batch[:0], p)
+	drain:
+		for len(batch) < maxWriteBatch {
+			select {
+			case p = <-session.WriteChannel:
+				batch = append(batch, p)
+			default:
+				break drain
+			}
 		}
+
+		session.writeBatch(batch)
+		session.lastTime = time.Now()
 	}
 }
 
@@ -133,52 +218,98 @@ func (session *Session) WritePacket() {
 func (session *Session) dispatchPacket() {
 	//解析
 	for !session.Closed() {
-		p := <-session.ReadChannel
+		var p codec.Packet
+		select {
+		case p = <-session.ReadChannel:
+		case <-session.closeChan:
+			return
+		}
 		if nil == p {
 			continue
 		}
-
-		//模拟queue/pool
-		session.config.DispatcherQueueSize <- 1
-		go func() {
-			defer func() {
-				<-session.config.DispatcherQueueSize
-			}()
-
-			session.handler(session, p)
-		}()
+		session.Dispatch(p)
 	}
 }
 
-//ReadMessage 读取
-func (session *Session) ReadMessage() {
-	defer func() {
-		if err := recover(); nil != err {
-			log.Warn("session read packet failed, localAddr: %s, remoteAddr: %s, err: %s",
-				session.localAddr, session.remoteAddr, err)
+//Dispatch 将包交给执行器处理，未设置执行器时每个包启动一个goroutine
+//事件循环模式下不阻塞循环协程，队列满时丢弃包并触发EventPacketDropped
+func (session *Session) Dispatch(p codec.Packet) {
+	if session.executor != nil {
+		var err error
+		if te, ok := session.executor.(TryExecutor); ok && session.poll != nil {
+			//不能阻塞事件循环协程
+			err = te.TryExecute(session, p)
+		} else {
+			err = session.executor.Execute(session, p)
 		}
-	}()
-	for !session.isClose {
-		packet, err := session.codec.Read(session.bReader)
 		if err != nil {
-			log.Error("read packet error, ", err)
-			session.Close()
+			log.Warn("dispatch packet failed, remoteAddr: %s, err: %s", session.remoteAddr, err)
+			session.readDone(p)
 		}
+		return
+	}
 
-		session.ReadChannel <- packet
+	//模拟queue/pool
+	if session.poll == nil {
+		session.config.DispatcherQueueSize <- 1
+	} else {
+		select {
+		case session.config.DispatcherQueueSize <- 1:
+		default:
+			//事件循环模式下队列满时丢弃，不阻塞循环协程
+			log.Warn("dispatch packet failed, remoteAddr: %s, err: %s", session.remoteAddr, ExecutorBusyError)
+			session.fireEvent(Event{Type: EventPacketDropped, Packet: p, Err: ExecutorBusyError})
+			session.readDone(p)
+			return
+		}
 	}
+	go func() {
+		defer func() {
+			<-session.config.DispatcherQueueSize
+		}()
+
+		session.handle(p)
+	}()
 }
 
-//WriteMessage 从channel中取出包并写出
+//handle 调用包处理函数，处理完后更新读水位
+func (session *Session) handle(p codec.Packet) {
+	defer session.readDone(p)
+	session.handler(session, p)
+}
+
+//ReadMessage 读取，与ReadPacket一样经过读过滤器
+func (session *Session) ReadMessage() {
+	session.ReadPacket()
+}
+
+//WriteMessage 从channel中取出包并逐个写出，与WritePacket一样经过写过滤器
 func (session *Session) WriteMessage() {
 	var p codec.Packet
-	for !session.isClose {
-		p = <-session.WriteChannel
+	for !session.Closed() {
+		select {
+		case p = <-session.WriteChannel:
+		case <-session.closeChan:
+			session.failPendingWrites()
+			return
+		}
 		if nil != p {
-			err := session.codec.Write(session.bWriter, p)
+			var future *WriteFuture
+			p, future = unwrapWrite(p)
+			packets, err := session.filterWrite(p)
 			if err != nil {
-				log.Error("codec write error", err)
+				log.Error("filter packet error", err)
+			}
+			for _, fp := range packets {
+				if werr := session.codec.Write(session.bWriter, fp); werr != nil {
+					log.Error("codec write error", werr)
+					if err == nil {
+						err = werr
+					}
+				}
 			}
+			session.writeDone(p)
+			future.complete(err)
 
 			session.lastTime = time.Now()
 		} else {
@@ -191,34 +322,30 @@ func (session *Session) WriteMessage() {
 func (session *Session) dispatchMessage() {
 	//解析
 	for !session.Closed() {
-		p := <-session.ReadChannel
+		var p codec.Packet
+		select {
+		case p = <-session.ReadChannel:
+		case <-session.closeChan:
+			return
+		}
 		if nil == p {
 			continue
 		}
-
-		//模拟queue/pool
-		session.config.DispatcherQueueSize <- 1
-		go func() {
-			defer func() {
-				<-session.config.DispatcherQueueSize
-			}()
-
-			session.handler(session, p)
-		}()
+		session.Dispatch(p)
 	}
 }
 
 //Start 开启session，开始收发包
 func (session *Session) Start() {
-	go session.WritePacket()
-	go session.dispatchPacket()
-	go session.ReadPacket()
-
 	laddr := session.conn.LocalAddr().(*net.TCPAddr)
 	raddr := session.conn.RemoteAddr().(*net.TCPAddr)
 	session.localAddr = fmt.Sprintf("%s:%d", laddr.IP, laddr.Port)
 	session.remoteAddr = fmt.Sprintf("%s:%d", raddr.IP, raddr.Port)
 
+	go session.WritePacket()
+	go session.dispatchPacket()
+	go session.ReadPacket()
+
 	log.Info("session start: %s <-> %s", session.localAddr, session.remoteAddr)
 }
 
@@ -231,29 +358,41 @@ func (session *Session) Write(p codec.Packet) error {
 		}
 	}()
 
-	if !session.isClose {
+	if session.poll != nil {
+		return session.poll.write(session, p, nil)
+	}
+	if !session.Closed() {
+		session.writeHold(p)
 		select {
 		case session.WriteChannel <- p:
 			return nil
 		default:
+			session.writeDone(p)
 			return fmt.Errorf("write channel is full: %s", session.remoteAddr)
 		}
 	}
 	return fmt.Errorf("session closed: %s", session.remoteAddr)
 }
 
+//Done 返回session关闭时被close的channel
+func (session *Session) Done() <-chan struct{} {
+	return session.closeChan
+}
+
 //Closed 当前连接是否关闭
 func (session *Session) Closed() bool {
-	return session.isClose
+	return atomic.LoadInt32(&session.isClose) == 1
 }
 
-//Close 关闭当前对话：关闭连接、channel及其他善后处理
+//Close 关闭当前对话：关闭连接、通知各协程退出及其他善后处理
+//ReadChannel和WriteChannel不再关闭，避免并发写入时panic
 func (session *Session) Close() error {
-	if !session.isClose {
-		session.isClose = true
+	if atomic.CompareAndSwapInt32(&session.isClose, 0, 1) {
+		close(session.closeChan)
+		if session.poll != nil {
+			session.poll.close(session)
+		}
 		session.conn.Close()
-		close(session.WriteChannel)
-		close(session.ReadChannel)
 		log.Info("session close, remoteAddr: %s", session.remoteAddr)
 	}
 	return nil
@@ -0,0 +1,174 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/sumory/gotty/codec"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	signSenderLen = 8
+	signNonceLen  = 8
+	signTimeLen   = 8
+	signMacLen    = sha256.Size
+	//签名信息追加在Header.Extra尾部: sender(8) + nonce(8) + timestamp(8) + hmac(32)
+	signTrailerLen = signSenderLen + signNonceLen + signTimeLen + signMacLen
+)
+
+// Errors
+var (
+	MissingSignatureError = errors.New("Packet signature is missing")
+	BadSignatureError     = errors.New("Packet signature mismatch")
+	StaleTimestampError   = errors.New("Packet timestamp out of allowed skew")
+	ReplayedNonceError    = errors.New("Packet nonce already seen or too old")
+	ReflectedPacketError  = errors.New("Packet was signed by this side")
+	SenderMismatchError   = errors.New("Packet sender does not match the session peer")
+)
+
+//SignFilter 对LengthBasedPacket进行HMAC-SHA256签名，并校验时间戳和nonce以防重放
+//签名覆盖meta、header(含原始extra)、发送方标识、nonce、时间戳及body
+//每个过滤器有随机的发送方标识，收到自己签名的包即为反射；接收方记住第一个通过校验的对端标识，
+//之后只接受该对端的包，其他session的包不能混入。不同session的nonce互不相关，
+//跨session的重放只能靠时间戳校验，maxSkew<=0时只防同一个session内的重放
+type SignFilter struct {
+	key     []byte
+	maxSkew time.Duration //允许的时钟偏差，<=0表示不校验时间戳
+	sender  uint64        //本端的随机标识
+	peer    uint64        //对端的标识，收到第一个合法包时确定，原子操作
+	nonce   uint64        //发送方单调递增nonce
+	window  *replayWindow //接收方已见nonce的滑动窗口
+}
+
+//NewSignFilterFactory 创建签名过滤器工厂，每个session拥有独立的nonce和窗口
+func NewSignFilterFactory(key []byte, maxSkew time.Duration, windowSize int) FilterFactory {
+	return func(session *Session) Filter {
+		return NewSignFilter(key, maxSkew, windowSize)
+	}
+}
+
+//NewSignFilter 新建签名过滤器
+func NewSignFilter(key []byte, maxSkew time.Duration, windowSize int) *SignFilter {
+	return &SignFilter{
+		key:     key,
+		maxSkew: maxSkew,
+		sender:  newSenderID(),
+		window:  newReplayWindow(windowSize),
+	}
+}
+
+//newSenderID 生成非0的随机标识
+func newSenderID() uint64 {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		if id := binary.BigEndian.Uint64(b[:]); id != 0 {
+			return id
+		}
+	}
+}
+
+//OnWrite 追加签名
+func (sf *SignFilter) OnWrite(session *Session, p codec.Packet) ([]codec.Packet, error) {
+	lbp, err := asLengthBasedPacket(p)
+	if err != nil {
+		return nil, err
+	}
+
+	extra := make([]byte, len(lbp.Header.Extra)+signTrailerLen)
+	n := copy(extra, lbp.Header.Extra)
+	binary.BigEndian.PutUint64(extra[n:], sf.sender)
+	n += signSenderLen
+	binary.BigEndian.PutUint64(extra[n:], atomic.AddUint64(&sf.nonce, 1))
+	binary.BigEndian.PutUint64(extra[n+signNonceLen:], uint64(time.Now().UnixNano()))
+
+	header := *lbp.Header
+	header.Extra = extra
+	meta := &codec.LengthBasedPacketMeta{
+		TotalLen:  lbp.Meta.TotalLen + signTrailerLen,
+		HeaderLen: lbp.Meta.HeaderLen + signTrailerLen,
+	}
+	signed := codec.LengthBasedPacket{Meta: meta, Header: &header, Body: lbp.Body}
+	copy(extra[len(extra)-signMacLen:], sf.sum(signed, extra[:len(extra)-signMacLen]))
+	return []codec.Packet{signed}, nil
+}
+
+//OnRead 校验签名并去掉签名信息
+func (sf *SignFilter) OnRead(session *Session, p codec.Packet) (codec.Packet, error) {
+	lbp, err := asLengthBasedPacket(p)
+	if err != nil {
+		return nil, err
+	}
+	extra := lbp.Header.Extra
+	if len(extra) < signTrailerLen {
+		return nil, MissingSignatureError
+	}
+
+	signedPart := extra[:len(extra)-signMacLen]
+	if subtle.ConstantTimeCompare(sf.sum(lbp, signedPart), extra[len(signedPart):]) != 1 {
+		return nil, BadSignatureError
+	}
+
+	n := len(extra) - signTrailerLen
+	sender := binary.BigEndian.Uint64(extra[n:])
+	if sender == sf.sender {
+		return nil, ReflectedPacketError
+	}
+	if peer := atomic.LoadUint64(&sf.peer); peer != 0 && peer != sender {
+		return nil, SenderMismatchError
+	}
+	nonce := binary.BigEndian.Uint64(extra[n+signSenderLen:])
+	ts := time.Unix(0, int64(binary.BigEndian.Uint64(extra[n+signSenderLen+signNonceLen:])))
+	if sf.maxSkew > 0 {
+		if skew := time.Since(ts); skew > sf.maxSkew || skew < -sf.maxSkew {
+			return nil, StaleTimestampError
+		}
+	}
+	if !sf.window.check(nonce) {
+		return nil, ReplayedNonceError
+	}
+	atomic.StoreUint64(&sf.peer, sender)
+
+	header := *lbp.Header
+	header.Extra = extra[:n]
+	meta := &codec.LengthBasedPacketMeta{
+		TotalLen:  lbp.Meta.TotalLen - signTrailerLen,
+		HeaderLen: lbp.Meta.HeaderLen - signTrailerLen,
+	}
+	return codec.LengthBasedPacket{Meta: meta, Header: &header, Body: lbp.Body}, nil
+}
+
+//sum 计算签名，signedExtra为extra中除hmac外的部分
+func (sf *SignFilter) sum(p codec.LengthBasedPacket, signedExtra []byte) []byte {
+	var fixed [16]byte
+	binary.BigEndian.PutUint32(fixed[0:4], p.Meta.TotalLen)
+	binary.BigEndian.PutUint32(fixed[4:8], p.Meta.HeaderLen)
+	binary.BigEndian.PutUint32(fixed[8:12], p.Header.Sequence)
+	binary.BigEndian.PutUint16(fixed[12:14], p.Header.Operation)
+	binary.BigEndian.PutUint16(fixed[14:16], p.Header.Version)
+
+	//同一个session的读写分别在各自的协程中，hash每次新建避免竞争
+	mac := hmac.New(sha256.New, sf.key)
+	mac.Write(fixed[:])
+	mac.Write(signedExtra)
+	mac.Write(p.Body.Data)
+	return mac.Sum(nil)
+}
+
+func asLengthBasedPacket(p codec.Packet) (codec.LengthBasedPacket, error) {
+	switch lbp := p.(type) {
+	case codec.LengthBasedPacket:
+		return lbp, nil
+	case *codec.LengthBasedPacket:
+		return *lbp, nil
+	}
+	return codec.LengthBasedPacket{}, fmt.Errorf("packet is not length based")
+}
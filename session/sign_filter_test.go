@@ -0,0 +1,169 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/config"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestPacket(extra, data []byte) codec.LengthBasedPacket {
+	header := &codec.LengthBasedPacketHeader{
+		Sequence:  7,
+		Operation: 1,
+		Version:   0,
+		Extra:     extra,
+	}
+	body := &codec.LengthBasedPacketBody{
+		Data: data,
+	}
+	meta := &codec.LengthBasedPacketMeta{
+		TotalLen:  uint32(8 + header.Len() + body.Len()),
+		HeaderLen: uint32(header.Len()),
+	}
+	return codec.LengthBasedPacket{Meta: meta, Header: header, Body: body}
+}
+
+func Test_SignFilter(t *testing.T) {
+	key := []byte("secret")
+
+	convey.Convey("Signed packet should be verified and restored", t, func() {
+		sender := NewSignFilter(key, time.Minute, 64)
+		receiver := NewSignFilter(key, time.Minute, 64)
+		p := newTestPacket([]byte("extra"), []byte("pay 100"))
+
+		signed, err := sender.OnWrite(nil, p)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(signed), convey.ShouldEqual, 1)
+		lbp := signed[0].(codec.LengthBasedPacket)
+		convey.So(lbp.Meta.TotalLen, convey.ShouldEqual, p.Meta.TotalLen+signTrailerLen)
+		convey.So(len(p.Header.Extra), convey.ShouldEqual, 5)
+
+		//经过编码后再解码
+		bs, _ := lbp.Encode(binary.BigEndian)
+		decoded := codec.NewLengthBasedPacketFromBinary(binary.BigEndian, bs)
+
+		got, err := receiver.OnRead(nil, *decoded)
+		convey.So(err, convey.ShouldBeNil)
+		gp := got.(codec.LengthBasedPacket)
+		convey.So(bytes.Equal(gp.Header.Extra, []byte("extra")), convey.ShouldBeTrue)
+		convey.So(bytes.Equal(gp.Body.Data, []byte("pay 100")), convey.ShouldBeTrue)
+		convey.So(gp.Meta.TotalLen, convey.ShouldEqual, p.Meta.TotalLen)
+		convey.So(gp.Meta.HeaderLen, convey.ShouldEqual, p.Meta.HeaderLen)
+
+		//重放
+		_, err = receiver.OnRead(nil, *decoded)
+		convey.So(err, convey.ShouldEqual, ReplayedNonceError)
+	})
+
+	convey.Convey("Tampered or unsigned packet should be rejected", t, func() {
+		sender := NewSignFilter(key, time.Minute, 64)
+		receiver := NewSignFilter(key, time.Minute, 64)
+
+		signed, _ := sender.OnWrite(nil, newTestPacket(nil, []byte("pay 100")))
+		lbp := signed[0].(codec.LengthBasedPacket)
+		lbp.Body.Data = []byte("pay 999")
+		_, err := receiver.OnRead(nil, lbp)
+		convey.So(err, convey.ShouldEqual, BadSignatureError)
+
+		_, err = receiver.OnRead(nil, newTestPacket(nil, []byte("pay 100")))
+		convey.So(err, convey.ShouldEqual, MissingSignatureError)
+
+		other := NewSignFilter([]byte("other"), time.Minute, 64)
+		signed, _ = other.OnWrite(nil, newTestPacket(nil, []byte("pay 100")))
+		_, err = receiver.OnRead(nil, signed[0])
+		convey.So(err, convey.ShouldEqual, BadSignatureError)
+	})
+
+	convey.Convey("Reflected packets and packets from another session should be rejected", t, func() {
+		client := NewSignFilter(key, 0, 64)
+		server := NewSignFilter(key, 0, 64)
+		other := NewSignFilter(key, 0, 64)
+
+		signed, _ := client.OnWrite(nil, newTestPacket(nil, []byte("pay 100")))
+		_, err := client.OnRead(nil, signed[0])
+		convey.So(err, convey.ShouldEqual, ReflectedPacketError)
+		_, err = server.OnRead(nil, signed[0])
+		convey.So(err, convey.ShouldBeNil)
+
+		//其他session的nonce更大也不能混入
+		for i := 0; i < 5; i++ {
+			signed, _ = other.OnWrite(nil, newTestPacket(nil, []byte("pay 100")))
+		}
+		_, err = server.OnRead(nil, signed[0])
+		convey.So(err, convey.ShouldEqual, SenderMismatchError)
+	})
+
+	convey.Convey("Stale packet should be rejected", t, func() {
+		sender := NewSignFilter(key, time.Minute, 64)
+		receiver := NewSignFilter(key, time.Millisecond, 64)
+
+		signed, _ := sender.OnWrite(nil, newTestPacket(nil, []byte("pay 100")))
+		time.Sleep(5 * time.Millisecond)
+		_, err := receiver.OnRead(nil, signed[0])
+		convey.So(err, convey.ShouldEqual, StaleTimestampError)
+	})
+}
+
+func Test_SignFilterDirectIO(t *testing.T) {
+	convey.Convey("ReadMessage and WriteMessage should go through the filters", t, func() {
+		listener, _ := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		defer listener.Close()
+		accepted := make(chan *net.TCPConn, 1)
+		go func() {
+			conn, _ := listener.AcceptTCP()
+			accepted <- conn
+		}()
+		conn, _ := net.DialTCP("tcp4", nil, listener.Addr().(*net.TCPAddr))
+
+		key := []byte("secret")
+		lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		sender := NewSession(conn, lbc, config.NewDefaultGottyConfig(), nil)
+		sender.AddFilter(NewSignFilter(key, time.Minute, 64))
+		receiver := NewSession(<-accepted, lbc, config.NewDefaultGottyConfig(), nil)
+		receiver.AddFilter(NewSignFilter(key, time.Minute, 64))
+		defer sender.Close()
+		defer receiver.Close()
+		go sender.WriteMessage()
+		go receiver.ReadMessage()
+
+		convey.So(sender.Write(newTestPacket([]byte("extra"), []byte("pay 100"))), convey.ShouldBeNil)
+		select {
+		case p := <-receiver.ReadChannel:
+			gp := p.(codec.LengthBasedPacket)
+			convey.So(string(gp.Header.Extra), convey.ShouldEqual, "extra")
+			convey.So(string(gp.Body.Data), convey.ShouldEqual, "pay 100")
+		case <-time.After(time.Second):
+			t.Fatal("signed packet was not delivered")
+		}
+	})
+}
+
+func Test_ReplayWindow(t *testing.T) {
+	convey.Convey("Replay window should reject seen and too old nonces", t, func() {
+		w := newReplayWindow(128)
+		convey.So(w.check(0), convey.ShouldBeFalse)
+		convey.So(w.check(1), convey.ShouldBeTrue)
+		convey.So(w.check(1), convey.ShouldBeFalse)
+		convey.So(w.check(3), convey.ShouldBeTrue)
+		convey.So(w.check(2), convey.ShouldBeTrue)
+		convey.So(w.check(2), convey.ShouldBeFalse)
+
+		convey.So(w.check(100), convey.ShouldBeTrue)
+		convey.So(w.check(3), convey.ShouldBeFalse)
+		convey.So(w.check(50), convey.ShouldBeTrue)
+
+		convey.So(w.check(200), convey.ShouldBeTrue)
+		convey.So(w.check(72), convey.ShouldBeFalse)
+		convey.So(w.check(73), convey.ShouldBeTrue)
+		convey.So(w.check(100), convey.ShouldBeFalse)
+
+		convey.So(w.check(1000), convey.ShouldBeTrue)
+		convey.So(w.check(999), convey.ShouldBeTrue)
+		convey.So(w.check(200), convey.ShouldBeFalse)
+	})
+}
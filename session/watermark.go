@@ -0,0 +1,163 @@
+package session
+
+import (
+	"github.com/sumory/gotty/codec"
+)
+
+//packetLen 包编码后的长度，无法获知时为0，只按包数统计
+func packetLen(p codec.Packet) int {
+	if sized, ok := p.(codec.Sized); ok {
+		return sized.Len()
+	}
+	return 0
+}
+
+//ReadPaused 是否因读水位暂停了读取
+func (session *Session) ReadPaused() bool {
+	session.readLock.Lock()
+	defer session.readLock.Unlock()
+	return session.readPaused
+}
+
+//PendingReads 已读入但未处理完的包数和字节数
+func (session *Session) PendingReads() (int, int) {
+	session.readLock.Lock()
+	defer session.readLock.Unlock()
+	return session.readPending, session.readBytes
+}
+
+//readHold 包进入ReadChannel前计入读水位，达到高水位时标记暂停
+func (session *Session) readHold(p codec.Packet) {
+	cfg := session.config
+	session.readLock.Lock()
+	session.readPending++
+	session.readBytes += packetLen(p)
+	paused := !session.readPaused &&
+		((cfg.ReadHighWatermark > 0 && session.readPending >= cfg.ReadHighWatermark) ||
+			(cfg.ReadHighWatermarkBytes > 0 && session.readBytes >= cfg.ReadHighWatermarkBytes))
+	if paused {
+		session.readPaused = true
+		session.readResume = make(chan struct{})
+	}
+	session.readLock.Unlock()
+
+	if paused {
+		session.fireEvent(Event{Type: EventReadPaused})
+	}
+}
+
+//readDone 包处理完或被丢弃后移出读水位，降到低水位时恢复读取，并归还零拷贝解码的帧缓冲
+func (session *Session) readDone(p codec.Packet) {
+	if r, ok := p.(codec.Releaser); ok {
+		r.Release()
+	}
+	cfg := session.config
+	session.readLock.Lock()
+	session.readPending--
+	session.readBytes -= packetLen(p)
+	resumed := session.readPaused &&
+		(cfg.ReadHighWatermark <= 0 || session.readPending <= cfg.ReadLowWatermark) &&
+		(cfg.ReadHighWatermarkBytes <= 0 || session.readBytes <= cfg.ReadLowWatermarkBytes)
+	if resumed {
+		session.readPaused = false
+		close(session.readResume)
+	}
+	session.readLock.Unlock()
+
+	if resumed {
+		session.fireEvent(Event{Type: EventReadResumed})
+	}
+}
+
+//waitReadable 暂停期间阻塞读协程，不再从socket读取，由TCP流控反压对端
+func (session *Session) waitReadable() {
+	session.readLock.Lock()
+	if !session.readPaused {
+		session.readLock.Unlock()
+		return
+	}
+	resume := session.readResume
+	session.readLock.Unlock()
+
+	select {
+	case <-resume:
+	case <-session.closeChan:
+	}
+}
+
+//IsWritable 写队列是否低于写高水位，为false时生产者应暂停写入，等待WritabilityChanged事件
+func (session *Session) IsWritable() bool {
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	return !session.writeBlocked
+}
+
+//QueuedWriteBytes 写队列中待写出的字节数
+func (session *Session) QueuedWriteBytes() int {
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	return session.writeQueued
+}
+
+//writeHold 包进入WriteChannel前计入写水位，并持有零拷贝解码的帧缓冲直到写出
+//处理函数可以直接回写收到的包，函数返回后帧缓冲不会在写出前被归还
+func (session *Session) writeHold(p codec.Packet) {
+	if session.holdWrite(p) {
+		session.fireEvent(Event{Type: EventWritabilityChanged})
+	}
+}
+
+//holdWrite 同writeHold，但不触发事件，返回可写状态是否改变，供持有锁的调用方在解锁后触发
+func (session *Session) holdWrite(p codec.Packet) bool {
+	retainFrame(p)
+	high := session.config.WriteHighWatermarkBytes
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	session.writeQueued += packetLen(p)
+	changed := !session.writeBlocked && high > 0 && session.writeQueued >= high
+	if changed {
+		session.writeBlocked = true
+	}
+	return changed
+}
+
+//writeDone 包写出或被丢弃后移出写水位，归还writeHold持有的帧缓冲
+func (session *Session) writeDone(p codec.Packet) {
+	if session.releaseWrite(p) {
+		session.fireEvent(Event{Type: EventWritabilityChanged})
+	}
+}
+
+//releaseWrite 同writeDone，但不触发事件，返回可写状态是否改变
+func (session *Session) releaseWrite(p codec.Packet) bool {
+	releaseFrame(p)
+	low := session.config.WriteLowWatermarkBytes
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	session.writeQueued -= packetLen(p)
+	changed := session.writeBlocked && session.writeQueued <= low
+	if changed {
+		session.writeBlocked = false
+	}
+	return changed
+}
+
+//retainFrame 增加零拷贝解码的包的帧缓冲引用
+func retainFrame(p codec.Packet) {
+	switch lbp := p.(type) {
+	case codec.LengthBasedPacket:
+		lbp.Retain()
+	case *codec.LengthBasedPacket:
+		lbp.Retain()
+	}
+}
+
+//releaseFrame 归还retainFrame增加的引用
+func releaseFrame(p codec.Packet) {
+	switch lbp := p.(type) {
+	case codec.LengthBasedPacket:
+		lbp.Release()
+	case *codec.LengthBasedPacket:
+		lbp.Release()
+	}
+}
@@ -0,0 +1,186 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/config"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//newStartedSession 建立本地连接并启动session，返回session和对端连接，c为nil时使用LengthBasedCodec
+func newStartedSession(cfg *config.GottyConfig, c codec.Codec, handler handlerFunc, events EventHandler) (*Session, *net.TCPConn) {
+	listener, _ := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	defer listener.Close()
+
+	accepted := make(chan *net.TCPConn, 1)
+	go func() {
+		conn, _ := listener.AcceptTCP()
+		accepted <- conn
+	}()
+	conn, _ := net.DialTCP("tcp4", nil, listener.Addr().(*net.TCPAddr))
+
+	if c == nil {
+		c = codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+	}
+	s := NewSession(<-accepted, c, cfg, handler)
+	s.SetEventHandler(events)
+	s.Start()
+	return s, conn
+}
+
+func Test_ReadWatermark(t *testing.T) {
+	convey.Convey("Reading should pause at the high watermark and resume at the low one", t, func() {
+		cfg := config.NewDefaultGottyConfig()
+		cfg.ReadHighWatermark = 8
+		cfg.ReadLowWatermark = 2
+
+		release := make(chan struct{})
+		var handled int32
+		handler := func(s *Session, p codec.Packet) {
+			<-release
+			atomic.AddInt32(&handled, 1)
+		}
+		paused := make(chan struct{}, 1)
+		resumed := make(chan struct{}, 1)
+		events := func(s *Session, e Event) {
+			//读取可能多次暂停和恢复，只需记录第一次
+			switch e.Type {
+			case EventReadPaused:
+				select {
+				case paused <- struct{}{}:
+				default:
+				}
+			case EventReadResumed:
+				select {
+				case resumed <- struct{}{}:
+				default:
+				}
+			}
+		}
+		s, peer := newStartedSession(cfg, nil, handler, events)
+		defer s.Close()
+		defer peer.Close()
+
+		lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		w := bufio.NewWriter(peer)
+		for i := 0; i < 20; i++ {
+			lbc.Write(w, newTestPacket(nil, []byte("payload")))
+		}
+
+		<-paused
+		convey.So(s.ReadPaused(), convey.ShouldBeTrue)
+		time.Sleep(20 * time.Millisecond)
+		packets, bytes := s.PendingReads()
+		convey.So(packets, convey.ShouldEqual, 8)
+		convey.So(bytes, convey.ShouldEqual, 8*newTestPacket(nil, []byte("payload")).Len())
+
+		close(release)
+		<-resumed
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt32(&handled) < 20 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		convey.So(atomic.LoadInt32(&handled), convey.ShouldEqual, 20)
+		convey.So(s.ReadPaused(), convey.ShouldBeFalse)
+		packets, bytes = s.PendingReads()
+		convey.So(packets, convey.ShouldEqual, 0)
+		convey.So(bytes, convey.ShouldEqual, 0)
+	})
+}
+
+func Test_WriteWatermark(t *testing.T) {
+	convey.Convey("Session should become unwritable above the high watermark", t, func() {
+		var changes int32
+		s := newTestSession(func(s *Session, p codec.Packet) {})
+		defer s.Close()
+		s.codec = codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		s.config.WriteHighWatermarkBytes = 100
+		s.config.WriteLowWatermarkBytes = 20
+		s.SetEventHandler(func(s *Session, e Event) {
+			if e.Type == EventWritabilityChanged {
+				atomic.AddInt32(&changes, 1)
+			}
+		})
+
+		p := newTestPacket(nil, []byte("payload"))
+		for i := 0; i < 4; i++ {
+			convey.So(s.Write(p), convey.ShouldBeNil)
+		}
+		convey.So(s.IsWritable(), convey.ShouldBeTrue)
+		convey.So(s.Write(p), convey.ShouldBeNil)
+		convey.So(s.IsWritable(), convey.ShouldBeFalse)
+		convey.So(s.QueuedWriteBytes(), convey.ShouldEqual, 5*p.Len())
+		convey.So(atomic.LoadInt32(&changes), convey.ShouldEqual, 1)
+
+		go s.WritePacket()
+		deadline := time.Now().Add(time.Second)
+		for s.QueuedWriteBytes() > 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		convey.So(s.IsWritable(), convey.ShouldBeTrue)
+		convey.So(s.QueuedWriteBytes(), convey.ShouldEqual, 0)
+		convey.So(atomic.LoadInt32(&changes), convey.ShouldEqual, 2)
+	})
+}
+
+func Test_ZeroCopyRelease(t *testing.T) {
+	convey.Convey("Zero-copy packets should be released after the handler returns", t, func() {
+		received := make(chan codec.LengthBasedPacket, 2)
+		handler := func(s *Session, p codec.Packet) {
+			lbp := p.(codec.LengthBasedPacket)
+			if string(lbp.Body.Data) != "drop" {
+				lbp.Retain()
+			}
+			received <- lbp
+		}
+		zc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableZeroCopy()
+		s, peer := newStartedSession(config.NewDefaultGottyConfig(), zc, handler, nil)
+		defer s.Close()
+		defer peer.Close()
+
+		w := bufio.NewWriter(peer)
+		zc.Write(w, newTestPacket(nil, []byte("drop")))
+		//两个包大小不同，取自不同的池，释放的帧不会被另一个包复用
+		keep := strings.Repeat("k", 200)
+		zc.Write(w, newTestPacket(nil, []byte(keep)))
+
+		//默认的分发每个包一个协程，到达顺序不确定
+		dropped, kept := <-received, <-received
+		if string(dropped.Body.Data) != "drop" {
+			dropped, kept = kept, dropped
+		}
+		deadline := time.Now().Add(time.Second)
+		for packets, _ := s.PendingReads(); packets > 0 && time.Now().Before(deadline); packets, _ = s.PendingReads() {
+			time.Sleep(5 * time.Millisecond)
+		}
+		convey.So(func() { dropped.Retain() }, convey.ShouldPanic)
+		convey.So(string(kept.Body.Data), convey.ShouldEqual, keep)
+		kept.Release()
+	})
+
+	convey.Convey("Zero-copy packets written back by the handler should stay valid until written", t, func() {
+		zc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil).EnableZeroCopy()
+		var in bytes.Buffer
+		zc.Write(bufio.NewWriter(&in), newTestPacket(nil, []byte("echo")))
+		p, err := zc.Read(bufio.NewReader(&in))
+		convey.So(err, convey.ShouldBeNil)
+		lbp := p.(codec.LengthBasedPacket)
+
+		//没有启动写协程，包停在写队列中
+		s := &Session{config: config.NewDefaultGottyConfig(), WriteChannel: make(chan codec.Packet, 1), closeChan: make(chan struct{})}
+		convey.So(s.Write(p), convey.ShouldBeNil)
+		lbp.Release() //处理函数返回
+		convey.So(string(lbp.Body.Data), convey.ShouldEqual, "echo")
+		convey.So(func() { lbp.Retain().Release() }, convey.ShouldNotPanic)
+
+		s.writeDone(<-s.WriteChannel)
+		convey.So(func() { lbp.Retain() }, convey.ShouldPanic)
+	})
+}
@@ -0,0 +1,182 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/session"
+	log "github.com/sumory/log4go"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPartialTimeout = 10 * time.Minute
+	defaultOfferWorkers   = 16 //同时处理的传输请求个数上限
+)
+
+//Sink 接收方的文件存储，校验时需要回读已写入的数据，*os.File即可满足
+type Sink interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+//ProgressFunc 进度回调，done为已确认的字节数
+type ProgressFunc func(offer Offer, done int64)
+
+//OfferHandler 接收方收到新的传输请求时调用，返回error表示拒绝
+type OfferHandler func(s *session.Session, offer Offer) (Sink, error)
+
+//CompleteHandler 接收方传输结束时调用，err为nil表示校验通过
+type CompleteHandler func(s *session.Session, offer Offer, sink Sink, err error)
+
+//PeerFunc 返回session对端的身份，只有同一身份的连接才能续传未完成的传输
+type PeerFunc func(s *session.Session) string
+
+//RemoteHost 以对端的IP为身份，Manager的默认PeerFunc
+func RemoteHost(s *session.Session) string {
+	host, _, err := net.SplitHostPort(s.RemoteAddr())
+	if err != nil {
+		return s.RemoteAddr()
+	}
+	return host
+}
+
+//Manager 文件传输管理器，同时负责发送和接收
+//通过FilterFactory挂到session上，传输相关的包在过滤器中被消费，不会进入业务handler
+//接收方按对端身份和文件sha256保存未完成的传输，同一对端断线重连后对同一文件重新发起请求即可从已确认的偏移续传
+//请求和数据块的处理都在单独的协程中进行，不占用session的读协程，同时处理的请求过多时拒绝新的请求
+type Manager struct {
+	chunkSize      int           //数据块大小
+	window         int           //未确认的数据块个数上限
+	timeout        time.Duration //等待对方响应的超时时间
+	retries        int           //连续超时重传次数上限
+	partialTimeout time.Duration //未完成的传输多久没有数据块后丢弃
+
+	onOffer    OfferHandler
+	onComplete CompleteHandler
+	onProgress ProgressFunc
+	peer       PeerFunc
+	offers     chan struct{} //处理中的传输请求，满时拒绝新的请求
+
+	lock     sync.Mutex
+	nextId   uint32
+	outgoing map[uint32]*Transfer
+	incoming map[incomingKey]*incoming
+	partials map[partialKey]*incoming
+}
+
+//NewManager 新建文件传输管理器
+func NewManager(chunkSize, window int, timeout time.Duration) *Manager {
+	return &Manager{
+		chunkSize:      chunkSize,
+		window:         window,
+		timeout:        timeout,
+		retries:        3,
+		partialTimeout: defaultPartialTimeout,
+		peer:           RemoteHost,
+		offers:         make(chan struct{}, defaultOfferWorkers),
+		outgoing:       make(map[uint32]*Transfer),
+		incoming:       make(map[incomingKey]*incoming),
+		partials:       make(map[partialKey]*incoming),
+	}
+}
+
+//SetPeerFunc 设置识别对端身份的函数，默认为RemoteHost，有登录等认证时应使用认证得到的身份
+func (m *Manager) SetPeerFunc(f PeerFunc) {
+	m.peer = f
+}
+
+//SetPartialTimeout 设置未完成的传输保留多久，超过该时间没有收到数据块时丢弃，
+//并以TimeoutError调用CompleteHandler，默认10分钟
+func (m *Manager) SetPartialTimeout(d time.Duration) {
+	m.partialTimeout = d
+}
+
+//SetOfferHandler 设置接收请求的处理函数
+func (m *Manager) SetOfferHandler(h OfferHandler) {
+	m.onOffer = h
+}
+
+//SetCompleteHandler 设置接收完成的处理函数
+func (m *Manager) SetCompleteHandler(h CompleteHandler) {
+	m.onComplete = h
+}
+
+//SetProgressHandler 设置接收进度回调
+func (m *Manager) SetProgressHandler(h ProgressFunc) {
+	m.onProgress = h
+}
+
+//FilterFactory 返回挂在session上的过滤器工厂
+func (m *Manager) FilterFactory() session.FilterFactory {
+	return func(s *session.Session) session.Filter {
+		return &filter{m: m}
+	}
+}
+
+//write 写出控制包或数据块，写队列满时等待，以免挤占其他流量
+func (m *Manager) write(s *session.Session, p codec.Packet) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	switch err := s.WriteContext(ctx, p); err {
+	case nil:
+		return nil
+	case session.SessionClosedError:
+		return SessionClosedError
+	case context.DeadlineExceeded:
+		return TimeoutError
+	default:
+		return err
+	}
+}
+
+//filter 拦截文件传输相关的包
+type filter struct {
+	m *Manager
+}
+
+func (f *filter) OnRead(s *session.Session, p codec.Packet) (codec.Packet, error) {
+	lbp, ok := p.(codec.LengthBasedPacket)
+	if !ok {
+		return p, nil
+	}
+	switch lbp.Header.Operation {
+	case codec.OperationFileOffer:
+		select {
+		case f.m.offers <- struct{}{}:
+		default:
+			return nil, f.m.rejectBusy(s, lbp)
+		}
+		go func() {
+			defer func() { <-f.m.offers }()
+			if err := f.m.handleOffer(s, lbp); err != nil {
+				log.Warn("file transfer offer failed, remoteAddr: %s, err: %s", s.RemoteAddr(), err)
+			}
+		}()
+		return nil, nil
+	case codec.OperationFileChunk:
+		return nil, f.m.handleChunk(s, lbp)
+	case codec.OperationFileAccept, codec.OperationFileReject,
+		codec.OperationFileAck, codec.OperationFileResult:
+		return nil, f.m.handleControl(s, lbp)
+	}
+	return p, nil
+}
+
+func (f *filter) OnWrite(s *session.Session, p codec.Packet) ([]codec.Packet, error) {
+	return []codec.Packet{p}, nil
+}
+
+//checksum 计算文件的sha256
+func checksum(r io.ReaderAt, size int64) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
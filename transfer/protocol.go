@@ -0,0 +1,158 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/sumory/gotty/codec"
+)
+
+//各控制包的定长字段放在Header.Extra中，变长数据(文件名、数据块、原因)放在Body中
+//  offer:  id(4) + size(8) + sha256(32), body: 文件名
+//  accept: id(4) + offset(8)
+//  reject: id(4), body: 原因
+//  chunk:  id(4) + offset(8), body: 数据
+//  ack:    id(4) + offset(8)
+//  result: id(4) + ok(1), body: 原因
+
+// Errors
+var (
+	InvalidPacketError    = errors.New("Invalid file transfer packet")
+	UnknownTransferError  = errors.New("Unknown file transfer")
+	RejectedError         = errors.New("File transfer rejected")
+	ChecksumMismatchError = errors.New("File checksum mismatch")
+	TimeoutError          = errors.New("File transfer timeout")
+	SessionClosedError    = errors.New("Session closed during file transfer")
+	BusyError             = errors.New("Too many pending file transfer offers")
+)
+
+//Offer 文件传输请求
+type Offer struct {
+	Id     uint32
+	Name   string
+	Size   int64
+	Sha256 [sha256.Size]byte
+}
+
+//control 发送方收到的控制信息
+type control struct {
+	op     uint16
+	offset int64
+	ok     bool
+	reason string
+}
+
+func newPacket(op uint16, extra, data []byte) codec.LengthBasedPacket {
+	header := &codec.LengthBasedPacketHeader{
+		Operation: op,
+		Extra:     extra,
+	}
+	body := &codec.LengthBasedPacketBody{
+		Data: data,
+	}
+	meta := &codec.LengthBasedPacketMeta{
+		TotalLen:  uint32(8 + header.Len() + body.Len()),
+		HeaderLen: uint32(header.Len()),
+	}
+	return codec.LengthBasedPacket{Meta: meta, Header: header, Body: body}
+}
+
+func idOffsetExtra(id uint32, offset int64) []byte {
+	extra := make([]byte, 12)
+	binary.BigEndian.PutUint32(extra[0:4], id)
+	binary.BigEndian.PutUint64(extra[4:12], uint64(offset))
+	return extra
+}
+
+func newOfferPacket(offer Offer) codec.LengthBasedPacket {
+	extra := make([]byte, 12+sha256.Size)
+	binary.BigEndian.PutUint32(extra[0:4], offer.Id)
+	binary.BigEndian.PutUint64(extra[4:12], uint64(offer.Size))
+	copy(extra[12:], offer.Sha256[:])
+	return newPacket(codec.OperationFileOffer, extra, []byte(offer.Name))
+}
+
+func newAcceptPacket(id uint32, offset int64) codec.LengthBasedPacket {
+	return newPacket(codec.OperationFileAccept, idOffsetExtra(id, offset), nil)
+}
+
+func newRejectPacket(id uint32, reason string) codec.LengthBasedPacket {
+	extra := make([]byte, 4)
+	binary.BigEndian.PutUint32(extra, id)
+	return newPacket(codec.OperationFileReject, extra, []byte(reason))
+}
+
+func newChunkPacket(id uint32, offset int64, data []byte) codec.LengthBasedPacket {
+	return newPacket(codec.OperationFileChunk, idOffsetExtra(id, offset), data)
+}
+
+func newAckPacket(id uint32, offset int64) codec.LengthBasedPacket {
+	return newPacket(codec.OperationFileAck, idOffsetExtra(id, offset), nil)
+}
+
+func newResultPacket(id uint32, err error) codec.LengthBasedPacket {
+	extra := make([]byte, 5)
+	binary.BigEndian.PutUint32(extra, id)
+	var reason []byte
+	if err == nil {
+		extra[4] = 1
+	} else {
+		reason = []byte(err.Error())
+	}
+	return newPacket(codec.OperationFileResult, extra, reason)
+}
+
+func parseOffer(p codec.LengthBasedPacket) (Offer, error) {
+	extra := p.Header.Extra
+	if len(extra) < 12+sha256.Size {
+		return Offer{}, InvalidPacketError
+	}
+	offer := Offer{
+		Id:   binary.BigEndian.Uint32(extra[0:4]),
+		Size: int64(binary.BigEndian.Uint64(extra[4:12])),
+		Name: string(p.Body.Data),
+	}
+	if offer.Size < 0 {
+		return Offer{}, InvalidPacketError
+	}
+	copy(offer.Sha256[:], extra[12:])
+	return offer, nil
+}
+
+//parseIdOffset 解析accept、chunk、ack的公共字段
+func parseIdOffset(p codec.LengthBasedPacket) (uint32, int64, error) {
+	extra := p.Header.Extra
+	if len(extra) < 12 {
+		return 0, 0, InvalidPacketError
+	}
+	offset := int64(binary.BigEndian.Uint64(extra[4:12]))
+	if offset < 0 {
+		return 0, 0, InvalidPacketError
+	}
+	return binary.BigEndian.Uint32(extra[0:4]), offset, nil
+}
+
+//parseControl 解析发送方需要处理的控制包
+func parseControl(p codec.LengthBasedPacket) (uint32, control, error) {
+	c := control{op: p.Header.Operation}
+	switch c.op {
+	case codec.OperationFileAccept, codec.OperationFileAck:
+		id, offset, err := parseIdOffset(p)
+		c.offset = offset
+		return id, c, err
+	case codec.OperationFileReject:
+		if len(p.Header.Extra) < 4 {
+			return 0, c, InvalidPacketError
+		}
+		c.reason = string(p.Body.Data)
+		return binary.BigEndian.Uint32(p.Header.Extra), c, nil
+	case codec.OperationFileResult:
+		if len(p.Header.Extra) < 5 {
+			return 0, c, InvalidPacketError
+		}
+		c.ok = p.Header.Extra[4] == 1
+		c.reason = string(p.Body.Data)
+		return binary.BigEndian.Uint32(p.Header.Extra), c, nil
+	}
+	return 0, c, InvalidPacketError
+}
@@ -0,0 +1,292 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/session"
+	log "github.com/sumory/log4go"
+	"sync"
+	"time"
+)
+
+type incomingKey struct {
+	sessionId uint64
+	id        uint32
+}
+
+//partialKey 未完成的传输只能由同一对端续传
+type partialKey struct {
+	peer   string
+	sha256 [sha256.Size]byte
+}
+
+//incoming 一次文件接收，断线后保留以便续传
+//数据块由receive协程写入，文件写入、校验和回调都不占用session的读协程
+type incoming struct {
+	lock     sync.Mutex //断线续传时新旧连接可能同时访问
+	offer    Offer
+	sink     Sink
+	acked    int64 //已连续写入的字节数
+	key      incomingKey
+	partial  partialKey
+	session  *session.Session //当前传输所在的session
+	chunks   chan chunk       //待写入的数据块
+	done     chan struct{}    //传输结束或过期时close
+	finished bool
+}
+
+//chunk 待写入的数据块
+type chunk struct {
+	s      *session.Session
+	offset int64
+	data   []byte
+}
+
+//handleOffer 处理传输请求，同一对端已有同一文件的未完成传输时从已确认的位置续传
+//会调用OfferHandler，在单独的协程中执行
+func (m *Manager) handleOffer(s *session.Session, p codec.LengthBasedPacket) error {
+	offer, err := parseOffer(p)
+	if err != nil {
+		return err
+	}
+	partial := partialKey{peer: m.peer(s), sha256: offer.Sha256}
+
+	m.lock.Lock()
+	in, ok := m.partials[partial]
+	if ok && in.offer.Size == offer.Size {
+		delete(m.incoming, in.key)
+	} else {
+		ok = false
+	}
+	m.lock.Unlock()
+
+	if !ok {
+		if m.onOffer == nil {
+			return m.write(s, newRejectPacket(offer.Id, "no offer handler"))
+		}
+		sink, err := m.onOffer(s, offer)
+		if err != nil {
+			return m.write(s, newRejectPacket(offer.Id, err.Error()))
+		}
+		in = &incoming{
+			sink:    sink,
+			partial: partial,
+			chunks:  make(chan chunk, 2*m.window),
+			done:    make(chan struct{}),
+		}
+	}
+
+	in.lock.Lock()
+	if in.finished {
+		//查找之后恰好过期
+		in.lock.Unlock()
+		return m.write(s, newRejectPacket(offer.Id, TimeoutError.Error()))
+	}
+	in.offer = offer
+	in.key = incomingKey{sessionId: s.ID(), id: offer.Id}
+	in.session = s
+	acked := in.acked
+	m.lock.Lock()
+	m.partials[partial] = in
+	m.incoming[in.key] = in
+	m.lock.Unlock()
+	in.lock.Unlock()
+	if !ok {
+		go m.receive(in)
+	}
+
+	log.Info("file transfer accepted, name: %s, size: %d, offset: %d", offer.Name, offer.Size, acked)
+	if err := m.write(s, newAcceptPacket(offer.Id, acked)); err != nil {
+		return err
+	}
+	if acked == offer.Size {
+		return m.complete(in, nil)
+	}
+	return nil
+}
+
+//rejectBusy 同时处理的请求过多时在读协程中直接拒绝，写队列满时放弃回复，发送方超时后会重试
+func (m *Manager) rejectBusy(s *session.Session, p codec.LengthBasedPacket) error {
+	offer, err := parseOffer(p)
+	if err != nil {
+		return err
+	}
+	log.Warn("file transfer offer rejected, remoteAddr: %s, err: %s", s.RemoteAddr(), BusyError)
+	s.Write(newRejectPacket(offer.Id, BusyError.Error()))
+	return nil
+}
+
+//handleChunk 将数据块交给receive协程，队列满时丢弃，发送方超时后会从已确认的位置重传
+func (m *Manager) handleChunk(s *session.Session, p codec.LengthBasedPacket) error {
+	id, offset, err := parseIdOffset(p)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	in, ok := m.incoming[incomingKey{sessionId: s.ID(), id: id}]
+	m.lock.Unlock()
+	if !ok {
+		return UnknownTransferError
+	}
+
+	select {
+	case in.chunks <- chunk{s: s, offset: offset, data: p.Body.Data}:
+	default:
+		log.Warn("file transfer chunk dropped, id: %d, offset: %d", id, offset)
+	}
+	return nil
+}
+
+//receive 依次写入数据块，session断开后只保留续传所需的状态，超过partialTimeout没有数据块时丢弃传输
+func (m *Manager) receive(in *incoming) {
+	idle := time.NewTimer(m.partialTimeout)
+	defer idle.Stop()
+
+	var detached *session.Session //已处理过断开的session
+	for {
+		in.lock.Lock()
+		s := in.session
+		in.lock.Unlock()
+		var closed <-chan struct{}
+		if s != detached {
+			closed = s.Done()
+		}
+
+		select {
+		case c := <-in.chunks:
+			m.writeChunk(in, c)
+		case <-closed:
+			detached = s
+			m.detach(in, s)
+			continue
+		case <-idle.C:
+			m.expire(in)
+			return
+		case <-in.done:
+			return
+		}
+		if !idle.Stop() {
+			<-idle.C
+		}
+		idle.Reset(m.partialTimeout)
+	}
+}
+
+//writeChunk 写入数据块，只接受紧接已确认位置的数据，乱序或重复的块只回复当前确认位置
+//只有receive协程写入数据块，写入sink和回复确认时不持有in.lock，以免阻塞续传请求
+func (m *Manager) writeChunk(in *incoming, c chunk) {
+	in.lock.Lock()
+	if in.finished || in.session != c.s {
+		//已结束或已在新连接上续传
+		in.lock.Unlock()
+		return
+	}
+	accept := c.offset == in.acked && len(c.data) > 0 && c.offset+int64(len(c.data)) <= in.offer.Size
+	in.lock.Unlock()
+
+	var err error
+	if accept {
+		_, err = in.sink.WriteAt(c.data, c.offset)
+	}
+
+	in.lock.Lock()
+	if in.finished || in.session != c.s {
+		in.lock.Unlock()
+		return
+	}
+	if accept && err == nil {
+		in.acked += int64(len(c.data))
+	}
+	offer, acked := in.offer, in.acked
+	in.lock.Unlock()
+
+	if err != nil {
+		m.complete(in, err)
+		return
+	}
+	if accept && m.onProgress != nil {
+		m.onProgress(offer, acked)
+	}
+	if err := m.write(c.s, newAckPacket(offer.Id, acked)); err != nil {
+		log.Warn("file transfer ack failed, name: %s, err: %s", offer.Name, err)
+		return
+	}
+	if acked == offer.Size {
+		m.complete(in, nil)
+	}
+}
+
+//detach session断开后移除按session查找的记录，partials保留到续传或过期
+func (m *Manager) detach(in *incoming, s *session.Session) {
+	in.lock.Lock()
+	defer in.lock.Unlock()
+	if in.session != s {
+		return
+	}
+	m.lock.Lock()
+	if m.incoming[in.key] == in {
+		delete(m.incoming, in.key)
+	}
+	m.lock.Unlock()
+}
+
+//finish 结束传输并移除所有记录，调用时需持有in.lock，已结束时返回false
+func (m *Manager) finish(in *incoming) bool {
+	if in.finished {
+		return false
+	}
+	in.finished = true
+	close(in.done)
+
+	m.lock.Lock()
+	if m.incoming[in.key] == in {
+		delete(m.incoming, in.key)
+	}
+	if m.partials[in.partial] == in {
+		delete(m.partials, in.partial)
+	}
+	m.lock.Unlock()
+	return true
+}
+
+//expire 丢弃长时间没有进展的传输，以TimeoutError通知CompleteHandler以便清理sink
+func (m *Manager) expire(in *incoming) {
+	in.lock.Lock()
+	finished := m.finish(in)
+	s, offer, acked := in.session, in.offer, in.acked
+	in.lock.Unlock()
+	if !finished {
+		return
+	}
+	log.Warn("file transfer expired, name: %s, acked: %d", offer.Name, acked)
+	if m.onComplete != nil {
+		m.onComplete(s, offer, in.sink, TimeoutError)
+	}
+}
+
+//complete 校验文件并通知发送方，无论成功与否都不再保留该传输，调用时不能持有in.lock
+func (m *Manager) complete(in *incoming, err error) error {
+	in.lock.Lock()
+	finished := m.finish(in)
+	s, offer := in.session, in.offer
+	in.lock.Unlock()
+	if !finished {
+		return nil
+	}
+
+	if err == nil {
+		var sum [sha256.Size]byte
+		if sum, err = checksum(in.sink, offer.Size); err == nil && sum != offer.Sha256 {
+			err = ChecksumMismatchError
+		}
+	}
+	if err != nil {
+		log.Warn("file transfer failed, name: %s, err: %s", offer.Name, err)
+	}
+
+	if m.onComplete != nil {
+		m.onComplete(s, offer, in.sink, err)
+	}
+	return m.write(s, newResultPacket(offer.Id, err))
+}
@@ -0,0 +1,213 @@
+package transfer
+
+import (
+	"errors"
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/session"
+	log "github.com/sumory/log4go"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+//Transfer 一次文件发送
+type Transfer struct {
+	Offer       Offer
+	StartOffset int64 //接收方同意的起始偏移，大于0表示续传
+
+	session  *session.Session
+	file     io.ReaderAt
+	progress ProgressFunc
+	controls chan control
+	done     chan struct{}
+	acked    int64
+	err      error
+}
+
+//Send 向session发送文件，sha256在发送前计算
+//同一文件在断线后重新调用Send，接收方会从已确认的位置续传
+func (m *Manager) Send(s *session.Session, name string, file io.ReaderAt, size int64, progress ProgressFunc) (*Transfer, error) {
+	sum, err := checksum(file, size)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Transfer{
+		Offer: Offer{
+			Id:     atomic.AddUint32(&m.nextId, 1),
+			Name:   name,
+			Size:   size,
+			Sha256: sum,
+		},
+		session:  s,
+		file:     file,
+		progress: progress,
+		controls: make(chan control, 2*m.window+4),
+		done:     make(chan struct{}),
+	}
+
+	m.lock.Lock()
+	m.outgoing[t.Offer.Id] = t
+	m.lock.Unlock()
+
+	go m.run(t)
+	return t, nil
+}
+
+//Wait 等待传输结束，返回nil表示接收方校验通过
+func (t *Transfer) Wait() error {
+	<-t.done
+	return t.err
+}
+
+//Done 传输结束时关闭的channel
+func (t *Transfer) Done() <-chan struct{} {
+	return t.done
+}
+
+//Acked 已被接收方确认的字节数
+func (t *Transfer) Acked() int64 {
+	return atomic.LoadInt64(&t.acked)
+}
+
+//await 等待接收方的控制包
+func (t *Transfer) await(timeout time.Duration) (control, error) {
+	select {
+	case c := <-t.controls:
+		return c, nil
+	case <-time.After(timeout):
+		if t.session.Closed() {
+			return control{}, SessionClosedError
+		}
+		return control{}, TimeoutError
+	}
+}
+
+func (m *Manager) run(t *Transfer) {
+	defer func() {
+		m.lock.Lock()
+		delete(m.outgoing, t.Offer.Id)
+		m.lock.Unlock()
+		close(t.done)
+	}()
+
+	if t.err = m.write(t.session, newOfferPacket(t.Offer)); t.err != nil {
+		return
+	}
+	for {
+		c, err := t.await(m.timeout)
+		if err != nil {
+			t.err = err
+			return
+		}
+		if c.op == codec.OperationFileReject {
+			t.err = errors.New(RejectedError.Error() + ": " + c.reason)
+			return
+		}
+		if c.op == codec.OperationFileAccept {
+			if c.offset > t.Offer.Size {
+				c.offset = t.Offer.Size
+			}
+			t.StartOffset = c.offset
+			atomic.StoreInt64(&t.acked, c.offset)
+			break
+		}
+	}
+
+	if t.err = m.sendChunks(t); t.err != nil {
+		return
+	}
+
+	//等待接收方校验结果
+	for {
+		c, err := t.await(m.timeout)
+		if err != nil {
+			t.err = err
+			return
+		}
+		if c.op == codec.OperationFileResult {
+			if !c.ok {
+				t.err = errors.New(ChecksumMismatchError.Error() + ": " + c.reason)
+			}
+			return
+		}
+	}
+}
+
+//sendChunks 滑动窗口发送数据块，超时未确认时从已确认位置重传
+func (m *Manager) sendChunks(t *Transfer) error {
+	size := t.Offer.Size
+	acked := t.Acked()
+	next := acked
+	timeouts := 0
+	for acked < size {
+		for next < size && next-acked < int64(m.window*m.chunkSize) {
+			n := int64(m.chunkSize)
+			if size-next < n {
+				n = size - next
+			}
+			//数据块可能还在写队列中，不能复用缓冲区
+			data := make([]byte, n)
+			if _, err := t.file.ReadAt(data, next); err != nil && err != io.EOF {
+				return err
+			}
+			if err := m.write(t.session, newChunkPacket(t.Offer.Id, next, data)); err != nil {
+				return err
+			}
+			next += n
+		}
+
+		c, err := t.await(m.timeout)
+		if err == TimeoutError && timeouts < m.retries {
+			timeouts++
+			log.Warn("file transfer ack timeout, id: %d, acked: %d, retry: %d", t.Offer.Id, acked, timeouts)
+			next = acked
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch c.op {
+		case codec.OperationFileAck:
+			if c.offset > acked && c.offset <= size {
+				acked = c.offset
+				timeouts = 0
+				atomic.StoreInt64(&t.acked, acked)
+				if t.progress != nil {
+					t.progress(t.Offer, acked)
+				}
+			}
+		case codec.OperationFileResult:
+			//接收方提前结束
+			if c.ok {
+				return nil
+			}
+			return errors.New(ChecksumMismatchError.Error() + ": " + c.reason)
+		}
+	}
+	return nil
+}
+
+//handleControl 将接收方的控制包交给对应的发送任务
+func (m *Manager) handleControl(s *session.Session, p codec.LengthBasedPacket) error {
+	id, c, err := parseControl(p)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	t, ok := m.outgoing[id]
+	m.lock.Unlock()
+	if !ok || t.session != s {
+		return UnknownTransferError
+	}
+
+	select {
+	case t.controls <- c:
+	default:
+		//发送方处理不过来时丢弃，超时后会重传
+		log.Warn("file transfer control dropped, id: %d", id)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package transfer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/sumory/gotty/codec"
+	"github.com/sumory/gotty/config"
+	"github.com/sumory/gotty/session"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type memSink struct {
+	lock sync.Mutex
+	data []byte
+}
+
+func (ms *memSink) WriteAt(p []byte, off int64) (int, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	if end := int(off) + len(p); end > len(ms.data) {
+		ms.data = append(ms.data, make([]byte, end-len(ms.data))...)
+	}
+	return copy(ms.data[off:], p), nil
+}
+
+func (ms *memSink) ReadAt(p []byte, off int64) (int, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	if int(off) >= len(ms.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, ms.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (ms *memSink) bytes() []byte {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return ms.data
+}
+
+//newSessionPair 建立一条本地连接，返回发送端和接收端的session
+func newSessionPair(sender, receiver *Manager) (*session.Session, *session.Session) {
+	return newSessionPairWithHandler(sender, receiver, func(s *session.Session, p codec.Packet) {})
+}
+
+//newSessionPairWithHandler 接收端的业务包交给handler处理
+func newSessionPairWithHandler(sender, receiver *Manager, handler func(s *session.Session, p codec.Packet)) (*session.Session, *session.Session) {
+	listener, _ := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	defer listener.Close()
+
+	accepted := make(chan *net.TCPConn, 1)
+	go func() {
+		conn, _ := listener.AcceptTCP()
+		accepted <- conn
+	}()
+	conn, _ := net.DialTCP("tcp4", nil, listener.Addr().(*net.TCPAddr))
+
+	newSession := func(conn *net.TCPConn, m *Manager) *session.Session {
+		lbc := codec.NewLengthBasedCodec(binary.BigEndian, 64*1024, nil, nil)
+		s := session.NewSession(conn, lbc, config.NewDefaultGottyConfig(), handler)
+		s.AddFilter(m.FilterFactory()(s))
+		s.Start()
+		return s
+	}
+	return newSession(conn, sender), newSession(<-accepted, receiver)
+}
+
+func Test_Transfer(t *testing.T) {
+	file := bytes.Repeat([]byte("firmware-image-0123456789"), 40000)
+
+	convey.Convey("File should be transferred and verified", t, func() {
+		sender := NewManager(16*1024, 4, time.Second)
+		receiver := NewManager(16*1024, 4, time.Second)
+		sink := &memSink{}
+		var completeErr error
+		completed := make(chan struct{})
+		receiver.SetOfferHandler(func(s *session.Session, offer Offer) (Sink, error) {
+			return sink, nil
+		})
+		receiver.SetCompleteHandler(func(s *session.Session, offer Offer, sink Sink, err error) {
+			completeErr = err
+			close(completed)
+		})
+
+		s, _ := newSessionPair(sender, receiver)
+		defer s.Close()
+
+		var progress int64
+		tr, err := sender.Send(s, "fw.bin", bytes.NewReader(file), int64(len(file)), func(offer Offer, done int64) {
+			progress = done
+		})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(tr.Wait(), convey.ShouldBeNil)
+		<-completed
+		convey.So(completeErr, convey.ShouldBeNil)
+		convey.So(progress, convey.ShouldEqual, len(file))
+		convey.So(tr.StartOffset, convey.ShouldEqual, 0)
+		convey.So(bytes.Equal(sink.bytes(), file), convey.ShouldBeTrue)
+	})
+
+	convey.Convey("Rejected offer should fail the transfer", t, func() {
+		sender := NewManager(16*1024, 4, time.Second)
+		receiver := NewManager(16*1024, 4, time.Second)
+		receiver.SetOfferHandler(func(s *session.Session, offer Offer) (Sink, error) {
+			return nil, errors.New("disk full")
+		})
+
+		s, _ := newSessionPair(sender, receiver)
+		defer s.Close()
+
+		tr, _ := sender.Send(s, "fw.bin", bytes.NewReader(file), int64(len(file)), nil)
+		err := tr.Wait()
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(err.Error(), convey.ShouldContainSubstring, "disk full")
+	})
+
+	convey.Convey("Interrupted transfer should resume after reconnect", t, func() {
+		sender := NewManager(16*1024, 4, 300*time.Millisecond)
+		receiver := NewManager(16*1024, 4, 300*time.Millisecond)
+		sink := &memSink{}
+		var offers int32
+		receiver.SetOfferHandler(func(s *session.Session, offer Offer) (Sink, error) {
+			atomic.AddInt32(&offers, 1)
+			return sink, nil
+		})
+
+		s1, _ := newSessionPair(sender, receiver)
+		var once sync.Once
+		tr, _ := sender.Send(s1, "fw.bin", bytes.NewReader(file), int64(len(file)), func(offer Offer, done int64) {
+			if done >= int64(len(file))/3 {
+				once.Do(func() { go s1.Close() })
+			}
+		})
+		convey.So(tr.Wait(), convey.ShouldNotBeNil)
+
+		s2, _ := newSessionPair(sender, receiver)
+		defer s2.Close()
+		tr, _ = sender.Send(s2, "fw.bin", bytes.NewReader(file), int64(len(file)), nil)
+		convey.So(tr.Wait(), convey.ShouldBeNil)
+		convey.So(tr.StartOffset, convey.ShouldBeGreaterThan, 0)
+		convey.So(atomic.LoadInt32(&offers), convey.ShouldEqual, 1)
+		convey.So(bytes.Equal(sink.bytes(), file), convey.ShouldBeTrue)
+	})
+
+	convey.Convey("Another peer should not resume a partial transfer", t, func() {
+		sender := NewManager(16*1024, 4, 300*time.Millisecond)
+		receiver := NewManager(16*1024, 4, 300*time.Millisecond)
+		//每条连接视为不同的对端
+		receiver.SetPeerFunc(func(s *session.Session) string {
+			return fmt.Sprint(s.ID())
+		})
+		var offers int32
+		receiver.SetOfferHandler(func(s *session.Session, offer Offer) (Sink, error) {
+			atomic.AddInt32(&offers, 1)
+			return &memSink{}, nil
+		})
+
+		s1, _ := newSessionPair(sender, receiver)
+		var once sync.Once
+		tr, _ := sender.Send(s1, "fw.bin", bytes.NewReader(file), int64(len(file)), func(offer Offer, done int64) {
+			if done >= int64(len(file))/3 {
+				once.Do(func() { go s1.Close() })
+			}
+		})
+		convey.So(tr.Wait(), convey.ShouldNotBeNil)
+
+		s2, _ := newSessionPair(sender, receiver)
+		defer s2.Close()
+		tr, _ = sender.Send(s2, "fw.bin", bytes.NewReader(file), int64(len(file)), nil)
+		convey.So(tr.Wait(), convey.ShouldBeNil)
+		convey.So(tr.StartOffset, convey.ShouldEqual, 0)
+		convey.So(atomic.LoadInt32(&offers), convey.ShouldEqual, 2)
+	})
+
+	convey.Convey("Offers beyond the worker limit should be rejected", t, func() {
+		sender := NewManager(16*1024, 4, time.Second)
+		receiver := NewManager(16*1024, 4, time.Second)
+		receiver.offers = make(chan struct{}, 1)
+		release := make(chan struct{})
+		receiver.SetOfferHandler(func(s *session.Session, offer Offer) (Sink, error) {
+			<-release
+			return &memSink{}, nil
+		})
+
+		s, _ := newSessionPair(sender, receiver)
+		defer s.Close()
+		first, _ := sender.Send(s, "a.bin", bytes.NewReader(file), int64(len(file)), nil)
+		time.Sleep(50 * time.Millisecond)
+		second, _ := sender.Send(s, "b.bin", bytes.NewReader(file[1:]), int64(len(file)-1), nil)
+		err := second.Wait()
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(err.Error(), convey.ShouldContainSubstring, BusyError.Error())
+		close(release)
+		convey.So(first.Wait(), convey.ShouldBeNil)
+	})
+
+	convey.Convey("A slow sink should not block ordinary traffic", t, func() {
+		sender := NewManager(16*1024, 4, 5*time.Second)
+		receiver := NewManager(16*1024, 4, 5*time.Second)
+		receiver.SetOfferHandler(func(s *session.Session, offer Offer) (Sink, error) {
+			return &slowSink{delay: 100 * time.Millisecond}, nil
+		})
+		pings := make(chan struct{}, 1)
+		s, _ := newSessionPairWithHandler(sender, receiver, func(s *session.Session, p codec.Packet) {
+			pings <- struct{}{}
+		})
+		defer s.Close()
+
+		sender.Send(s, "fw.bin", bytes.NewReader(file), int64(len(file)), nil)
+		time.Sleep(50 * time.Millisecond)
+		start := time.Now()
+		header := &codec.LengthBasedPacketHeader{Sequence: 1, Operation: 1}
+		body := &codec.LengthBasedPacketBody{Data: []byte("ping")}
+		meta := &codec.LengthBasedPacketMeta{TotalLen: uint32(8 + header.Len() + body.Len()), HeaderLen: uint32(header.Len())}
+		s.Write(codec.LengthBasedPacket{Meta: meta, Header: header, Body: body})
+		<-pings
+		convey.So(time.Since(start), convey.ShouldBeLessThan, 50*time.Millisecond)
+	})
+
+	convey.Convey("An abandoned partial transfer should expire", t, func() {
+		sender := NewManager(16*1024, 4, 300*time.Millisecond)
+		receiver := NewManager(16*1024, 4, 300*time.Millisecond)
+		receiver.SetPartialTimeout(100 * time.Millisecond)
+		completed := make(chan error, 1)
+		receiver.SetOfferHandler(func(s *session.Session, offer Offer) (Sink, error) {
+			return &memSink{}, nil
+		})
+		receiver.SetCompleteHandler(func(s *session.Session, offer Offer, sink Sink, err error) {
+			completed <- err
+		})
+
+		s, _ := newSessionPair(sender, receiver)
+		var once sync.Once
+		tr, _ := sender.Send(s, "fw.bin", bytes.NewReader(file), int64(len(file)), func(offer Offer, done int64) {
+			once.Do(func() { go s.Close() })
+		})
+		convey.So(tr.Wait(), convey.ShouldNotBeNil)
+
+		select {
+		case err := <-completed:
+			convey.So(err, convey.ShouldEqual, TimeoutError)
+		case <-time.After(time.Second):
+			t.Fatal("partial transfer did not expire")
+		}
+		receiver.lock.Lock()
+		convey.So(len(receiver.partials), convey.ShouldEqual, 0)
+		convey.So(len(receiver.incoming), convey.ShouldEqual, 0)
+		receiver.lock.Unlock()
+	})
+}
+
+//slowSink 每次写入都很慢的存储
+type slowSink struct {
+	memSink
+	delay time.Duration
+}
+
+func (ss *slowSink) WriteAt(p []byte, off int64) (int, error) {
+	time.Sleep(ss.delay)
+	return ss.memSink.WriteAt(p, off)
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"errors"
+	"io"
+)
+
+// Errors
+var (
+	BitCountError = errors.New("Bit count must be between 0 and 64")
+)
+
+//BitOrder 字节内位的读写顺序
+type BitOrder int
+
+const (
+	MSBFirst BitOrder = iota //从字节的高位开始，先读写的位是字段的高位，多数网络协议采用
+	LSBFirst                 //从字节的低位开始，先读写的位是字段的低位，如deflate
+)
+
+func bitMask(n uint) uint64 {
+	if n >= 64 {
+		return ^uint64(0)
+	}
+	return 1<<n - 1
+}
+
+//BitReader 按位读取，底层可以是buffer.Buffer或其他io.ByteReader
+type BitReader struct {
+	r     io.ByteReader
+	order BitOrder
+	cur   byte
+	left  uint //cur中未读的位数
+}
+
+//NewBitReader 新建按位读取器
+func NewBitReader(r io.ByteReader, order BitOrder) *BitReader {
+	return &BitReader{r: r, order: order}
+}
+
+//ReadBits 读取n位，n为0~64
+//数据在字段中间耗尽时返回io.ErrUnexpectedEOF，一位都没读到时返回底层的错误
+func (br *BitReader) ReadBits(n uint) (uint64, error) {
+	if n > 64 {
+		return 0, BitCountError
+	}
+	var v uint64
+	var shift uint
+	for remain := n; remain > 0; {
+		if br.left == 0 {
+			c, err := br.r.ReadByte()
+			if err != nil {
+				if err == io.EOF && remain < n {
+					err = io.ErrUnexpectedEOF
+				}
+				return 0, err
+			}
+			br.cur, br.left = c, 8
+		}
+
+		k := remain
+		if k > br.left {
+			k = br.left
+		}
+		if br.order == MSBFirst {
+			bits := uint64(br.cur>>(br.left-k)) & bitMask(k)
+			v = v<<k | bits
+		} else {
+			bits := uint64(br.cur>>(8-br.left)) & bitMask(k)
+			v |= bits << shift
+			shift += k
+		}
+		br.left -= k
+		remain -= k
+	}
+	return v, nil
+}
+
+//ReadBit 读取一位
+func (br *BitReader) ReadBit() (bool, error) {
+	v, err := br.ReadBits(1)
+	return v == 1, err
+}
+
+//Align 丢弃当前字节中未读的位，下一次读取从新的字节开始
+func (br *BitReader) Align() {
+	br.left = 0
+}
+
+//BitWriter 按位写入，底层可以是buffer.Buffer或其他io.ByteWriter
+//写满一个字节才会写入底层，结束时需调用Flush写出不足一个字节的部分
+type BitWriter struct {
+	w     io.ByteWriter
+	order BitOrder
+	cur   byte
+	used  uint //cur中已写的位数
+}
+
+//NewBitWriter 新建按位写入器
+func NewBitWriter(w io.ByteWriter, order BitOrder) *BitWriter {
+	return &BitWriter{w: w, order: order}
+}
+
+//WriteBits 写入v的低n位，n为0~64
+func (bw *BitWriter) WriteBits(v uint64, n uint) error {
+	if n > 64 {
+		return BitCountError
+	}
+	for remain := n; remain > 0; {
+		k := remain
+		if k > 8-bw.used {
+			k = 8 - bw.used
+		}
+		if bw.order == MSBFirst {
+			bits := byte((v >> (remain - k)) & bitMask(k))
+			bw.cur |= bits << (8 - bw.used - k)
+		} else {
+			bits := byte((v >> (n - remain)) & bitMask(k))
+			bw.cur |= bits << bw.used
+		}
+		bw.used += k
+		remain -= k
+
+		if bw.used == 8 {
+			if err := bw.w.WriteByte(bw.cur); err != nil {
+				return err
+			}
+			bw.cur, bw.used = 0, 0
+		}
+	}
+	return nil
+}
+
+//WriteBit 写入一位
+func (bw *BitWriter) WriteBit(bit bool) error {
+	if bit {
+		return bw.WriteBits(1, 1)
+	}
+	return bw.WriteBits(0, 1)
+}
+
+//Flush 以0补齐当前字节并写出，已对齐时不写入
+func (bw *BitWriter) Flush() error {
+	if bw.used == 0 {
+		return nil
+	}
+	err := bw.w.WriteByte(bw.cur)
+	bw.cur, bw.used = 0, 0
+	return err
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"bytes"
+	convey "github.com/smartystreets/goconvey/convey"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+//getBit 逐位的参考实现，取data中第i位
+func getBit(data []byte, i uint, order BitOrder) uint64 {
+	if order == MSBFirst {
+		return uint64(data[i/8]>>(7-i%8)) & 1
+	}
+	return uint64(data[i/8]>>(i%8)) & 1
+}
+
+//getBits 逐位的参考实现，从第offset位开始取n位
+func getBits(data []byte, offset, n uint, order BitOrder) (r uint64) {
+	for i := uint(0); i < n; i++ {
+		if order == MSBFirst {
+			r = r<<1 | getBit(data, offset+i, order)
+		} else {
+			r |= getBit(data, offset+i, order) << i
+		}
+	}
+	return
+}
+
+func Test_Bits(t *testing.T) {
+	convey.Convey("Bits should be packed in the chosen order", t, func() {
+		var out bytes.Buffer
+		bw := NewBitWriter(&out, MSBFirst)
+		bw.WriteBits(0x5, 3)
+		bw.WriteBits(0x1, 5)
+		bw.WriteBit(true)
+		bw.WriteBits(0xABC, 12)
+		bw.Flush()
+		convey.So(out.Bytes(), convey.ShouldResemble, []byte{0xA1, 0xD5, 0xE0})
+
+		out.Reset()
+		bw = NewBitWriter(&out, LSBFirst)
+		bw.WriteBits(0x5, 3)
+		bw.WriteBits(0x1, 5)
+		bw.WriteBit(true)
+		bw.WriteBits(0xABC, 12)
+		bw.Flush()
+		convey.So(out.Bytes(), convey.ShouldResemble, []byte{0x0D, 0x79, 0x15})
+
+		br := NewBitReader(bytes.NewReader([]byte{0xA1, 0xD5, 0xE0}), MSBFirst)
+		v, _ := br.ReadBits(3)
+		convey.So(v == 0x5, convey.ShouldBeTrue)
+		v, _ = br.ReadBits(5)
+		convey.So(v == 0x1, convey.ShouldBeTrue)
+		bit, _ := br.ReadBit()
+		convey.So(bit, convey.ShouldBeTrue)
+		v, _ = br.ReadBits(12)
+		convey.So(v == 0xABC, convey.ShouldBeTrue)
+
+		br = NewBitReader(bytes.NewReader([]byte{0x0D, 0x79, 0x15}), LSBFirst)
+		v, _ = br.ReadBits(3)
+		convey.So(v == 0x5, convey.ShouldBeTrue)
+		v, _ = br.ReadBits(5)
+		convey.So(v == 0x1, convey.ShouldBeTrue)
+		bit, _ = br.ReadBit()
+		convey.So(bit, convey.ShouldBeTrue)
+		v, _ = br.ReadBits(12)
+		convey.So(v == 0xABC, convey.ShouldBeTrue)
+	})
+
+	convey.Convey("Every width at every bit offset should round trip", t, func() {
+		rnd := rand.New(rand.NewSource(1))
+		for _, order := range []BitOrder{MSBFirst, LSBFirst} {
+			for offset := uint(0); offset < 8; offset++ {
+				for n := uint(0); n <= 64; n++ {
+					v := rnd.Uint64() & bitMask(n)
+
+					var out bytes.Buffer
+					bw := NewBitWriter(&out, order)
+					bw.WriteBits(0, offset)
+					bw.WriteBits(v, n)
+					bw.Flush()
+					convey.So(out.Len(), convey.ShouldEqual, int((offset+n+7)/8))
+					convey.So(getBits(out.Bytes(), offset, n, order) == v, convey.ShouldBeTrue)
+
+					br := NewBitReader(bytes.NewReader(out.Bytes()), order)
+					br.ReadBits(offset)
+					r, err := br.ReadBits(n)
+					convey.So(err, convey.ShouldBeNil)
+					convey.So(r == v, convey.ShouldBeTrue)
+				}
+			}
+		}
+	})
+
+	convey.Convey("Reader should match the bitwise reference on random data", t, func() {
+		rnd := rand.New(rand.NewSource(2))
+		data := make([]byte, 256)
+		rnd.Read(data)
+		for _, order := range []BitOrder{MSBFirst, LSBFirst} {
+			br := NewBitReader(bytes.NewReader(data), order)
+			offset := uint(0)
+			for {
+				n := uint(rnd.Intn(65))
+				if offset+n > uint(len(data))*8 {
+					break
+				}
+				v, err := br.ReadBits(n)
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(v == getBits(data, offset, n, order), convey.ShouldBeTrue)
+				offset += n
+			}
+		}
+	})
+
+	convey.Convey("Align should skip to the next byte", t, func() {
+		br := NewBitReader(bytes.NewReader([]byte{0xFF, 0x12}), MSBFirst)
+		br.ReadBits(3)
+		br.Align()
+		v, _ := br.ReadBits(8)
+		convey.So(v == 0x12, convey.ShouldBeTrue)
+	})
+
+	convey.Convey("Short input and bad counts should return errors", t, func() {
+		br := NewBitReader(bytes.NewReader([]byte{0xFF}), MSBFirst)
+		_, err := br.ReadBits(12)
+		convey.So(err, convey.ShouldEqual, io.ErrUnexpectedEOF)
+		_, err = br.ReadBits(1)
+		convey.So(err, convey.ShouldEqual, io.EOF)
+		_, err = br.ReadBits(65)
+		convey.So(err, convey.ShouldEqual, BitCountError)
+		convey.So(NewBitWriter(&bytes.Buffer{}, LSBFirst).WriteBits(0, 65), convey.ShouldEqual, BitCountError)
+	})
+}
+
+func Benchmark_Bits_Write12(b *testing.B) {
+	var out bytes.Buffer
+	bw := NewBitWriter(&out, MSBFirst)
+	for i := 0; i < b.N; i++ {
+		if out.Len() > 4096 {
+			out.Reset()
+		}
+		bw.WriteBits(uint64(i), 12)
+	}
+}
+
+func Benchmark_Bits_Read12(b *testing.B) {
+	data := make([]byte, 4096*3)
+	r := bytes.NewReader(data)
+	br := NewBitReader(r, MSBFirst)
+	for i := 0; i < b.N; i++ {
+		if r.Len() == 0 {
+			r.Reset(data)
+		}
+		br.ReadBits(12)
+	}
+}